@@ -1,11 +1,15 @@
 package cgroup
 
 import (
+	"errors"
+	"fmt"
 	"go_docker_learning/ganker/cgroup/subsystem"
 
 	log "github.com/sirupsen/logrus"
 )
 
+var errNoCgroup = errors.New("the container has no cgroup")
+
 type CgroupManager struct {
 	// cgroup path in the hierarchy, relative to the each root cgroup
 	Path string
@@ -13,6 +17,8 @@ type CgroupManager struct {
 	Resource *subsystem.ResourceConfig
 }
 
+// NewCgroupManager returns the manager of the cgroup in path, the manager of an empty path does nothing,
+// which is used by the containers that can't have a cgroup, like rootless containers without cgroup delegation
 func NewCgroupManager(path string) *CgroupManager {
 	return &CgroupManager{
 		Path: path,
@@ -20,8 +26,16 @@ func NewCgroupManager(path string) *CgroupManager {
 }
 
 func (c *CgroupManager) Apply(pid int) error {
+	if c.Path == "" {
+		return nil
+	}
 	for _, subSysInit := range subsystem.SubsystemsInit {
 		err := subSysInit.Apply(c.Path, pid)
+		// a controller the host doesn't have can't limit the container, Set fails if a limit of it is asked for
+		if errors.Is(err, subsystem.ErrNotMounted) {
+			log.Debugf("skip %v cgroup: %v", subSysInit.Name(), err)
+			continue
+		}
 		if err != nil {
 			log.Errorf("apply %v cgroup fail", subSysInit.Name())
 			return err
@@ -31,6 +45,9 @@ func (c *CgroupManager) Apply(pid int) error {
 }
 
 func (c *CgroupManager) Set(res *subsystem.ResourceConfig) error {
+	if c.Path == "" {
+		return nil
+	}
 	for _, subSysInit := range subsystem.SubsystemsInit {
 		err := subSysInit.Set(c.Path, res)
 		if err != nil {
@@ -41,11 +58,101 @@ func (c *CgroupManager) Set(res *subsystem.ResourceConfig) error {
 	return nil
 }
 
+// Update changes the limits of the cgroup of a running container, the device rules are left as they are,
+// since setting them again attaches one more BPF program in cgroup v2 instead of replacing the old one
+func (c *CgroupManager) Update(res *subsystem.ResourceConfig) error {
+	if c.Path == "" {
+		return nil
+	}
+	for _, subSysInit := range subsystem.SubsystemsInit {
+		if _, ok := subSysInit.(*subsystem.DevicesSubSystem); ok {
+			continue
+		}
+		if err := subSysInit.Set(c.Path, res); err != nil {
+			log.Errorf("update %v cgroup fail", subSysInit.Name())
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *CgroupManager) Delete() error {
+	if c.Path == "" {
+		return nil
+	}
 	for _, subSysInit := range subsystem.SubsystemsInit {
-		if err := subSysInit.Delete(c.Path); err != nil {
+		if err := subSysInit.Delete(c.Path); err != nil && !errors.Is(err, subsystem.ErrNotMounted) {
 			return err
 		}
 	}
 	return nil
 }
+
+// GetStats reads the resource usage of the cgroup from every subsystem having accounting files
+func (c *CgroupManager) GetStats() (*subsystem.Stats, error) {
+	if c.Path == "" {
+		return nil, errNoCgroup
+	}
+	stats := &subsystem.Stats{}
+	for _, subSysInit := range subsystem.SubsystemsInit {
+		reader, ok := subSysInit.(subsystem.StatsReader)
+		if !ok {
+			continue
+		}
+		if err := reader.GetStats(c.Path, stats); errors.Is(err, subsystem.ErrNotMounted) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("get %v cgroup stats fail: %v", subSysInit.Name(), err)
+		}
+	}
+	return stats, nil
+}
+
+// PidsStats returns the number of processes in the cgroup and the forks rejected by the pids limit
+func (c *CgroupManager) PidsStats() (*subsystem.PidsStats, error) {
+	if c.Path == "" {
+		return nil, errNoCgroup
+	}
+	stats := &subsystem.Stats{}
+	if err := (&subsystem.PidsSubSystem{}).GetStats(c.Path, stats); err != nil {
+		return nil, err
+	}
+	return &stats.Pids, nil
+}
+
+// OOMKilled reports whether the oom killer has killed any process of the cgroup
+func (c *CgroupManager) OOMKilled() bool {
+	if c.Path == "" {
+		return false
+	}
+	count, err := (&subsystem.MemorySubSystem{}).OOMKillCount(c.Path)
+	if err != nil {
+		log.Warnf("get oom kill count of %v fail: %v", c.Path, err)
+		return false
+	}
+	return count > 0
+}
+
+// NotifyOOM returns a channel which receives a value when the oom killer kills a process of the cgroup
+func (c *CgroupManager) NotifyOOM() (<-chan struct{}, error) {
+	if c.Path == "" {
+		return nil, errNoCgroup
+	}
+	return (&subsystem.MemorySubSystem{}).NotifyOOM(c.Path)
+}
+
+// Freeze suspends all processes in the cgroup
+func (c *CgroupManager) Freeze() error {
+	if c.Path == "" {
+		return errNoCgroup
+	}
+	return (&subsystem.FreezerSubSystem{}).Freeze(c.Path, true)
+}
+
+// Thaw resumes all processes in the cgroup
+func (c *CgroupManager) Thaw() error {
+	if c.Path == "" {
+		return errNoCgroup
+	}
+	return (&subsystem.FreezerSubSystem{}).Freeze(c.Path, false)
+}
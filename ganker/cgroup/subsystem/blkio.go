@@ -0,0 +1,252 @@
+package subsystem
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+type BlkioSubSystem struct{}
+
+const (
+	blkioWeight    = "blkio.weight"
+	blkioBfqWeight = "blkio.bfq.weight"
+	blkioReadBps   = "blkio.throttle.read_bps_device"
+	blkioWriteBps  = "blkio.throttle.write_bps_device"
+	blkioReadIops  = "blkio.throttle.read_iops_device"
+	blkioWriteIops = "blkio.throttle.write_iops_device"
+
+	ioWeightV2    = "io.weight"
+	ioBfqWeightV2 = "io.bfq.weight"
+	ioMaxV2       = "io.max"
+
+	blkioServiceBytes = "blkio.throttle.io_service_bytes"
+	ioStatV2          = "io.stat"
+)
+
+// the controller is called blkio in cgroup v1 and io in cgroup v2
+func (c *BlkioSubSystem) Name() string {
+	if IsCgroup2() {
+		return "io"
+	}
+	return "blkio"
+}
+
+// Set the io weight and the per device throttling of the cgroup in the cgroupPath path
+func (c *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.BlkioWeight == "" && len(res.DeviceReadBps) == 0 && len(res.DeviceWriteBps) == 0 &&
+		len(res.DeviceReadIops) == 0 && len(res.DeviceWriteIops) == 0 {
+		return nil
+	}
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+
+	if res.BlkioWeight != "" {
+		if err := c.setWeight(subsysCgroupPath, res.BlkioWeight); err != nil {
+			return err
+		}
+	}
+
+	// the key of v2 io.max, and the throttle file of v1
+	throttles := []struct {
+		key     string
+		file    string
+		devices []string
+		isBytes bool
+	}{
+		{"rbps", blkioReadBps, res.DeviceReadBps, true},
+		{"wbps", blkioWriteBps, res.DeviceWriteBps, true},
+		{"riops", blkioReadIops, res.DeviceReadIops, false},
+		{"wiops", blkioWriteIops, res.DeviceWriteIops, false},
+	}
+
+	// io.max takes all limits of a device in one line, like "8:0 rbps=1048576 wiops=100"
+	ioMax := map[string][]string{}
+	var devices []string
+	for _, throttle := range throttles {
+		for _, device := range throttle.devices {
+			number, rate, err := parseDeviceRate(device, throttle.isBytes)
+			if err != nil {
+				return err
+			}
+			if IsCgroup2() {
+				if _, ok := ioMax[number]; !ok {
+					devices = append(devices, number)
+				}
+				// a rate of 0 removes the limit, which is "max" in io.max
+				limit := strconv.FormatUint(rate, 10)
+				if rate == 0 {
+					limit = "max"
+				}
+				ioMax[number] = append(ioMax[number], throttle.key+"="+limit)
+				continue
+			}
+			// writing a rate of 0 removes the rule of the device from the throttle file
+			if err := os.WriteFile(path.Join(subsysCgroupPath, throttle.file), []byte(fmt.Sprintf("%s %d", number, rate)), 0644); err != nil {
+				return fmt.Errorf("set %s cgroup fail %v", throttle.file, err)
+			}
+		}
+	}
+	for _, number := range devices {
+		limit := number + " " + strings.Join(ioMax[number], " ")
+		if err := os.WriteFile(path.Join(subsysCgroupPath, ioMaxV2), []byte(limit), 0644); err != nil {
+			return fmt.Errorf("set %s cgroup fail %v", ioMaxV2, err)
+		}
+	}
+	return nil
+}
+
+// setWeight writes the relative weight [10-1000], the bfq file is used if the kernel doesn't have cfq
+func (c *BlkioSubSystem) setWeight(subsysCgroupPath, weight string) error {
+	value, err := strconv.ParseUint(weight, 10, 64)
+	if err != nil || value < 10 || value > 1000 {
+		return fmt.Errorf("invalid blkio weight %s, it should be in range [10-1000]", weight)
+	}
+
+	weightFile, bfqWeightFile, content := blkioWeight, blkioBfqWeight, weight
+	if IsCgroup2() {
+		// io.weight is in range [1-10000]
+		weightFile, bfqWeightFile = ioWeightV2, ioBfqWeightV2
+		content = "default " + strconv.FormatUint(1+(value-10)*9999/990, 10)
+	}
+	if _, err := os.Stat(path.Join(subsysCgroupPath, weightFile)); err != nil {
+		weightFile, content = bfqWeightFile, weight
+	}
+	if err := os.WriteFile(path.Join(subsysCgroupPath, weightFile), []byte(content), 0644); err != nil {
+		return fmt.Errorf("set %s cgroup fail %v", weightFile, err)
+	}
+	return nil
+}
+
+func (c *BlkioSubSystem) Delete(cgroupPath string) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(subsysCgroupPath); err != nil {
+		return fmt.Errorf("remove %s cgroup fail %v", c.Name(), err)
+	}
+	return nil
+}
+
+func (c *BlkioSubSystem) Apply(cgroupPath string, pid int) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
+	}
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
+		return fmt.Errorf("set %s cgroup proc fail,error:%v", c.Name(), err)
+	}
+	return nil
+}
+
+// GetStats sums up the bytes read and written on all devices
+func (c *BlkioSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+
+	statFile := blkioServiceBytes
+	if IsCgroup2() {
+		statFile = ioStatV2
+	}
+	content, err := os.ReadFile(path.Join(subsysCgroupPath, statFile))
+	if err != nil {
+		return fmt.Errorf("read %s error: %v", statFile, err)
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if IsCgroup2() {
+			// like "8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0"
+			for _, field := range fields[1:] {
+				key, value, _ := strings.Cut(field, "=")
+				number, _ := strconv.ParseUint(value, 10, 64)
+				switch key {
+				case "rbytes":
+					stats.Blkio.ReadBytes += number
+				case "wbytes":
+					stats.Blkio.WriteBytes += number
+				}
+			}
+			continue
+		}
+		// like "8:0 Read 1459200", the last line is "Total 1773973504"
+		if len(fields) != 3 {
+			continue
+		}
+		number, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			stats.Blkio.ReadBytes += number
+		case "Write":
+			stats.Blkio.WriteBytes += number
+		}
+	}
+	return nil
+}
+
+// parseDeviceRate parses "/dev/sda:1mb" into the device number "8:0" and the rate,
+// units are only allowed if the rate is in bytes
+func parseDeviceRate(device string, isBytes bool) (string, uint64, error) {
+	index := strings.LastIndex(device, ":")
+	if index <= 0 {
+		return "", 0, fmt.Errorf("invalid device rate %s, it should be like <device-path>:<rate>", device)
+	}
+	devicePath, rateStr := device[:index], device[index+1:]
+
+	var stat syscall.Stat_t
+	if err := syscall.Stat(devicePath, &stat); err != nil {
+		return "", 0, fmt.Errorf("stat device %s error: %v", devicePath, err)
+	}
+	if stat.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return "", 0, fmt.Errorf("%s is not a block device", devicePath)
+	}
+	number := fmt.Sprintf("%d:%d", syscall.Major(stat.Rdev), syscall.Minor(stat.Rdev))
+
+	var rate uint64
+	var err error
+	if isBytes {
+		rate, err = ParseBytes(rateStr)
+	} else {
+		rate, err = strconv.ParseUint(rateStr, 10, 64)
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid rate of device %s: %v", devicePath, err)
+	}
+	return number, rate, nil
+}
+
+// ParseBytes parses sizes like "512", "100k", "1mb" or "2g", the units are 1024 based
+func ParseBytes(size string) (uint64, error) {
+	size = strings.TrimSuffix(strings.ToLower(size), "b")
+	unit := uint64(1)
+	if size != "" {
+		switch size[len(size)-1] {
+		case 'k':
+			unit = 1 << 10
+		case 'm':
+			unit = 1 << 20
+		case 'g':
+			unit = 1 << 30
+		}
+		if unit != 1 {
+			size = size[:len(size)-1]
+		}
+	}
+	value, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return value * unit, nil
+}
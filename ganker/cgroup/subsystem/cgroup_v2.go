@@ -0,0 +1,108 @@
+package subsystem
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	processIdPathV2    = "cgroup.procs"
+	controllersFile    = "cgroup.controllers"
+	subtreeControlFile = "cgroup.subtree_control"
+)
+
+var (
+	unifiedOnce       sync.Once
+	unifiedMountPoint string
+)
+
+// IsCgroup2 reports whether the host only mounts the unified (cgroup v2) hierarchy
+func IsCgroup2() bool {
+	unifiedOnce.Do(func() {
+		unifiedMountPoint = findUnifiedMountPoint()
+	})
+	return unifiedMountPoint != ""
+}
+
+// findUnifiedMountPoint returns the mount point of cgroup2,
+// it returns "" if any v1 hierarchy is mounted, as the host is then running in legacy or hybrid mode
+func findUnifiedMountPoint() string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	mountPoint := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		// the optional fields end with a "-", the fs type follows it
+		for i := 6; i < len(fields)-1; i++ {
+			if fields[i] != "-" {
+				continue
+			}
+			switch fields[i+1] {
+			case "cgroup":
+				return ""
+			case "cgroup2":
+				if mountPoint == "" {
+					mountPoint = fields[4]
+				}
+			}
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return ""
+	}
+	return mountPoint
+}
+
+// enableController writes "+controller" into the cgroup.subtree_control of every ancestor of cgroupPath,
+// so that the controller's interface files show up in cgroupPath
+func enableController(controller, cgroupPath string) error {
+	// some "subsystems", like freezer, are core files of every cgroup and need no controller
+	if !hasController(path.Join(unifiedMountPoint, controllersFile), controller) {
+		return nil
+	}
+
+	current := unifiedMountPoint
+	for _, dir := range strings.Split(strings.Trim(path.Clean(cgroupPath), "/"), "/") {
+		subtreeControl := path.Join(current, subtreeControlFile)
+		if !hasController(subtreeControl, controller) {
+			if err := os.WriteFile(subtreeControl, []byte("+"+controller), 0644); err != nil {
+				return fmt.Errorf("enable %s controller in %s error: %v", controller, current, err)
+			}
+		}
+		current = path.Join(current, dir)
+	}
+	return nil
+}
+
+// hasController checks if the controller is listed in a cgroup.controllers or cgroup.subtree_control file
+func hasController(file, controller string) bool {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	for _, c := range strings.Fields(string(content)) {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// convertUnlimited converts the v1 "-1" (no limit) into the v2 "max"
+func convertUnlimited(value string) string {
+	if value == "-1" {
+		return "max"
+	}
+	return value
+}
@@ -4,13 +4,13 @@ import (
 	"fmt"
 	"os"
 	"path"
-	"strconv"
 )
 
 type CpuQuotaSubSystem struct{}
 
 const (
-	cpuQuotaLimit = "cpu.cfs_quota_us"
+	cpuQuotaLimit   = "cpu.cfs_quota_us"
+	cpuQuotaLimitV2 = "cpu.max"
 )
 
 func (c *CpuQuotaSubSystem) Name() string {
@@ -18,13 +18,20 @@ func (c *CpuQuotaSubSystem) Name() string {
 }
 
 func (c *CpuQuotaSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.CpuQuota == "" {
+		return nil
+	}
 	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
 	if err != nil {
 		return err
 	}
-	// Write the cpu.cfs_quota_us file
-	if err := os.WriteFile(path.Join(subsysCgroupPath, cpuQuotaLimit), []byte(res.CpuQuota), 0644); err != nil {
-		return fmt.Errorf("set %s cgroup fail %v", cpuQuotaLimit, err)
+	// Write the cpu.cfs_quota_us file, or the quota part of cpu.max in cgroup v2
+	quotaFile, quota := cpuQuotaLimit, res.CpuQuota
+	if IsCgroup2() {
+		quotaFile, quota = cpuQuotaLimitV2, convertUnlimited(res.CpuQuota)
+	}
+	if err := os.WriteFile(path.Join(subsysCgroupPath, quotaFile), []byte(quota), 0644); err != nil {
+		return fmt.Errorf("set %s cgroup fail %v", quotaFile, err)
 	}
 	return nil
 }
@@ -43,9 +50,9 @@ func (c *CpuQuotaSubSystem) Delete(cgroupPath string) error {
 func (c *CpuQuotaSubSystem) Apply(cgroupPath string, pid int) error {
 	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
 	if err != nil {
-		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
 	}
-	if err = os.WriteFile(path.Join(subsysCgroupPath, processIdPath), []byte(strconv.Itoa(pid)), 0644); err != nil {
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
 		return fmt.Errorf("set %s cgroup proc fail,error:%v", cpuQuotaLimit, err)
 	}
 	return nil
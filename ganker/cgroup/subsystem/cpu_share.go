@@ -9,21 +9,33 @@ import (
 
 type CpuShareSubSystem struct{}
 
-const cpuShareLimit = "cpu.shares"
+const (
+	cpuShareLimit   = "cpu.shares"
+	cpuShareLimitV2 = "cpu.weight"
+)
 
 func (c *CpuShareSubSystem) Name() string {
 	return "cpu"
 }
 
 func (c *CpuShareSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.CpuShare == "" {
+		return nil
+	}
 	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
 	if err != nil {
 		return err
 	}
-	// Write the cpu.cfs_quota_us file
-	if err := os.WriteFile(path.Join(subsysCgroupPath, cpuShareLimit), []byte(res.CpuShare), 0644); err != nil {
-		fmt.Println("error happen", res.CpuShare)
-		return fmt.Errorf("set %s cgroup fail %v", cpuShareLimit, err)
+	// Write the cpu.shares file, or cpu.weight in cgroup v2
+	shareFile, share := cpuShareLimit, res.CpuShare
+	if IsCgroup2() {
+		shareFile = cpuShareLimitV2
+		if share, err = convertCpuSharesToWeight(res.CpuShare); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path.Join(subsysCgroupPath, shareFile), []byte(share), 0644); err != nil {
+		return fmt.Errorf("set %s cgroup fail %v", shareFile, err)
 	}
 	return nil
 }
@@ -39,12 +51,24 @@ func (c *CpuShareSubSystem) Delete(cgroupPath string) error {
 	return nil
 }
 
+// convertCpuSharesToWeight maps cpu.shares [2-262144] onto cpu.weight [1-10000]
+func convertCpuSharesToWeight(shares string) (string, error) {
+	value, err := strconv.ParseUint(shares, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid cpu shares %s: %v", shares, err)
+	}
+	if value < 2 {
+		value = 2
+	}
+	return strconv.FormatUint(1+((value-2)*9999)/262142, 10), nil
+}
+
 func (c *CpuShareSubSystem) Apply(cgroupPath string, pid int) error {
 	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
 	if err != nil {
-		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
 	}
-	if err = os.WriteFile(path.Join(subsysCgroupPath, processIdPath), []byte(strconv.Itoa(pid)), 0644); err != nil {
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
 		return fmt.Errorf("set %s cgroup proc fail,error:%v", cpuShareLimit, err)
 	}
 	return nil
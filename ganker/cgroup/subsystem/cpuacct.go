@@ -0,0 +1,69 @@
+package subsystem
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// CpuacctSubSystem only does the cpu accounting, it has no limit to set
+type CpuacctSubSystem struct{}
+
+const (
+	cpuacctUsage = "cpuacct.usage"
+	cpuStatV2    = "cpu.stat"
+)
+
+// the accounting is part of the cpu controller in cgroup v2
+func (c *CpuacctSubSystem) Name() string {
+	if IsCgroup2() {
+		return "cpu"
+	}
+	return "cpuacct"
+}
+
+func (c *CpuacctSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+func (c *CpuacctSubSystem) Delete(cgroupPath string) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(subsysCgroupPath); err != nil {
+		return fmt.Errorf("remove %s cgroup fail %v", c.Name(), err)
+	}
+	return nil
+}
+
+func (c *CpuacctSubSystem) Apply(cgroupPath string, pid int) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
+	}
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
+		return fmt.Errorf("set %s cgroup proc fail,error:%v", c.Name(), err)
+	}
+	return nil
+}
+
+// GetStats reads the total cpu time from cpuacct.usage, or the usage_usec of cpu.stat in cgroup v2
+func (c *CpuacctSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+
+	if !IsCgroup2() {
+		stats.Cpu.TotalUsage, err = readUint(path.Join(subsysCgroupPath, cpuacctUsage))
+		return err
+	}
+
+	cpuStat, err := readKeyValues(path.Join(subsysCgroupPath, cpuStatV2))
+	if err != nil {
+		return err
+	}
+	stats.Cpu.TotalUsage = cpuStat["usage_usec"] * 1000
+	return nil
+}
@@ -0,0 +1,174 @@
+package subsystem
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type CpusetSubSystem struct{}
+
+const (
+	cpusetCpus = "cpuset.cpus"
+	cpusetMems = "cpuset.mems"
+
+	cpusetEffectiveCpus   = "cpuset.effective_cpus"
+	cpusetEffectiveMems   = "cpuset.effective_mems"
+	cpusetEffectiveCpusV2 = "cpuset.cpus.effective"
+	cpusetEffectiveMemsV2 = "cpuset.mems.effective"
+)
+
+func (c *CpusetSubSystem) Name() string {
+	return "cpuset"
+}
+
+// Set pins the cgroup to res.Cpus and res.Mems, the cgroup inherits the parent's cpuset if they are empty
+func (c *CpusetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	// the v1 cpuset is filled below even if nothing is asked for, a host without it has nothing to fill
+	if errors.Is(err, ErrNotMounted) && res.Cpus == "" && res.Mems == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// a v1 cpuset is created empty, and no task can be attached to it until both files are filled
+	if !IsCgroup2() {
+		if err := initCpuset(FindCgroupMountPoint(c.Name()), cgroupPath); err != nil {
+			return err
+		}
+	}
+
+	effectiveCpus, effectiveMems := cpusetEffectiveCpus, cpusetEffectiveMems
+	if IsCgroup2() {
+		effectiveCpus, effectiveMems = cpusetEffectiveCpusV2, cpusetEffectiveMemsV2
+	}
+
+	parent := path.Dir(subsysCgroupPath)
+	for _, limit := range []struct {
+		file      string
+		effective string
+		value     string
+	}{
+		{cpusetCpus, effectiveCpus, res.Cpus},
+		{cpusetMems, effectiveMems, res.Mems},
+	} {
+		if limit.value == "" {
+			continue
+		}
+		if err := checkCpusetRange(path.Join(parent, limit.effective), path.Join(parent, limit.file), limit.value); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path.Join(subsysCgroupPath, limit.file), []byte(limit.value), 0644); err != nil {
+			return fmt.Errorf("set %s cgroup fail %v", limit.file, err)
+		}
+	}
+	return nil
+}
+
+func (c *CpusetSubSystem) Delete(cgroupPath string) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(subsysCgroupPath); err != nil {
+		return fmt.Errorf("remove %s cgroup fail %v", c.Name(), err)
+	}
+	return nil
+}
+
+func (c *CpusetSubSystem) Apply(cgroupPath string, pid int) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
+	}
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
+		return fmt.Errorf("set %s cgroup proc fail,error:%v", c.Name(), err)
+	}
+	return nil
+}
+
+// initCpuset fills every empty cpuset between the hierarchy root and cgroupPath with the cpus and mems of its parent
+func initCpuset(root, cgroupPath string) error {
+	parent := root
+	for _, dir := range strings.Split(strings.Trim(path.Clean(cgroupPath), "/"), "/") {
+		current := path.Join(parent, dir)
+		for _, file := range []string{cpusetCpus, cpusetMems} {
+			content, err := os.ReadFile(path.Join(current, file))
+			if err != nil {
+				return fmt.Errorf("read %s of %s error: %v", file, current, err)
+			}
+			if strings.TrimSpace(string(content)) != "" {
+				continue
+			}
+			parentContent, err := os.ReadFile(path.Join(parent, file))
+			if err != nil {
+				return fmt.Errorf("read %s of %s error: %v", file, parent, err)
+			}
+			if err := os.WriteFile(path.Join(current, file), parentContent, 0644); err != nil {
+				return fmt.Errorf("inherit %s from %s error: %v", file, parent, err)
+			}
+		}
+		parent = current
+	}
+	return nil
+}
+
+// checkCpusetRange makes sure every cpu or memory node of value is in the effective cpuset of the parent,
+// fallback is read instead if the kernel doesn't expose the effective file
+func checkCpusetRange(effective, fallback, value string) error {
+	requested, err := parseCpusetList(value)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(effective)
+	if err != nil {
+		if content, err = os.ReadFile(fallback); err != nil {
+			return fmt.Errorf("read parent cpuset %s error: %v", fallback, err)
+		}
+	}
+	available, err := parseCpusetList(strings.TrimSpace(string(content)))
+	if err != nil {
+		return err
+	}
+
+	for id := range requested {
+		if _, ok := available[id]; !ok {
+			return fmt.Errorf("cpuset %s is out of the range %s available", value, strings.TrimSpace(string(content)))
+		}
+	}
+	return nil
+}
+
+// parseCpusetList parses the cpuset list format, like "0-3,5,7-8"
+func parseCpusetList(list string) (map[int]struct{}, error) {
+	ids := map[int]struct{}{}
+	if list == "" {
+		return ids, nil
+	}
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpuset %s: %v", list, err)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid cpuset %s: %v", list, err)
+			}
+		}
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("invalid cpuset %s", list)
+		}
+		for id := start; id <= end; id++ {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids, nil
+}
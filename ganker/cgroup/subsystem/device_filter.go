@@ -0,0 +1,163 @@
+package subsystem
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// bpfInsn is an eBPF instruction, regs holds the destination register in the low 4 bits and the source in the high 4 bits
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+// bpfProgLoadAttr is the head of union bpf_attr used by BPF_PROG_LOAD, the fields after it are left zero
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// bpfProgAttachAttr is the head of union bpf_attr used by BPF_PROG_ATTACH
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+const (
+	bpfLdxW   = syscall.BPF_LDX | syscall.BPF_W | syscall.BPF_MEM
+	bpfAnd32  = syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K
+	bpfRsh32  = syscall.BPF_ALU | syscall.BPF_RSH | syscall.BPF_K
+	bpfMov32X = syscall.BPF_ALU | syscall.BPF_MOV | syscall.BPF_X
+	bpfMov64  = syscall.BPF_ALU64 | syscall.BPF_MOV | syscall.BPF_K
+	bpfJne    = syscall.BPF_JMP | syscall.BPF_JNE | syscall.BPF_K
+	bpfExit   = syscall.BPF_JMP | syscall.BPF_EXIT
+
+	// bpfJumpNext marks a jump to the next rule, its offset is filled in when the rule is done
+	bpfJumpNext = -1
+)
+
+// deviceFilter compiles the rules into an eBPF program of type BPF_PROG_TYPE_CGROUP_DEVICE,
+// it returns 1 (allow) if the access matches any rule, otherwise 0 (deny).
+// the context is struct bpf_cgroup_dev_ctx { u32 access_type; u32 major; u32 minor; },
+// access_type has the device type in its low 16 bits and the access in its high 16 bits
+func deviceFilter(rules []DeviceRule) ([]bpfInsn, error) {
+	insns := []bpfInsn{
+		{code: bpfLdxW, regs: 2 | 1<<4, off: 0}, // r2 = type
+		{code: bpfAnd32, regs: 2, imm: 0xffff},
+		{code: bpfLdxW, regs: 3 | 1<<4, off: 0}, // r3 = access
+		{code: bpfRsh32, regs: 3, imm: 16},
+		{code: bpfLdxW, regs: 4 | 1<<4, off: 4}, // r4 = major
+		{code: bpfLdxW, regs: 5 | 1<<4, off: 8}, // r5 = minor
+	}
+
+	for _, rule := range rules {
+		var block []bpfInsn
+		switch rule.Type {
+		case 'c':
+			block = append(block, bpfInsn{code: bpfJne, regs: 2, off: bpfJumpNext, imm: syscall.BPF_DEVCG_DEV_CHAR})
+		case 'b':
+			block = append(block, bpfInsn{code: bpfJne, regs: 2, off: bpfJumpNext, imm: syscall.BPF_DEVCG_DEV_BLOCK})
+		case 'a':
+		default:
+			return nil, fmt.Errorf("invalid device type %c", rule.Type)
+		}
+
+		allowed := int32(0)
+		for _, a := range rule.Access {
+			switch a {
+			case 'r':
+				allowed |= syscall.BPF_DEVCG_ACC_READ
+			case 'w':
+				allowed |= syscall.BPF_DEVCG_ACC_WRITE
+			case 'm':
+				allowed |= syscall.BPF_DEVCG_ACC_MKNOD
+			default:
+				return nil, fmt.Errorf("invalid device access %s", rule.Access)
+			}
+		}
+		// the rule doesn't match if any access out of the allowed ones is asked
+		if denied := ^allowed & (syscall.BPF_DEVCG_ACC_READ | syscall.BPF_DEVCG_ACC_WRITE | syscall.BPF_DEVCG_ACC_MKNOD); denied != 0 {
+			block = append(block,
+				bpfInsn{code: bpfMov32X, regs: 6 | 3<<4},
+				bpfInsn{code: bpfAnd32, regs: 6, imm: denied},
+				bpfInsn{code: bpfJne, regs: 6, off: bpfJumpNext, imm: 0},
+			)
+		}
+		if rule.Major != -1 {
+			block = append(block, bpfInsn{code: bpfJne, regs: 4, off: bpfJumpNext, imm: int32(rule.Major)})
+		}
+		if rule.Minor != -1 {
+			block = append(block, bpfInsn{code: bpfJne, regs: 5, off: bpfJumpNext, imm: int32(rule.Minor)})
+		}
+		block = append(block, bpfInsn{code: bpfMov64, regs: 0, imm: 1}, bpfInsn{code: bpfExit})
+
+		// a jump offset is relative to the instruction after the jump
+		for i := range block {
+			if block[i].code == bpfJne && block[i].off == bpfJumpNext {
+				block[i].off = int16(len(block) - i - 1)
+			}
+		}
+		insns = append(insns, block...)
+	}
+	return append(insns, bpfInsn{code: bpfMov64, regs: 0, imm: 0}, bpfInsn{code: bpfExit}), nil
+}
+
+// attachDeviceFilter loads the device filter of the rules and attaches it to the v2 cgroup in subsysCgroupPath,
+// the program stays attached until the cgroup is removed
+func attachDeviceFilter(subsysCgroupPath string, rules []DeviceRule) error {
+	insns, err := deviceFilter(rules)
+	if err != nil {
+		return err
+	}
+
+	license := []byte("GPL\x00")
+	verifierLog := make([]byte, 64*1024)
+	loadAttr := bpfProgLoadAttr{
+		progType: syscall.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(verifierLog)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&verifierLog[0]))),
+	}
+	progFd, _, errno := syscall.Syscall(syscall.SYS_BPF, syscall.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&loadAttr)), unsafe.Sizeof(loadAttr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		return fmt.Errorf("load device filter error: %v, %s", errno, syscall.ByteSliceToString(verifierLog))
+	}
+	defer syscall.Close(int(progFd))
+
+	cgroupFd, err := syscall.Open(subsysCgroupPath, syscall.O_DIRECTORY|syscall.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open cgroup %s error: %v", subsysCgroupPath, err)
+	}
+	defer syscall.Close(cgroupFd)
+
+	// the programs of the ancestors, like the ones of systemd, still run with BPF_F_ALLOW_MULTI
+	attachAttr := bpfProgAttachAttr{
+		targetFd:    uint32(cgroupFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  syscall.BPF_CGROUP_DEVICE,
+		attachFlags: syscall.BPF_F_ALLOW_MULTI,
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_BPF, syscall.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attachAttr)), unsafe.Sizeof(attachAttr)); errno != 0 {
+		return fmt.Errorf("attach device filter to %s error: %v", subsysCgroupPath, errno)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package subsystem
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type DevicesSubSystem struct{}
+
+const (
+	devicesAllow = "devices.allow"
+	devicesDeny  = "devices.deny"
+)
+
+// DeviceRule allows the access to a device, or to all devices of a type if Major and Minor are -1
+type DeviceRule struct {
+	Type   rune   `json:"type"`   // 'c' for char devices, 'b' for block devices, 'a' for both
+	Major  int64  `json:"major"`  // -1 matches any major number
+	Minor  int64  `json:"minor"`  // -1 matches any minor number
+	Access string `json:"access"` // some of 'r' (read), 'w' (write) and 'm' (mknod)
+}
+
+// String formats the rule like the v1 devices.allow, like "c 1:3 rwm" or "c 136:* rwm"
+func (r DeviceRule) String() string {
+	number := func(n int64) string {
+		if n == -1 {
+			return "*"
+		}
+		return strconv.FormatInt(n, 10)
+	}
+	return fmt.Sprintf("%c %s:%s %s", r.Type, number(r.Major), number(r.Minor), r.Access)
+}
+
+func (c *DevicesSubSystem) Name() string {
+	return "devices"
+}
+
+// Set denies all devices to the cgroup in the cgroupPath path except the ones in res.Devices,
+// no device is denied if res.Devices is nil. cgroup v2 has no devices controller, the rules are
+// checked by an eBPF program attached to the cgroup instead
+func (c *DevicesSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.Devices == nil {
+		return nil
+	}
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+
+	if IsCgroup2() {
+		return attachDeviceFilter(subsysCgroupPath, res.Devices)
+	}
+
+	if err := os.WriteFile(path.Join(subsysCgroupPath, devicesDeny), []byte("a"), 0644); err != nil {
+		return fmt.Errorf("set %s cgroup fail %v", devicesDeny, err)
+	}
+	for _, rule := range res.Devices {
+		// the kernel only takes one rule per write
+		if err := os.WriteFile(path.Join(subsysCgroupPath, devicesAllow), []byte(rule.String()), 0644); err != nil {
+			return fmt.Errorf("set %s cgroup %s fail %v", devicesAllow, rule, err)
+		}
+	}
+	return nil
+}
+
+func (c *DevicesSubSystem) Delete(cgroupPath string) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(subsysCgroupPath); err != nil {
+		return fmt.Errorf("remove %s cgroup fail %v", c.Name(), err)
+	}
+	return nil
+}
+
+func (c *DevicesSubSystem) Apply(cgroupPath string, pid int) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
+	}
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
+		return fmt.Errorf("set %s cgroup proc fail,error:%v", c.Name(), err)
+	}
+	return nil
+}
+
+// ParseDeviceAccess checks the access of a device, like "rwm" or "r", the empty access is "rwm"
+func ParseDeviceAccess(access string) (string, error) {
+	if access == "" {
+		return "rwm", nil
+	}
+	for _, a := range access {
+		if !strings.ContainsRune("rwm", a) || strings.Count(access, string(a)) > 1 {
+			return "", fmt.Errorf("invalid device access %s, it should be made of r, w and m", access)
+		}
+	}
+	return access, nil
+}
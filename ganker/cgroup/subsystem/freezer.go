@@ -0,0 +1,94 @@
+package subsystem
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type FreezerSubSystem struct{}
+
+const (
+	freezerState    = "freezer.state"
+	cgroupFreezeV2  = "cgroup.freeze"
+	cgroupEventsV2  = "cgroup.events"
+	freezerFrozen   = "FROZEN"
+	freezerThawed   = "THAWED"
+	freezerRetries  = 1000
+	freezerInterval = 10 * time.Millisecond
+)
+
+// the freezer is a core file of every cgroup in v2, so it needs no controller
+func (c *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+// the freezer has no limit, it is changed by Freeze
+func (c *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	return nil
+}
+
+func (c *FreezerSubSystem) Delete(cgroupPath string) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(subsysCgroupPath); err != nil {
+		return fmt.Errorf("remove %s cgroup fail %v", c.Name(), err)
+	}
+	return nil
+}
+
+func (c *FreezerSubSystem) Apply(cgroupPath string, pid int) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
+	}
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
+		return fmt.Errorf("set %s cgroup proc fail,error:%v", c.Name(), err)
+	}
+	return nil
+}
+
+// Freeze suspends (frozen is true) or resumes all processes of the cgroup,
+// it waits until the kernel reports the cgroup is in the new state
+func (c *FreezerSubSystem) Freeze(cgroupPath string, frozen bool) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+
+	// v1 takes FROZEN or THAWED, v2 takes 1 or 0 and reports "frozen 1" in cgroup.events when it's done
+	stateFile, state, checkFile, check := freezerState, freezerThawed, freezerState, freezerThawed
+	if frozen {
+		state, check = freezerFrozen, freezerFrozen
+	}
+	if IsCgroup2() {
+		stateFile, state, checkFile, check = cgroupFreezeV2, "0", cgroupEventsV2, "frozen 0"
+		if frozen {
+			state, check = "1", "frozen 1"
+		}
+	}
+
+	for i := 0; i < freezerRetries; i++ {
+		// a v1 cgroup may get stuck in FREEZING, writing the state again makes the kernel retry
+		if i%50 == 0 {
+			if err := os.WriteFile(path.Join(subsysCgroupPath, stateFile), []byte(state), 0644); err != nil {
+				return fmt.Errorf("set %s cgroup fail %v", stateFile, err)
+			}
+		}
+		content, err := os.ReadFile(path.Join(subsysCgroupPath, checkFile))
+		if err != nil {
+			return fmt.Errorf("read %s error: %v", checkFile, err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.TrimSpace(line) == check {
+				return nil
+			}
+		}
+		time.Sleep(freezerInterval)
+	}
+	return fmt.Errorf("wait for cgroup %s to be %s timeout", cgroupPath, check)
+}
@@ -1,16 +1,26 @@
 package subsystem
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"path"
-	"strconv"
+
+	syscall "golang.org/x/sys/unix"
 )
 
 type MemorySubSystem struct{}
 
 const (
-	memoryLimit = "memory.limit_in_bytes"
+	memoryLimit   = "memory.limit_in_bytes"
+	memoryLimitV2 = "memory.max"
+	memoryUsage   = "memory.usage_in_bytes"
+	memoryUsageV2 = "memory.current"
+	memoryStat    = "memory.stat"
+
+	memoryOomControl   = "memory.oom_control"
+	memoryEventsV2     = "memory.events"
+	cgroupEventControl = "cgroup.event_control"
 )
 
 // Get the name of the subsystem
@@ -20,14 +30,21 @@ func (c *MemorySubSystem) Name() string {
 
 // Set the memory limit of the cgroup in the cgrouPath path
 func (c *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.Memory == "" {
+		return nil
+	}
 	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
 	if err != nil {
 		return err
 	}
 
-	// Write the memory.limit_in_bytes file
-	if err := os.WriteFile(path.Join(subsysCgroupPath, memoryLimit), []byte(res.Memory), 0644); err != nil {
-		return fmt.Errorf("set %s cgroup fail %v", memoryLimit, err)
+	// Write the memory.limit_in_bytes file, or memory.max in cgroup v2
+	limitFile, limit := memoryLimit, res.Memory
+	if IsCgroup2() {
+		limitFile, limit = memoryLimitV2, convertUnlimited(res.Memory)
+	}
+	if err := os.WriteFile(path.Join(subsysCgroupPath, limitFile), []byte(limit), 0644); err != nil {
+		return fmt.Errorf("set %s cgroup fail %v", limitFile, err)
 	}
 
 	return nil
@@ -49,10 +66,156 @@ func (c *MemorySubSystem) Delete(cgroupPath string) error {
 func (c *MemorySubSystem) Apply(cgroupPath string, pid int) error {
 	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
 	if err != nil {
-		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
 	}
-	if err = os.WriteFile(path.Join(subsysCgroupPath, processIdPath), []byte(strconv.Itoa(pid)), 0644); err != nil {
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
 		return fmt.Errorf("set %s cgroup proc fail,error:%v", memoryLimit, err)
 	}
 	return nil
 }
+
+// GetStats reads the memory usage and limit, the inactive page cache is taken out of the usage
+func (c *MemorySubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+
+	// v1 reports the hierarchical cache as total_inactive_file, v2 as inactive_file
+	usageFile, limitFile, cacheKey := memoryUsage, memoryLimit, "total_inactive_file"
+	if IsCgroup2() {
+		usageFile, limitFile, cacheKey = memoryUsageV2, memoryLimitV2, "inactive_file"
+	}
+
+	usage, err := readUint(path.Join(subsysCgroupPath, usageFile))
+	if err != nil {
+		return err
+	}
+	memStat, err := readKeyValues(path.Join(subsysCgroupPath, memoryStat))
+	if err != nil {
+		return err
+	}
+	stats.Memory.Cache = memStat[cacheKey]
+	stats.Memory.Usage = usage
+	if usage > stats.Memory.Cache {
+		stats.Memory.Usage = usage - stats.Memory.Cache
+	}
+
+	// memory.max is "max" if there's no limit
+	if limit, err := readUint(path.Join(subsysCgroupPath, limitFile)); err == nil {
+		stats.Memory.Limit = limit
+	}
+	return nil
+}
+
+// OOMKillCount returns how many processes of the cgroup were killed by the oom killer,
+// it is the oom_kill field of memory.oom_control, or memory.events in cgroup v2
+func (c *MemorySubSystem) OOMKillCount(cgroupPath string) (uint64, error) {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return 0, err
+	}
+
+	eventsFile := memoryOomControl
+	if IsCgroup2() {
+		eventsFile = memoryEventsV2
+	}
+	events, err := readKeyValues(path.Join(subsysCgroupPath, eventsFile))
+	if err != nil {
+		return 0, err
+	}
+	return events["oom_kill"], nil
+}
+
+// NotifyOOM returns a channel which receives a value every time the oom killer is invoked in the cgroup.
+// v1 registers an eventfd on memory.oom_control, v2 watches memory.events with inotify
+func (c *MemorySubSystem) NotifyOOM(cgroupPath string) (<-chan struct{}, error) {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	if IsCgroup2() {
+		return c.notifyOOMV2(cgroupPath, subsysCgroupPath)
+	}
+
+	eventFd, err := syscall.Eventfd(0, syscall.EFD_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("create eventfd error: %v", err)
+	}
+	oomControl, err := os.Open(path.Join(subsysCgroupPath, memoryOomControl))
+	if err != nil {
+		syscall.Close(eventFd)
+		return nil, fmt.Errorf("open %s error: %v", memoryOomControl, err)
+	}
+
+	// "<event_fd> <fd of memory.oom_control>" asks the kernel to signal the eventfd on oom
+	register := fmt.Sprintf("%d %d", eventFd, oomControl.Fd())
+	if err := os.WriteFile(path.Join(subsysCgroupPath, cgroupEventControl), []byte(register), 0644); err != nil {
+		syscall.Close(eventFd)
+		oomControl.Close()
+		return nil, fmt.Errorf("register oom event error: %v", err)
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			syscall.Close(eventFd)
+			oomControl.Close()
+			close(ch)
+		}()
+		buf := make([]byte, 8)
+		for {
+			if _, err := syscall.Read(eventFd, buf); err != nil {
+				return
+			}
+			// the eventfd is also signaled when the cgroup is removed
+			if _, err := os.Stat(subsysCgroupPath); err != nil || binary.LittleEndian.Uint64(buf) == 0 {
+				return
+			}
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// notifyOOMV2 watches memory.events and sends to the channel when its oom_kill counter grows
+func (c *MemorySubSystem) notifyOOMV2(cgroupPath, subsysCgroupPath string) (<-chan struct{}, error) {
+	inotifyFd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("create inotify error: %v", err)
+	}
+	if _, err := syscall.InotifyAddWatch(inotifyFd, path.Join(subsysCgroupPath, memoryEventsV2), syscall.IN_MODIFY); err != nil {
+		syscall.Close(inotifyFd)
+		return nil, fmt.Errorf("watch %s error: %v", memoryEventsV2, err)
+	}
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			syscall.Close(inotifyFd)
+			close(ch)
+		}()
+		var last uint64
+		buf := make([]byte, syscall.SizeofInotifyEvent+syscall.PathMax+1)
+		for {
+			if _, err := syscall.Read(inotifyFd, buf); err != nil {
+				return
+			}
+			count, err := c.OOMKillCount(cgroupPath)
+			if err != nil {
+				return
+			}
+			if count > last {
+				last = count
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,91 @@
+package subsystem
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+type PidsSubSystem struct{}
+
+const (
+	pidsLimit   = "pids.max"
+	pidsCurrent = "pids.current"
+	pidsEvents  = "pids.events"
+)
+
+// PidsStats is the process accounting of a cgroup
+type PidsStats struct {
+	Current  uint64 `json:"current"`  // number of processes in the cgroup
+	Limit    string `json:"limit"`    // content of pids.max
+	Rejected uint64 `json:"rejected"` // number of forks rejected by pids.max
+}
+
+func (c *PidsSubSystem) Name() string {
+	return "pids"
+}
+
+// Set the max number of processes of the cgroup in the cgroupPath path
+func (c *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	if res.PidsLimit == "" {
+		return nil
+	}
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	// pids.max takes "max" as no limit in both v1 and v2
+	if err := os.WriteFile(path.Join(subsysCgroupPath, pidsLimit), []byte(convertUnlimited(res.PidsLimit)), 0644); err != nil {
+		return fmt.Errorf("set %s cgroup fail %v", pidsLimit, err)
+	}
+	return nil
+}
+
+func (c *PidsSubSystem) Delete(cgroupPath string) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(subsysCgroupPath); err != nil {
+		return fmt.Errorf("remove %s cgroup fail %v", pidsLimit, err)
+	}
+	return nil
+}
+
+func (c *PidsSubSystem) Apply(cgroupPath string, pid int) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %w", cgroupPath, err)
+	}
+	if err = attachProcess(subsysCgroupPath, pid); err != nil {
+		return fmt.Errorf("set %s cgroup proc fail,error:%v", pidsLimit, err)
+	}
+	return nil
+}
+
+// GetStats reads pids.current, pids.max and the "max" counter of pids.events
+func (c *PidsSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	subsysCgroupPath, err := FindCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+
+	if stats.Pids.Current, err = readUint(path.Join(subsysCgroupPath, pidsCurrent)); err != nil {
+		return err
+	}
+
+	limit, err := os.ReadFile(path.Join(subsysCgroupPath, pidsLimit))
+	if err != nil {
+		return fmt.Errorf("read %s error: %v", pidsLimit, err)
+	}
+	stats.Pids.Limit = strings.TrimSpace(string(limit))
+
+	// pids.events only has one line, like "max 3"
+	events, err := readKeyValues(path.Join(subsysCgroupPath, pidsEvents))
+	if err != nil {
+		return err
+	}
+	stats.Pids.Rejected = events["max"]
+	return nil
+}
@@ -1,11 +1,23 @@
 package subsystem
 
+// processIdPath is the process list of a v1 cgroup, cgroup v2 uses cgroup.procs instead
 const processIdPath = "tasks"
 
 type ResourceConfig struct {
-	Memory   string // Memory limit
-	CpuShare string // CPU time slice allocation
-	CpuQuota string // scheduled CPU quota by cfs
+	Memory    string // Memory limit
+	CpuShare  string // CPU time slice allocation
+	CpuQuota  string // scheduled CPU quota by cfs
+	Cpus      string // CPUs the processes can run on, like "0-3,5"
+	Mems      string // NUMA memory nodes the processes can allocate from
+	PidsLimit string // max number of processes, "-1" means no limit, the pids cgroup isn't set if it's empty
+
+	BlkioWeight     string   // relative block io weight, in range [10-1000]
+	DeviceReadBps   []string // read rate of devices in bytes per second, like "/dev/sda:1mb"
+	DeviceWriteBps  []string // write rate of devices in bytes per second
+	DeviceReadIops  []string // read rate of devices in io per second, like "/dev/sda:100"
+	DeviceWriteIops []string // write rate of devices in io per second
+
+	Devices []DeviceRule // devices the processes can access, all devices are allowed if it's nil
 }
 
 type Subsystem interface {
@@ -21,4 +33,10 @@ var SubsystemsInit = []Subsystem{
 	&MemorySubSystem{},
 	&CpuShareSubSystem{},
 	&CpuQuotaSubSystem{},
+	&CpusetSubSystem{},
+	&PidsSubSystem{},
+	&BlkioSubSystem{},
+	&CpuacctSubSystem{},
+	&FreezerSubSystem{},
+	&DevicesSubSystem{},
 }
@@ -0,0 +1,32 @@
+package subsystem
+
+// Stats is the resource usage of a cgroup, read from the accounting files of each subsystem
+type Stats struct {
+	Cpu    CpuStats    `json:"cpu"`
+	Memory MemoryStats `json:"memory"`
+	Pids   PidsStats   `json:"pids"`
+	Blkio  BlkioStats  `json:"blkio"`
+}
+
+// CpuStats is the cpu time consumed by the cgroup
+type CpuStats struct {
+	TotalUsage uint64 `json:"total_usage"` // in nanoseconds
+}
+
+// MemoryStats is the memory used by the cgroup
+type MemoryStats struct {
+	Usage uint64 `json:"usage"` // in bytes, inactive page cache excluded
+	Cache uint64 `json:"cache"` // inactive page cache, it can be reclaimed at any time
+	Limit uint64 `json:"limit"` // 0 means no limit
+}
+
+// BlkioStats is the block io done by the cgroup, summed over all devices
+type BlkioStats struct {
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+}
+
+// StatsReader is implemented by the subsystems that have accounting files
+type StatsReader interface {
+	GetStats(path string, stats *Stats) error // fill its part of stats
+}
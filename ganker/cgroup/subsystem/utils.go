@@ -6,11 +6,17 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
 // FindCgroupMountPoint finds the mount point of the cgroup subsystem
 func FindCgroupMountPoint(subsystem string) string {
+	// in unified mode every controller lives in the same hierarchy
+	if IsCgroup2() {
+		return unifiedMountPoint
+	}
+
 	f, err := os.Open("/proc/self/mountinfo")
 	if err != nil {
 		return ""
@@ -22,14 +28,11 @@ func FindCgroupMountPoint(subsystem string) string {
 	for scanner.Scan() {
 		txt := scanner.Text()
 		fields := strings.Split(txt, " ")
-		if strings.Contains(fields[len(fields)-1], subsystem) {
-			if subsystem == "cpu" {
-				if strings.Contains(fields[4], "cpu,") {
-					return strings.Split(fields[4], ",")[0]
-				}
-				continue
+		// the super options of a v1 hierarchy list its controllers, like "rw,cpu,cpuacct"
+		for _, opt := range strings.Split(fields[len(fields)-1], ",") {
+			if opt == subsystem {
+				return fields[4]
 			}
-			return fields[4]
 		}
 	}
 
@@ -39,16 +42,75 @@ func FindCgroupMountPoint(subsystem string) string {
 	return ""
 }
 
+// ErrNotMounted is returned by FindCgroupPath if the hierarchy of the subsystem isn't mounted, like the pids
+// controller on an older cgroup v1 host
+var ErrNotMounted = errors.New("the cgroup hierarchy isn't mounted")
+
 func FindCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string, error) {
 	cgroupRoot := FindCgroupMountPoint(subsystem)
+	// the path would be relative to the working dir without the mount point
+	if cgroupRoot == "" {
+		return "", fmt.Errorf("find %v cgroup error: %w", subsystem, ErrNotMounted)
+	}
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && errors.Is(err, os.ErrNotExist)) {
 		if errors.Is(err, os.ErrNotExist) {
 			if err := os.MkdirAll(path.Join(cgroupRoot, cgroupPath), 0755); err != nil {
 				return "", fmt.Errorf("error create %v cgroup:%v", subsystem, err)
 			}
 		}
+		if IsCgroup2() {
+			if err := enableController(subsystem, cgroupPath); err != nil {
+				return "", err
+			}
+		}
 		return path.Join(cgroupRoot, cgroupPath), nil
 	} else {
 		return "", fmt.Errorf("find cgroup in path \"%v\" error:%v", cgroupPath, err)
 	}
 }
+
+// attachProcess adds the process to the cgroup in the subsysCgroupPath path
+func attachProcess(subsysCgroupPath string, pid int) error {
+	procsFile := processIdPath
+	if IsCgroup2() {
+		procsFile = processIdPathV2
+	}
+	return os.WriteFile(path.Join(subsysCgroupPath, procsFile), []byte(strconv.Itoa(pid)), 0644)
+}
+
+// readUint reads a cgroup file holding a single number
+func readUint(file string) (uint64, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return 0, fmt.Errorf("read %s error: %v", file, err)
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s error: %v", file, err)
+	}
+	return value, nil
+}
+
+// readKeyValues reads a flat keyed cgroup file, like memory.stat or pids.events
+func readKeyValues(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("read %s error: %v", file, err)
+	}
+	defer f.Close()
+
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = value
+	}
+	return values, scanner.Err()
+}
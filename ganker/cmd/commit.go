@@ -8,20 +8,33 @@ import (
 
 // Define the commit command
 var (
+	commitMessage string
+	commitAuthor  string
+	commitChanges []string
+
 	commitCmd = &cobra.Command{
-		Use:   "commit",
+		Use:   "commit [containerId] [image]",
 		Short: "package container into image",
-		Long:  `package container into image`,
+		Long:  `package the changes of the container into a new layer on top of the layers of its image`,
 
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) < 2 {
-				CommandLogger.Info("missing container command")
+				CommandLogger.Info("missing container id or image name")
+				return
 			}
-			container.CommitContainer(args[0], args[1])
+			container.CommitContainer(args[0], args[1], container.CommitOptions{
+				Message: commitMessage,
+				Author:  commitAuthor,
+				Changes: commitChanges,
+			})
 		},
 	}
 )
 
 func init() {
 	rootCmd.AddCommand(commitCmd)
+	commitCmd.Flags().StringVarP(&commitMessage, "message", "m", "", "commit message")
+	commitCmd.Flags().StringVarP(&commitAuthor, "author", "a", "", "author, like \"ganker <ganker@example.com>\"")
+	// the changes may contain commas, like CMD ["sh", "-c", "top"]
+	commitCmd.Flags().StringArrayVarP(&commitChanges, "change", "c", []string{}, "apply a dockerfile instruction to the image, one of CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, USER and WORKDIR")
 }
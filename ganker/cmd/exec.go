@@ -13,6 +13,11 @@ import (
 const EnvExecPid = "container_pid"
 
 var (
+	execUser       string
+	execCapAdd     []string
+	execCapDrop    []string
+	execPrivileged bool
+
 	execCmd = &cobra.Command{ /**/
 		Use:   "exec [containerId] [command]",
 		Short: "exec container and execute command",
@@ -32,11 +37,21 @@ var (
 			}
 
 			containerId, cmdArray := args[0], args[1:]
-			container.ExecContainer(containerId, cmdArray)
+			container.ExecContainer(containerId, cmdArray, execUser, container.CapabilityOptions{
+				Add:        execCapAdd,
+				Drop:       execCapDrop,
+				Privileged: execPrivileged,
+			})
 		},
 	}
 )
 
 func init() {
 	rootCmd.AddCommand(execCmd)
+	// the flags of the command in the container are not parsed
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().StringVarP(&execUser, "user", "u", "", "run the command as the user, like name[:group] or uid[:gid], the user of the container by default")
+	execCmd.Flags().StringSliceVar(&execCapAdd, "cap-add", []string{}, "add linux capabilities to the command, like NET_ADMIN or ALL")
+	execCmd.Flags().StringSliceVar(&execCapDrop, "cap-drop", []string{}, "drop linux capabilities from the command, like CHOWN or ALL")
+	execCmd.Flags().BoolVar(&execPrivileged, "privileged", false, "give all capabilities to the command")
 }
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	imageCmd = &cobra.Command{
+		Use:   "image",
+		Short: "manage images",
+		Long:  `manage images, the images are listed by ganker images, named by ganker tag and removed by ganker rmi`,
+	}
+
+	imageInspectCmd = &cobra.Command{
+		Use:   "inspect [image]",
+		Short: "show detailed info of an image",
+		Long:  `show detailed info of an image, including its config and the layers in the store`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing image name")
+				return
+			}
+			container.InspectImage(args[0])
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(imageCmd)
+	imageCmd.AddCommand(imageInspectCmd)
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	imagesCmd = &cobra.Command{
+		Use:   "images",
+		Short: "show image list",
+		Long:  `show the images in the store with their ids, digests in the registry, created time and sizes`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			container.ListImages()
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(imagesCmd)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inspectCmd = &cobra.Command{
+		Use:   "inspect [containerId]",
+		Short: "show detailed info of a container",
+		Long:  `show detailed info of a container, including the resource usage read from its cgroup`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing container id")
+				return
+			}
+			container.InspectContainer(args[0])
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	loadInput string
+
+	loadCmd = &cobra.Command{
+		Use:   "load",
+		Short: "load images from an archive",
+		Long:  `load images from an archive of the OCI image layout or an archive written by docker save, the archive may be gzipped`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			container.LoadImage(loadInput)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(loadCmd)
+	loadCmd.Flags().StringVarP(&loadInput, "input", "i", "", "read from the archive instead of stdin")
+}
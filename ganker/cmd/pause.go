@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pauseCmd = &cobra.Command{
+		Use:   "pause [containerId]",
+		Short: "pause all processes within a container",
+		Long:  `pause all processes within a container, they are suspended by the freezer cgroup until unpause`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing container id")
+				return
+			}
+			container.PauseContainer(args[0])
+		},
+	}
+)
+
+var (
+	unpauseCmd = &cobra.Command{
+		Use:   "unpause [containerId]",
+		Short: "unpause all processes within a container",
+		Long:  `unpause all processes within a container`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing container id")
+				return
+			}
+			container.UnpauseContainer(args[0])
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(pauseCmd, unpauseCmd)
+}
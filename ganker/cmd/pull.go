@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pullAuthFile string
+	pullInsecure bool
+
+	pullCmd = &cobra.Command{
+		Use:   "pull [image]",
+		Short: "pull an image from a registry",
+		Long:  `pull an image from a registry speaking the OCI distribution api, like busybox, localhost:5000/app:v1 or busybox@sha256:<digest>`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing image name")
+				return
+			}
+			container.PullImage(args[0], pullAuthFile, pullInsecure)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(pullCmd)
+	pullCmd.Flags().StringVar(&pullAuthFile, "authfile", "", "the credentials file in the format of the config.json of docker, "+container.DefaultAuthFile+" by default")
+	pullCmd.Flags().BoolVar(&pullInsecure, "insecure", false, "skip the verification of the certificate of the registry, and fall back to plain http")
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pushAuthFile string
+	pushInsecure bool
+
+	pushCmd = &cobra.Command{
+		Use:   "push [image] [destination]",
+		Short: "push an image to a registry",
+		Long:  `push an image to a registry speaking the OCI distribution api, the image is pushed by its name unless the destination is given, like localhost:5000/app:v1`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing image name")
+				return
+			}
+			destination := ""
+			if len(args) > 1 {
+				destination = args[1]
+			}
+			container.PushImage(args[0], destination, pushAuthFile, pushInsecure)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+	pushCmd.Flags().StringVar(&pushAuthFile, "authfile", "", "the credentials file in the format of the config.json of docker, "+container.DefaultAuthFile+" by default")
+	pushCmd.Flags().BoolVar(&pushInsecure, "insecure", false, "skip the verification of the certificate of the registry, and fall back to plain http")
+}
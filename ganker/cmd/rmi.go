@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rmiForce bool
+
+	rmiCmd = &cobra.Command{
+		Use:   "rmi [image...]",
+		Short: "remove images",
+		Long:  `remove images and the layers no other image or container uses, an image used by a container is only removed with --force`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing image name")
+				return
+			}
+			container.RemoveImages(args, rmiForce)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(rmiCmd)
+	rmiCmd.Flags().BoolVarP(&rmiForce, "force", "f", false, "remove the images even if containers use them")
+}
@@ -23,6 +23,30 @@ var (
 	envSlice      []string
 	netName       string
 	portMapping   []string
+	publishAll    bool
+	usernsRemap   string
+	uidMaps       []string
+	gidMaps       []string
+	cgroupNs      string
+	timeOffsets   []string
+	netNs         string
+	pidNs         string
+	ipcNs         string
+	utsNs         string
+	hostname      string
+	domainname    string
+	devices       []string
+	shmSize       string
+	capAdd        []string
+	capDrop       []string
+	privileged    bool
+	securityOpts  []string
+	user          string
+	ulimits       []string
+	sysctls       []string
+	readOnly      bool
+	maskedPaths   []string
+	readonlyPaths []string
 )
 
 // Define the run command
@@ -33,7 +57,7 @@ var (
 		Long:  `Create a container by ganker run  [arg]`,
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(args) < 1 {
-				CommandLogger.Info("missing command")
+				CommandLogger.Info("no command is given, the command of the image is used")
 			}
 			CommandLogger.Infof("Running Container...")
 			CommandLogger.Infof("Run command:%v", strings.Join(args, " "))
@@ -41,23 +65,134 @@ var (
 				tty = false
 			}
 
-			container.RunContainer(tty, args, image, volume, ResourceConfig, containerName, netName, envSlice, portMapping)
+			userNs, err := container.NewUserNamespace(usernsRemap, uidMaps, gidMaps)
+			if err != nil {
+				CommandLogger.Error(err)
+				return
+			}
+
+			offsets, err := container.ParseTimeOffsets(timeOffsets)
+			if err != nil {
+				CommandLogger.Error(err)
+				return
+			}
+
+			containerDevices, err := container.ParseDevices(devices)
+			if err != nil {
+				CommandLogger.Error(err)
+				return
+			}
+
+			capabilities, err := container.CapabilityOptions{Add: capAdd, Drop: capDrop, Privileged: privileged}.Resolve(container.DefaultCapabilities)
+			if err != nil {
+				CommandLogger.Error(err)
+				return
+			}
+
+			security, err := container.ParseSecurityOpts(securityOpts)
+			if err != nil {
+				CommandLogger.Error(err)
+				return
+			}
+
+			rlimits, err := container.ParseUlimits(ulimits)
+			if err != nil {
+				CommandLogger.Error(err)
+				return
+			}
+
+			sysctlMap, err := container.ParseSysctls(sysctls)
+			if err != nil {
+				CommandLogger.Error(err)
+				return
+			}
+
+			namespaces := map[string]string{}
+			for namespace, mode := range map[string]string{"net": netNs, "pid": pidNs, "ipc": ipcNs, "uts": utsNs} {
+				if mode != "" {
+					namespaces[namespace] = mode
+				}
+			}
+
+			container.RunContainer(&container.RunOptions{
+				Tty:           tty,
+				Command:       args,
+				Image:         image,
+				Volume:        volume,
+				Resource:      ResourceConfig,
+				Name:          containerName,
+				Network:       netName,
+				Env:           envSlice,
+				User:          user,
+				PortMapping:   portMapping,
+				PublishAll:    publishAll,
+				UserNamespace: userNs,
+				CgroupNs:      cgroupNs,
+				TimeOffsets:   offsets,
+				Namespaces:    namespaces,
+				Hostname:      hostname,
+				Domainname:    domainname,
+				Devices:       containerDevices,
+				ShmSize:       shmSize,
+				Capabilities:  capabilities,
+				Privileged:    privileged,
+				Security:      security,
+				ReadOnly:      readOnly,
+				MaskedPaths:   maskedPaths,
+				ReadonlyPaths: readonlyPaths,
+				Ulimits:       rlimits,
+				Sysctls:       sysctlMap,
+			})
 		},
 	}
 )
 
 func init() {
 	rootCmd.AddCommand(runCmd)
+	// the flags of the command in the container are not parsed
+	runCmd.Flags().SetInterspersed(false)
 	// add a parameter, used to specify whether to use tty
 	runCmd.Flags().BoolVarP(&tty, "it", "t", false, "enable tty")
 	runCmd.Flags().StringVar(&ResourceConfig.Memory, "memory-limit", "922337203685477171", "memory limit")
 	runCmd.Flags().StringVar(&ResourceConfig.CpuShare, "cpu-shares", "1024", "cpu-shares limit")
-	runCmd.Flags().StringVar(&ResourceConfig.CpuQuota, "cpu-quotas", "-1", "cpuset-cpus limit")
+	runCmd.Flags().StringVar(&ResourceConfig.CpuQuota, "cpu-quotas", "-1", "cpu cfs quota limit")
+	runCmd.Flags().StringVar(&ResourceConfig.Cpus, "cpuset-cpus", "", "CPUs in which to allow execution (0-3, 0,1)")
+	runCmd.Flags().StringVar(&ResourceConfig.Mems, "cpuset-mems", "", "MEMs in which to allow execution (0-3, 0,1)")
+	runCmd.Flags().StringVar(&ResourceConfig.PidsLimit, "pids-limit", "", "tune container pids limit (-1 for unlimited)")
+	runCmd.Flags().StringVar(&ResourceConfig.BlkioWeight, "blkio-weight", "", "block IO (relative weight), between 10 and 1000")
+	runCmd.Flags().StringSliceVar(&ResourceConfig.DeviceReadBps, "device-read-bps", []string{}, "limit read rate (bytes per second) from a device, like /dev/sda:1mb")
+	runCmd.Flags().StringSliceVar(&ResourceConfig.DeviceWriteBps, "device-write-bps", []string{}, "limit write rate (bytes per second) to a device, like /dev/sda:1mb")
+	runCmd.Flags().StringSliceVar(&ResourceConfig.DeviceReadIops, "device-read-iops", []string{}, "limit read rate (IO per second) from a device, like /dev/sda:100")
+	runCmd.Flags().StringSliceVar(&ResourceConfig.DeviceWriteIops, "device-write-iops", []string{}, "limit write rate (IO per second) to a device, like /dev/sda:100")
 	runCmd.Flags().StringVarP(&volume, "volume", "v", "", "add volume")
 	runCmd.Flags().StringVarP(&image, "image", "i", "busybox", "choose image")
 	runCmd.Flags().BoolVarP(&detach, "detach", "d", false, "detach container")
 	runCmd.Flags().StringVarP(&containerName, "name", "n", "", "container name")
 	runCmd.Flags().StringSliceVarP(&envSlice, "env", "e", []string{}, "set environment")
 	runCmd.Flags().StringSliceVarP(&portMapping, "portmapping", "p", []string{}, "port mapping")
+	runCmd.Flags().BoolVarP(&publishAll, "publish-all", "P", false, "publish the ports exposed by the image on free ports of the host")
 	runCmd.Flags().StringVarP(&netName, "network", "w", "", "container network")
+	runCmd.Flags().StringVar(&usernsRemap, "userns-remap", "", "run in a user namespace with the subordinate ids of a user in /etc/subuid and /etc/subgid")
+	runCmd.Flags().StringSliceVar(&uidMaps, "uidmap", []string{}, "run in a user namespace with the uid mapping, like 0:100000:65536 (containerID:hostID:size)")
+	runCmd.Flags().StringSliceVar(&gidMaps, "gidmap", []string{}, "run in a user namespace with the gid mapping, like 0:100000:65536 (containerID:hostID:size)")
+	runCmd.Flags().StringVar(&cgroupNs, "cgroupns", "", "cgroup namespace to use (private|host), private by default on cgroup v2")
+	runCmd.Flags().StringSliceVar(&timeOffsets, "timens", []string{}, "run in a time namespace with the clock offset, like monotonic=3600 or boottime=-1h")
+	runCmd.Flags().StringVar(&netNs, "net", "", "share the network namespace of a container (container:<id>)")
+	runCmd.Flags().StringVar(&pidNs, "pid", "", "share the pid namespace of a container or the host (container:<id>|host)")
+	runCmd.Flags().StringVar(&ipcNs, "ipc", "", "share the ipc namespace of a container or the host (container:<id>|host)")
+	runCmd.Flags().StringVar(&utsNs, "uts", "", "share the uts namespace of the host (host)")
+	runCmd.Flags().StringVar(&hostname, "hostname", "", "container host name, the container id by default")
+	runCmd.Flags().StringVar(&domainname, "domainname", "", "container NIS domain name")
+	runCmd.Flags().StringSliceVar(&devices, "device", []string{}, "add a host device to the container, like /dev/sdc:/dev/xvdc:rwm")
+	runCmd.Flags().StringSliceVar(&capAdd, "cap-add", []string{}, "add linux capabilities, like NET_ADMIN or ALL")
+	runCmd.Flags().StringSliceVar(&capDrop, "cap-drop", []string{}, "drop linux capabilities, like CHOWN or ALL")
+	runCmd.Flags().BoolVar(&privileged, "privileged", false, "give all capabilities and devices to the container")
+	runCmd.Flags().StringVarP(&user, "user", "u", "", "run the command as the user, like name[:group] or uid[:gid], names are looked up in the container")
+	runCmd.Flags().StringSliceVar(&securityOpts, "security-opt", []string{}, "security options, like seccomp=unconfined, seccomp=<profile.json> or no-new-privileges=false")
+	runCmd.Flags().BoolVar(&readOnly, "read-only", false, "mount the container's root filesystem as read only, /tmp and /run are writable tmpfs")
+	runCmd.Flags().StringSliceVar(&maskedPaths, "masked-paths", container.DefaultMaskedPaths, "paths hidden in the container")
+	runCmd.Flags().StringSliceVar(&readonlyPaths, "readonly-paths", container.DefaultReadonlyPaths, "paths made read only in the container")
+	runCmd.Flags().StringSliceVar(&ulimits, "ulimit", []string{}, "resource limits of the command, like nofile=1024:2048 or core=unlimited")
+	runCmd.Flags().StringSliceVar(&sysctls, "sysctl", []string{}, "namespaced kernel parameters, like net.core.somaxconn=1024")
+	runCmd.Flags().StringVar(&shmSize, "shm-size", container.DefaultShmSize, "size of /dev/shm, like 64m")
 }
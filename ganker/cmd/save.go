@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	saveOutput string
+
+	saveCmd = &cobra.Command{
+		Use:   "save [image...]",
+		Short: "save images to an archive",
+		Long:  `save images to an archive of the OCI image layout, which can be loaded by ganker load, docker load or podman load`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing image name")
+				return
+			}
+			container.SaveImage(args, saveOutput)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(saveCmd)
+	saveCmd.Flags().StringVarP(&saveOutput, "output", "o", "", "write to the archive instead of stdout")
+}
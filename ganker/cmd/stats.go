@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	noStream    bool
+	statsFormat string
+)
+
+var (
+	statsCmd = &cobra.Command{
+		Use:   "stats [containerId...]",
+		Short: "display a live stream of container resource usage",
+		Long:  `display a live stream of cpu, memory, pids and block io usage of containers, all running containers are shown if no container id is given`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			container.StatsContainers(args, noStream, statsFormat)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().BoolVar(&noStream, "no-stream", false, "disable streaming stats and only pull the first result")
+	statsCmd.Flags().StringVar(&statsFormat, "format", container.StatsFormatTable, "output format, table or json")
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tagCmd = &cobra.Command{
+		Use:   "tag [source] [target]",
+		Short: "name an image by another name",
+		Long:  `name an image by another name like localhost:5000/app:v1, the names share the layers of the image`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 2 {
+				log.Errorf("Missing source or target image name")
+				return
+			}
+			container.TagImage(args[0], args[1])
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(tagCmd)
+}
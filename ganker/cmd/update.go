@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"go_docker_learning/ganker/cgroup/subsystem"
+	"go_docker_learning/ganker/container"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// updateResourceConfig only holds the limits given in flags, the empty fields are left unchanged
+var updateResourceConfig = &subsystem.ResourceConfig{}
+
+var (
+	updateCmd = &cobra.Command{
+		Use:   "update [containerId]",
+		Short: "update resource limits of a running container",
+		Long:  `update resource limits of a running container, the limits not given keep their current value`,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				log.Errorf("Missing container id")
+				return
+			}
+			container.UpdateContainer(args[0], updateResourceConfig)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(updateCmd)
+	updateCmd.Flags().StringVar(&updateResourceConfig.Memory, "memory-limit", "", "memory limit")
+	updateCmd.Flags().StringVar(&updateResourceConfig.CpuShare, "cpu-shares", "", "cpu-shares limit")
+	updateCmd.Flags().StringVar(&updateResourceConfig.CpuQuota, "cpu-quotas", "", "cpu cfs quota limit")
+	updateCmd.Flags().StringVar(&updateResourceConfig.Cpus, "cpuset-cpus", "", "CPUs in which to allow execution (0-3, 0,1)")
+	updateCmd.Flags().StringVar(&updateResourceConfig.Mems, "cpuset-mems", "", "MEMs in which to allow execution (0-3, 0,1)")
+	updateCmd.Flags().StringVar(&updateResourceConfig.PidsLimit, "pids-limit", "", "tune container pids limit (-1 for unlimited)")
+	updateCmd.Flags().StringVar(&updateResourceConfig.BlkioWeight, "blkio-weight", "", "block IO (relative weight), between 10 and 1000")
+	updateCmd.Flags().StringSliceVar(&updateResourceConfig.DeviceReadBps, "device-read-bps", []string{}, "limit read rate (bytes per second) from a device, like /dev/sda:1mb, 0 removes the limit")
+	updateCmd.Flags().StringSliceVar(&updateResourceConfig.DeviceWriteBps, "device-write-bps", []string{}, "limit write rate (bytes per second) to a device, like /dev/sda:1mb, 0 removes the limit")
+	updateCmd.Flags().StringSliceVar(&updateResourceConfig.DeviceReadIops, "device-read-iops", []string{}, "limit read rate (IO per second) from a device, like /dev/sda:100, 0 removes the limit")
+	updateCmd.Flags().StringSliceVar(&updateResourceConfig.DeviceWriteIops, "device-write-iops", []string{}, "limit write rate (IO per second) to a device, like /dev/sda:100, 0 removes the limit")
+}
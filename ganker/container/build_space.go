@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"os/exec"
 	"time"
 
 	syscall "golang.org/x/sys/unix"
@@ -14,9 +13,6 @@ import (
 )
 
 const (
-	// LowerName  the prefix name of lower layer
-	LowerName = "lower"
-
 	// UpperName the prefix name of upper layer
 	UpperName = "diff"
 
@@ -25,22 +21,28 @@ const (
 
 	// MergeLayerName the prefix name of merge layer
 	MergeLayerName = "merged"
+)
 
+// the root paths are under $XDG_DATA_HOME/ganker in rootless mode
+var (
 	// ImageRootPath is the root path of image compressed file
-	ImageRootPath = "./images/"
+	ImageRootPath = dataRoot() + "images/"
+
+	// LayerRootPath is the root path of the layer store, each layer is unpacked once in a dir named by its digest
+	LayerRootPath = ImageRootPath + "layers/"
 
 	// StorageRootPath is the root path of container
-	StorageRootPath = "./storage/"
+	StorageRootPath = dataRoot() + "storage/"
 
 	// VolumeRootPath is the root path of volume
-	VolumeRootPath = "./volumes/"
+	VolumeRootPath = dataRoot() + "volumes/"
 
 	// ContainerRootPath is the root path of container
-	ContainerRootPath = "./containers/"
+	ContainerRootPath = dataRoot() + "containers/"
 )
 
-// NewWorkSpace create the work space for the container
-func newWorkSpace(id, image, volume string) (string, string) {
+// NewWorkSpace create the work space for the container, the files are owned by the mapped ids if userNs is not nil
+func newWorkSpace(id, image, volume string, userNs *UserNamespace) (string, string) {
 	var volumeArray []string
 	var err error
 	if volume != "" {
@@ -50,12 +52,9 @@ func newWorkSpace(id, image, volume string) (string, string) {
 			os.Exit(-1)
 		}
 	}
-	imagePath := ImageRootPath + image + ".tar"
-	if exists, err := checkFileOrDirExist(imagePath); err != nil {
-		log.Errorf("Fail to judge if the root dir of image exist: " + err.Error())
-		os.Exit(-1)
-	} else if !exists {
-		log.Infof("Image not found")
+	imageRecord, err := getImage(image)
+	if err != nil {
+		log.Errorf("Fail to get the layers of the image: " + err.Error())
 		os.Exit(-1)
 	}
 	containerDir := StorageRootPath + id + "/"
@@ -64,17 +63,47 @@ func newWorkSpace(id, image, volume string) (string, string) {
 		os.Exit(-1)
 	}
 
-	// create the layers
+	// create the layers, the image layers in the layer store are shared by the containers.
+	// the layers of a rootless container are owned by the user already, which is root in the container
 	newUpperLayer(containerDir)
-	newLowerLayer(containerDir, imagePath)
+	layerNs := userNs
+	if Rootless {
+		layerNs = nil
+	}
+	lowerDirs, err := acquireLayers(containerDir, id, imageRecord.Layers, layerNs)
+	if err != nil {
+		log.Errorf("Fail to acquire the image layers: " + err.Error())
+		os.Exit(-1)
+	}
 	newMergeLayer(containerDir)
 	newWorkLayer(containerDir)
 
+	// a rootless container is owned by the user already, and the volume is mounted by init in its mount namespace
+	if Rootless {
+		if err := mountRootlessFS(containerDir, lowerDirs); err != nil {
+			log.Errorf("Fail to mount the rootfs with %s: %v", getStorageDriver(), err)
+			os.Exit(-1)
+		}
+		if volume != "" {
+			createVolume(volumeArray)
+		}
+		fmt.Printf("container: %v is created \n", id)
+		return containerDir, id
+	}
+
+	// root of the container owns the upper layer, which is the root dir of the merged layer
+	if userNs != nil {
+		if err := userNs.chownRoot(containerDir + UpperName); err != nil {
+			log.Errorf("Fail to change the owner of the upper layer: " + err.Error())
+			os.Exit(-1)
+		}
+	}
+
 	// mount the overlay file system
-	execMountFS(containerDir)
+	execMountFS(containerDir, lowerDirs)
 
 	if volume != "" {
-		mountVolume(volumeArray, containerDir)
+		mountVolume(volumeArray, containerDir, userNs)
 	}
 	fmt.Printf("container: %v is created \n", id)
 	return containerDir, id
@@ -113,29 +142,10 @@ func newMergeLayer(containerDir string) string {
 	return mergeLayerPath
 }
 
-// newLowerLayer create the read only layer
-func newLowerLayer(containerDir, imagePath string) string {
+// execMountFS mount the overlay file system, the layers of the image are the lowerdirs, the lowest one first
+func execMountFS(containerDir string, lowerDirs []string) {
 
-	// create the read only layer
-	lowerDir := containerDir + LowerName
-	if err := os.MkdirAll(lowerDir, 0777); err != nil {
-		log.Errorf("Fail to create lower dir: " + err.Error())
-		os.Exit(-1)
-	}
-
-	// decompression the image to the lower dir
-	if err := exec.Command("tar", "-xvf", imagePath, "-C", lowerDir).Run(); err != nil {
-		log.Errorf("Fail to extract the image to the lower dir: " + err.Error())
-		os.Exit(-1)
-	}
-
-	return lowerDir
-}
-
-// execMountFS mount the overlay file system
-func execMountFS(containerDir string) {
-
-	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", containerDir+LowerName, containerDir+UpperName, containerDir+WorkSpaceName)
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", overlayLowerDirs(lowerDirs), containerDir+UpperName, containerDir+WorkSpaceName)
 	if err := syscall.Mount("overlay", containerDir+MergeLayerName, "overlay", 0, data); err != nil {
 		log.Errorf("Fail to mount the overlay file system: " + err.Error())
 		os.Exit(-1)
@@ -145,7 +155,11 @@ func execMountFS(containerDir string) {
 
 func deleteMountPoint(containerDir string) {
 	mountPoint := containerDir + MergeLayerName
-	if err := syscall.Unmount(mountPoint, syscall.MNT_DETACH); err != nil {
+	if Rootless {
+		if err := unmountRootlessFS(mountPoint); err != nil {
+			log.Errorf("Fail to unmount the fuse-overlayfs: " + err.Error())
+		}
+	} else if err := syscall.Unmount(mountPoint, syscall.MNT_DETACH); err != nil {
 		log.Errorf("Fail to unmount the overlay file system: " + err.Error())
 	}
 
@@ -157,8 +171,8 @@ func deleteMountPoint(containerDir string) {
 }
 
 func deleteWorkSpace(containerDir, volume string) {
-	// check if the volume is empty
-	if volume != "" {
+	// check if the volume is empty, the volume of a rootless container is only mounted in its mount namespace
+	if volume != "" && !Rootless {
 		volumeArray, err := extractVolume(volume)
 		if volumeArray == nil {
 			log.Errorf("Fail to extract the volume when delete: " + err.Error())
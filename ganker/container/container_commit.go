@@ -1,35 +1,128 @@
 package container
 
 import (
-	"os/exec"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// CommitContainer commit the container to image
-func CommitContainer(containerId, image string) {
-	containerDir := StorageRootPath + containerId
+// CommitOptions are given by ganker commit
+type CommitOptions struct {
+	Message string
+	Author  string
+	Changes []string // dockerfile instructions changing the config of the image, like "CMD top" or "ENV KEY=value"
+}
+
+// CommitContainer commit the container to image, the image has the layers of the container's image
+// and a new layer on top of them holding the changes of the container
+func CommitContainer(containerId, image string, opts CommitOptions) {
+	containerDir := StorageRootPath + containerId + "/"
 	if exist, err := checkFileOrDirExist(containerDir); err != nil {
-		log.Errorf("check container dir exist failed ", err)
+		log.Errorf("check container dir exist failed %v", err)
 		return
 	} else if !exist {
 		log.Infof("container %v not found", containerId)
 		return
 	}
 
-	imageDir := ImageRootPath + image + ".tar"
-	if exist, err := checkFileOrDirExist(imageDir); err != nil {
-		log.Errorf("check image dir exist failed ", err)
+	image = normalizeImageName(image)
+	if err := checkImageName(image); err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	if exist, err := imageExists(image); err != nil {
+		log.Errorf("check image exist failed %v", err)
 		return
 	} else if exist {
 		log.Infof("image name already exist")
 		return
 	}
 
-	if err := exec.Command("tar", "-czf", imageDir, "-C", containerDir+"/"+MergeLayerName, ".").Run(); err != nil {
-		log.Errorf("package container dir failed ", err)
+	containerInfo, err := getContainerInfo(containerId)
+	if err != nil {
+		log.Errorf("get container %s info failed %v", containerId, err)
+		return
+	}
+
+	parentLayers, err := getContainerLayerDigests(containerDir)
+	if err != nil {
+		log.Errorf("get layers of container %s failed %v", containerId, err)
+		return
+	}
+	// the config and the platform of the image the container was created from are inherited
+	config := ImageConfig{}
+	architecture, osName := runtime.GOARCH, runtime.GOOS
+	if parent, err := readImageRecord(containerInfo.Image); err == nil {
+		config = parent.Config
+		if parent.OS != "" {
+			architecture, osName = parent.Architecture, parent.OS
+		}
+	}
+	if err := config.applyChanges(opts.Changes); err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+
+	// the vfs driver has no upper dir, the copied rootfs holding every file is committed as the only layer
+	changedDir := containerDir + UpperName
+	if getStorageDriver() == StorageDriverVfs {
+		changedDir, parentLayers = containerDir+MergeLayerName, nil
+	}
+	// the files of a container in a user namespace are owned by the mapped host ids,
+	// they are mapped back so the layer has the same owners as the image the container was created from
+	digest, err := commitLayer(changedDir, containerInfo.UserNamespace)
+	if err != nil {
+		log.Errorf("package container dir failed %v", err)
+		return
+	}
+
+	record := &ImageRecord{
+		Layers:  append(parentLayers, digest),
+		Parent:  containerInfo.Image,
+		Created: time.Now().Format("2006-01-02 15:04:05"),
+		Author:  opts.Author,
+		Comment: opts.Message,
+		Config:  config,
+
+		Architecture: architecture,
+		OS:           osName,
+	}
+	// the id is the digest of the config with the diff ids of the layers in the store
+	record.ID = imageID(record)
+	if err := writeImageRecord(image, record); err != nil {
+		log.Errorf("%v", err)
 		return
 	}
 
 	log.Infof("package container %v to image %v success", containerId, image)
 }
+
+// commitLayer packs the upper dir of a container into a layer tar and imports it into the layer store
+func commitLayer(upperDir string, userNs *UserNamespace) (string, error) {
+	file, err := os.CreateTemp(LayerRootPath, "tmp-*.tar")
+	if err != nil {
+		return "", fmt.Errorf("create layer tar error: %v", err)
+	}
+	defer os.Remove(file.Name())
+	if err := archiveLayer(upperDir, file, userNs); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return importLayer(file.Name(), "")
+}
+
+// imageExists checks if the image is a tar or a record in ImageRootPath
+func imageExists(image string) (bool, error) {
+	for _, path := range []string{ImageRootPath + image + ".tar", ImageRootPath + image + ".json"} {
+		if exist, err := checkFileOrDirExist(path); err != nil || exist {
+			return exist, err
+		}
+	}
+	return false, nil
+}
@@ -2,6 +2,7 @@ package container
 
 import (
 	"os"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -10,6 +11,12 @@ func DeleteContainer(containerId string) {
 	containerInfo, err := getContainerInfo(containerId)
 	if err != nil {
 		log.Errorf("get container %s info error %v", containerId, err)
+		return
+	}
+
+	if containerInfo.Status == PAUSED {
+		log.Errorf("couldn't remove paused container, please unpause and stop it first")
+		return
 	}
 
 	if containerInfo.Status != EXIT {
@@ -17,9 +24,16 @@ func DeleteContainer(containerId string) {
 		return
 	}
 
+	// the namespaces live on with the containers sharing them, the container must be kept until they exit
+	if users := getNamespaceUsers(containerId); len(users) != 0 {
+		log.Errorf("couldn't remove container, its namespaces are shared by running containers %s", strings.Join(users, ", "))
+		return
+	}
+
 	deleteContainerInfo(containerId)
 
 	storagePath := StorageRootPath + containerId
+	releaseLayers(storagePath+"/", containerId)
 	if err := os.RemoveAll(storagePath); err != nil {
 		log.Errorf("remove container %s error %v", containerId, err)
 	}
@@ -4,16 +4,27 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// envExecPrefix is the prefix of the control vars of ganker exec, read by the nsenter constructor
+const envExecPrefix = "container_"
+
 const EnvExecPid = "container_pid"
 const EnvExecCmd = "container_cmd"
+const EnvExecCaps = "container_caps"
+const EnvExecSeccomp = "container_seccomp"
+const EnvExecUser = "container_user"
+const EnvExecGroups = "container_groups"
+const EnvExecHome = "container_home"
+const EnvExecNoNewPrivs = "container_no_new_privs"
 
-// ExecContainer exec container by containerId
-func ExecContainer(containerId string, cmdArray []string) {
+// ExecContainer exec container by containerId, the command has the capabilities of the container changed by capOpts,
+// and runs as user, or as the user of the container if it's empty
+func ExecContainer(containerId string, cmdArray []string, user string, capOpts CapabilityOptions) {
 
 	containerInfo, err := getContainerInfo(containerId)
 	if err != nil {
@@ -21,6 +32,11 @@ func ExecContainer(containerId string, cmdArray []string) {
 		return
 	}
 
+	if containerInfo.Status == PAUSED {
+		log.Errorf("Exec container %s is paused, unpause it first", containerId)
+		return
+	}
+
 	if containerInfo.Status != RUNNING {
 		log.Errorf("Exec container %s not running", containerId)
 		return
@@ -35,18 +51,56 @@ func ExecContainer(containerId string, cmdArray []string) {
 	cmd.Stdout = os.Stdin
 	cmd.Stderr = os.Stdout
 
-	if err := os.Setenv(EnvExecPid, containerInfo.Pid); err != nil {
-		log.Errorf("Exec container setenv %s error %v", containerId, err)
+	// the control vars tell the nsenter constructor what to apply, they are passed after the environ of the container
+	// so the container can't override them, and the constructor removes them before the command runs
+	control := []string{EnvExecPid + "=" + containerInfo.Pid, EnvExecCmd + "=" + cmdStr}
+
+	// the capabilities of a container created before they were recorded are kept unless they are changed
+	caps := allCapabilities()
+	if containerInfo.Capabilities != nil || len(capOpts.Add) != 0 || len(capOpts.Drop) != 0 || capOpts.Privileged {
+		base := containerInfo.Capabilities
+		if base == nil {
+			base = DefaultCapabilities
+		}
+		if caps, err = capOpts.Resolve(base); err != nil {
+			log.Errorf("Exec container %s error %v", containerId, err)
+			return
+		}
+		control = append(control, EnvExecCaps+"="+strconv.FormatUint(capabilityMask(caps), 16))
+	}
+
+	// the command is filtered by the seccomp profile of the container, with the capabilities of the command
+	profile, err := getSeccompProfile(containerId)
+	if err != nil {
+		log.Errorf("Exec container %s error %v", containerId, err)
 		return
 	}
+	if profile != nil {
+		filter, err := compileSeccomp(profile, caps)
+		if err != nil {
+			log.Errorf("Exec container %s compile seccomp profile error %v", containerId, err)
+			return
+		}
+		encoded, err := encodeSeccomp(filter)
+		if err != nil {
+			log.Errorf("Exec container %s error %v", containerId, err)
+			return
+		}
+		control = append(control, EnvExecSeccomp+"="+encoded)
+	}
 
-	if err := os.Setenv(EnvExecCmd, cmdStr); err != nil {
-		log.Errorf("Exec container setenv %s error %v", containerId, err)
+	userEnv, err := execUserEnv(containerInfo, user)
+	if err != nil {
+		log.Errorf("Exec container %s error %v", containerId, err)
 		return
 	}
+	control = append(control, userEnv...)
+	if containerInfo.NoNewPrivs {
+		control = append(control, EnvExecNoNewPrivs+"=1")
+	}
 
-	// set env that container process can inherit
-	cmd.Env = append(os.Environ(), getEnvByPid(containerInfo.Pid)...)
+	// the command gets the environ of the container like ganker run builds it, nothing of the host environment
+	cmd.Env = append(getEnvByPid(containerInfo.Pid), control...)
 
 	if err := cmd.Run(); err != nil {
 		log.Errorf("Exec container %s error %v", containerId, err)
@@ -54,7 +108,28 @@ func ExecContainer(containerId string, cmdArray []string) {
 	}
 }
 
-// getEnvPid get env from process pid
+// execUserEnv resolves the user against the passwd and group files of the container, and returns the control vars
+// passing it to the command
+func execUserEnv(containerInfo *Info, user string) ([]string, error) {
+	if user == "" {
+		user = containerInfo.User
+	}
+	if user == "" {
+		return nil, nil
+	}
+	root := fmt.Sprintf("/proc/%s/root", containerInfo.Pid)
+	execUser, err := lookupUser(user, root+passwdPath, root+groupPath)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		EnvExecUser + "=" + fmt.Sprintf("%d:%d", execUser.Uid, execUser.Gid),
+		EnvExecGroups + "=" + encodeGroups(execUser.Groups),
+		EnvExecHome + "=" + execUser.Home,
+	}, nil
+}
+
+// getEnvPid get env from process pid, the control vars of ganker exec are left out
 func getEnvByPid(pid string) []string {
 
 	// process storage env in /proc/pid/environ
@@ -66,6 +141,25 @@ func getEnvByPid(pid string) []string {
 		return nil
 	}
 
-	envSlice := strings.Split(string(content), "\u0000")
+	var envSlice []string
+	for _, env := range strings.Split(string(content), "\u0000") {
+		if env == "" || isExecControlEnv(env) {
+			continue
+		}
+		envSlice = append(envSlice, env)
+	}
 	return envSlice
 }
+
+// checkEnvKey checks if the key can be set in the environment of a container, the control vars of ganker exec can't
+func checkEnvKey(key string) error {
+	if isExecControlEnv(key) {
+		return fmt.Errorf("the env %s is reserved for ganker exec", key)
+	}
+	return nil
+}
+
+// isExecControlEnv checks if the env, like "container_pid=1", is a control var of ganker exec
+func isExecControlEnv(env string) bool {
+	return strings.HasPrefix(env, envExecPrefix)
+}
@@ -5,49 +5,192 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"runtime"
+	"strings"
 
 	syscall "golang.org/x/sys/unix"
 
 	"github.com/sirupsen/logrus"
 )
 
+func init() {
+	// the init process must stay on the main thread: setgroups and unshare only change the calling thread,
+	// so the command must be executed from it, and timens_offsets is only writable for the main thread
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		runtime.LockOSThread()
+	}
+}
+
 // InitRunContainerProcess is used to create a parent process
 func InitRunContainerProcess() error {
+	// get the init message from pipe
+	initMessage, err := readParentProcess()
+	if err != nil {
+		return err
+	}
 
-	// get the command array from pipe
-	cmdArray := readParentProcess()
-	if cmdArray == nil {
-		return fmt.Errorf("get parent command error, cmdArray is nil")
+	// unshare the cgroup and time namespaces
+	if err := setupNamespaces(initMessage); err != nil {
+		return err
 	}
 
 	// set Mount
-	if err := MountSet(); err != nil {
+	if err := MountSet(initMessage); err != nil {
 		return err
 	}
 
-	if cmdArray[0] == "" {
-		return nil
+	if len(initMessage.Args) == 0 {
+		return fmt.Errorf("get parent command error, no command to run")
+	}
+
+	// set hostname, rlimits, working dir and user
+	if err := setupProcess(initMessage); err != nil {
+		return err
 	}
 
 	//LookPath searches for an executable named file in the directories named by the PATH environment variable.
 	// so it will return the absolute path of the command
 	// for example, if the command is "ls", it will return "/bin/ls"
-	path, err := exec.LookPath(cmdArray[0])
+	// the PATH of the container is used instead of the one inherited from the parent
+	for _, env := range initMessage.Env {
+		if strings.HasPrefix(env, "PATH=") {
+			os.Setenv("PATH", strings.TrimPrefix(env, "PATH="))
+		}
+	}
+	path, err := exec.LookPath(initMessage.Args[0])
 	if err != nil {
 		logrus.Errorf("nsenter loop path error %v", err)
 		return err
 	}
 
 	// syscall.Exec will replace the current process with the command
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
+	if err := syscall.Exec(path, initMessage.Args, initMessage.Env); err != nil {
 		logrus.Errorf(err.Error())
 		return err
 	}
 	return nil
 }
 
+// setupProcess applies the process related fields of the init message, the user is switched last
+// as the other settings need root, and the capabilities are limited after it
+func setupProcess(initMessage *InitMessage) error {
+	if initMessage.Hostname != "" {
+		if err := syscall.Sethostname([]byte(initMessage.Hostname)); err != nil {
+			return fmt.Errorf("set hostname %s error: %v", initMessage.Hostname, err)
+		}
+	}
+	if initMessage.Domainname != "" {
+		if err := syscall.Setdomainname([]byte(initMessage.Domainname)); err != nil {
+			return fmt.Errorf("set domainname %s error: %v", initMessage.Domainname, err)
+		}
+	}
+
+	for _, rlimit := range initMessage.Rlimits {
+		limit := &syscall.Rlimit{Cur: rlimit.Soft, Max: rlimit.Hard}
+		if err := syscall.Setrlimit(rlimit.Type, limit); err != nil {
+			return fmt.Errorf("set rlimit %s error: %v", rlimitName(rlimit.Type), err)
+		}
+	}
+
+	if initMessage.Cwd != "" {
+		// the working dir of the image is created like docker does, the error is left to chdir
+		if _, err := os.Stat(initMessage.Cwd); os.IsNotExist(err) {
+			os.MkdirAll(initMessage.Cwd, 0755)
+		}
+		if err := syscall.Chdir(initMessage.Cwd); err != nil {
+			return fmt.Errorf("chdir %s error: %v", initMessage.Cwd, err)
+		}
+	}
+
+	// the user is resolved against the files of the container, and HOME defaults to its home
+	user := initMessage.User
+	if user == "" {
+		user = "0"
+	}
+	execUser, err := lookupUser(user, passwdPath, groupPath)
+	if err != nil {
+		return err
+	}
+	if !hasEnv(initMessage.Env, "HOME") {
+		initMessage.Env = append(initMessage.Env, "HOME="+execUser.Home)
+	}
+
+	capMask := capabilityMask(initMessage.Capabilities)
+	if err := dropBoundingSet(capMask); err != nil {
+		return err
+	}
+	// without no_new_privs the filter needs CAP_SYS_ADMIN, which may be gone after the user is switched,
+	// so it's installed before like runc does, and must allow the syscalls left, like setuid and capset
+	if !initMessage.NoNewPrivileges {
+		if err := installSeccomp(initMessage.Seccomp); err != nil {
+			return err
+		}
+	}
+
+	if initMessage.User != "" {
+		if err := switchUser(execUser); err != nil {
+			return err
+		}
+	}
+	if err := setCapabilities(capMask); err != nil {
+		return err
+	}
+	// the setuid binaries and file capabilities can't grant more privileges to the command after it's set
+	if initMessage.NoNewPrivileges {
+		if err := syscall.Prctl(syscall.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("set no_new_privs error: %v", err)
+		}
+		// the filter is installed last, so only the command and the exec of it go through it
+		if err := installSeccomp(initMessage.Seccomp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// switchUser sets the groups, gid and uid of the process. the same as docker, only root keeps its capabilities
+// to be limited later, setuid clears the permitted capabilities of the other users, they are left in the bounding set
+func switchUser(execUser *ExecUser) error {
+	keepCaps := 0
+	if execUser.Uid == 0 {
+		keepCaps = 1
+	}
+	if err := syscall.Prctl(syscall.PR_SET_KEEPCAPS, uintptr(keepCaps), 0, 0, 0); err != nil {
+		return fmt.Errorf("keep capabilities error: %v", err)
+	}
+	if !setgroupsDenied() {
+		if err := syscall.Setgroups(execUser.Groups); err != nil {
+			return fmt.Errorf("set groups %v error: %v", execUser.Groups, err)
+		}
+	} else if len(execUser.Groups) != 0 {
+		logrus.Warnf("supplementary groups %v are ignored in a rootless container", execUser.Groups)
+	}
+	// the ids not mapped in the user namespace are invalid, a rootless container only maps root
+	if err := syscall.Setgid(execUser.Gid); err == syscall.EINVAL {
+		return fmt.Errorf("set gid %d error: it is not mapped in the user namespace of the container", execUser.Gid)
+	} else if err != nil {
+		return fmt.Errorf("set gid %d error: %v", execUser.Gid, err)
+	}
+	if err := syscall.Setuid(execUser.Uid); err == syscall.EINVAL {
+		return fmt.Errorf("set uid %d error: it is not mapped in the user namespace of the container", execUser.Uid)
+	} else if err != nil {
+		return fmt.Errorf("set uid %d error: %v", execUser.Uid, err)
+	}
+	if err := syscall.Prctl(syscall.PR_SET_KEEPCAPS, 0, 0, 0, 0); err != nil {
+		return fmt.Errorf("reset keep capabilities error: %v", err)
+	}
+	return nil
+}
+
+// setgroupsDenied reports whether setgroups is denied in the user namespace, which is the case in a rootless container
+// as the kernel only allows an unprivileged user to write gid_map after it
+func setgroupsDenied() bool {
+	content, err := os.ReadFile("/proc/self/setgroups")
+	return err == nil && strings.TrimSpace(string(content)) == "deny"
+}
+
 // initNewParentProcess it will clone a new process as parent process and calling /proc/self/exe with init as the first argument
-func initNewParentProcess(tty bool, id, imageName, volume string, env []string) (*exec.Cmd, *os.File, string, string) {
+func initNewParentProcess(id string, opts *RunOptions) (*exec.Cmd, *os.File, string, string) {
 	// create a pipe,it will be used to send command to child process,in another word, it can be used to send command to init process
 	readPipe, writePipe, err := newPipe()
 	if err != nil {
@@ -63,12 +206,20 @@ func initNewParentProcess(tty bool, id, imageName, volume string, env []string)
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID |
 			syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
+	// the namespaces shared with the host or other containers are not created
+	for namespace := range opts.Namespaces {
+		cmd.SysProcAttr.Cloneflags &^= sharableNamespaces[namespace].cloneFlag
+	}
+	// root in the container is mapped to an unprivileged user on the host
+	if opts.UserNamespace != nil {
+		opts.UserNamespace.setSysProcAttr(cmd.SysProcAttr)
+	}
 	logOut := recordContainerLog(id)
 	if logOut == nil {
 		return nil, nil, "", ""
 	}
 	// if a tty is needed, it will set for user input and output
-	if tty {
+	if opts.Tty {
 		stdOut := io.MultiWriter(os.Stdout, logOut)
 		stdErr := io.MultiWriter(os.Stderr, logOut)
 		cmd.Stdin = os.Stdin
@@ -79,7 +230,7 @@ func initNewParentProcess(tty bool, id, imageName, volume string, env []string)
 		cmd.Stdout = logOut
 		cmd.Stderr = logOut
 	}
-	containerDir, containerId := newWorkSpace(id, imageName, volume)
+	containerDir, containerId := newWorkSpace(id, opts.Image, opts.Volume, opts.UserNamespace)
 	// ExtraFiles specifies additional open files to be inherited by the new process,
 	// it will deliver the pipe file to child process
 	// as file descriptor 0,1,2 are used for stdin,stdout,stderr,
@@ -87,7 +238,5 @@ func initNewParentProcess(tty bool, id, imageName, volume string, env []string)
 	cmd.ExtraFiles = []*os.File{readPipe}
 	cmd.Dir = containerDir + MergeLayerName
 
-	// set environment variables
-	cmd.Env = append(os.Environ(), env...)
 	return cmd, writePipe, containerDir, containerId
 }
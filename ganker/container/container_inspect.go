@@ -0,0 +1,41 @@
+package container
+
+import (
+	"fmt"
+	"go_docker_learning/ganker/cgroup"
+	"go_docker_learning/ganker/cgroup/subsystem"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// containerDetail is what inspect prints, the saved Info plus the state read from the live cgroup
+type containerDetail struct {
+	*Info
+	Pids *subsystem.PidsStats `json:"pids,omitempty"` // process count and rejected forks
+}
+
+// InspectContainer prints the detail of a container in json
+func InspectContainer(containerId string) {
+	containerInfo, err := getContainerInfo(containerId)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerId, err)
+		return
+	}
+
+	detail := &containerDetail{Info: containerInfo}
+	if containerInfo.Status == RUNNING || containerInfo.Status == PAUSED {
+		cGroupManager := cgroup.NewCgroupManager(getCgroupPath(containerId))
+		if detail.Pids, err = cGroupManager.PidsStats(); err != nil {
+			log.Warnf("Get container %s pids stats error %v", containerId, err)
+		}
+	}
+
+	body, err := json.MarshalIndent(detail, "", "    ")
+	if err != nil {
+		log.Errorf("Json marshal error %v", err)
+		return
+	}
+	fmt.Println(string(body))
+}
@@ -36,7 +36,7 @@ func recordContainerLog(containerId string) *os.File {
 
 	logFilePath := path.Join(ContainerRootPath+containerId, LogFileName)
 	if exist, _ := checkFileOrDirExist(logFilePath); !exist {
-		if err := os.MkdirAll(path.Dir(logFilePath), 0666); err != nil {
+		if err := os.MkdirAll(path.Dir(logFilePath), 0755); err != nil {
 			logrus.Errorf("Create Log File %s Error %v", logFilePath, err)
 			return nil
 		}
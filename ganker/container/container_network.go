@@ -10,12 +10,12 @@ import (
 )
 
 var (
-	NetConfigRootPath = "./networks/netconfig/"
+	NetConfigRootPath = dataRoot() + "networks/netconfig/"
 	netDriver         = map[string]networks.NetDriver{}
 	network           = map[string]*networks.Net{}
 )
 
-const ipamAllocatorPath = "./networks/ipam.json"
+var ipamAllocatorPath = dataRoot() + "networks/ipam.json"
 
 var ipAllocator = &networks.IPAM{
 	SubnetAllocator: ipamAllocatorPath,
@@ -49,20 +49,26 @@ func CreateNet(driver, subnet, name string) error {
 
 // Connect connect a container to the net
 func Connect(containerId, netName string) error {
+	_, err := connectNetwork(containerId, netName)
+	return err
+}
+
+// connectNetwork connects a container to the net, it returns the endpoint of the container in the net
+func connectNetwork(containerId, netName string) (*networks.NetPoint, error) {
 	info, err := getContainerInfo(containerId)
 	if err != nil {
-		return fmt.Errorf("get container info error: %v", err)
+		return nil, fmt.Errorf("get container info error: %v", err)
 	}
 	// get net from network map
 	nw, ok := network[netName]
 	if !ok {
-		return fmt.Errorf("no such network: %s", netName)
+		return nil, fmt.Errorf("no such network: %s", netName)
 	}
 
 	// allocate ip for the container
 	ip, err := ipAllocator.Allocate(nw.IpRange)
 	if err != nil {
-		return fmt.Errorf("allocate ip for subnet %s failed, err: %v", nw.IpRange.String(), err)
+		return nil, fmt.Errorf("allocate ip for subnet %s failed, err: %v", nw.IpRange.String(), err)
 	}
 
 	// construct netpoint
@@ -74,13 +80,13 @@ func Connect(containerId, netName string) error {
 	}
 
 	if err := netDriver[nw.Driver].Connect(nw, netEndPoint); err != nil {
-		return fmt.Errorf("connect network %s failed, err: %v", nw.Name, err)
+		return nil, fmt.Errorf("connect network %s failed, err: %v", nw.Name, err)
 	}
 
 	if err := networks.ConfigEndpointIpAndRoute(netEndPoint, info.Pid); err != nil {
-		return fmt.Errorf("config endpoint ip and route error: %v", err)
+		return nil, fmt.Errorf("config endpoint ip and route error: %v", err)
 	}
-	return networks.ConfigurePortMapping(netEndPoint)
+	return netEndPoint, networks.ConfigurePortMapping(netEndPoint)
 }
 
 // load all net config to network map
@@ -88,7 +94,7 @@ func InitNet() error {
 	var bridgeDriver = networks.BridgeNetDriver{}
 	netDriver[bridgeDriver.Name()] = &bridgeDriver
 
-	if err := os.MkdirAll(NetConfigRootPath, 0644); err != nil {
+	if err := os.MkdirAll(NetConfigRootPath, 0755); err != nil {
 		return fmt.Errorf("mkdir %s error: %v", NetConfigRootPath, err)
 	}
 
@@ -0,0 +1,76 @@
+package container
+
+import (
+	"fmt"
+	"go_docker_learning/ganker/cgroup"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PauseContainer suspends all processes of a running container with the freezer cgroup
+func PauseContainer(containerId string) {
+	unlock, err := lockContainer(containerId)
+	if err != nil {
+		log.Errorf("Lock container %s error %v", containerId, err)
+		return
+	}
+	defer unlock()
+
+	containerInfo, err := getContainerInfo(containerId)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerId, err)
+		return
+	}
+
+	if containerInfo.Status != RUNNING {
+		log.Errorf("Container %s is not running now", containerId)
+		return
+	}
+
+	cGroupManager := cgroup.NewCgroupManager(getCgroupPath(containerId))
+	if err := cGroupManager.Freeze(); err != nil {
+		log.Errorf("Pause container %s error %v", containerId, err)
+		return
+	}
+
+	containerInfo.Status = PAUSED
+	if err := dumpContainerInfo(containerInfo); err != nil {
+		log.Errorf("Dump container %s info error %v", containerId, err)
+		return
+	}
+	fmt.Println(containerId)
+}
+
+// UnpauseContainer resumes all processes of a paused container
+func UnpauseContainer(containerId string) {
+	unlock, err := lockContainer(containerId)
+	if err != nil {
+		log.Errorf("Lock container %s error %v", containerId, err)
+		return
+	}
+	defer unlock()
+
+	containerInfo, err := getContainerInfo(containerId)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerId, err)
+		return
+	}
+
+	if containerInfo.Status != PAUSED {
+		log.Errorf("Container %s is not paused", containerId)
+		return
+	}
+
+	cGroupManager := cgroup.NewCgroupManager(getCgroupPath(containerId))
+	if err := cGroupManager.Thaw(); err != nil {
+		log.Errorf("Unpause container %s error %v", containerId, err)
+		return
+	}
+
+	containerInfo.Status = RUNNING
+	if err := dumpContainerInfo(containerInfo); err != nil {
+		log.Errorf("Dump container %s info error %v", containerId, err)
+		return
+	}
+	fmt.Println(containerId)
+}
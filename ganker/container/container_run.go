@@ -4,41 +4,203 @@ import (
 	"fmt"
 	"go_docker_learning/ganker/cgroup"
 	"go_docker_learning/ganker/cgroup/subsystem"
+	"net"
 	"os"
+	"os/exec"
+	"sort"
 	"strconv"
+	"strings"
+	gosyscall "syscall"
+
+	syscall "golang.org/x/sys/unix"
 
 	"github.com/sirupsen/logrus"
 )
 
-func RunContainer(tty bool, comArray []string, imageName string, volume string, resourceConfig *subsystem.ResourceConfig, containerName, network string, env, portMapping []string) {
+// RunOptions is the configuration of a container given by ganker run
+type RunOptions struct {
+	Tty           bool
+	Command       []string
+	Image         string
+	Volume        string
+	Resource      *subsystem.ResourceConfig
+	Name          string
+	Network       string
+	Env           []string
+	User          string // user[:group] the command runs as, names are resolved in the container
+	PortMapping   []string
+	PublishAll    bool              // the ports exposed by the image are published on free ports of the host
+	UserNamespace *UserNamespace    // nil if the container shares the user namespace of the host
+	CgroupNs      string            // private or host, the default depends on the cgroup version
+	TimeOffsets   []TimeOffset      // the container runs in a new time namespace if it's not empty
+	Namespaces    map[string]string // the namespaces shared with the host or other containers, like "net": "container:<id>"
+	Hostname      string            // the id of the container is used if it's empty, unless the uts namespace is shared
+	Domainname    string
+	Devices       []Device // devices of the host added to the container
+	ShmSize       string   // size of /dev/shm, like "64m"
+	Capabilities  []string // capabilities kept by the container, resolved by CapabilityOptions
+	Privileged    bool     // all capabilities are kept, all devices are allowed and the syscalls are not filtered by default
+	Security      *SecurityOptions
+	ReadOnly      bool     // the rootfs is read only, /tmp and /run are writable tmpfs
+	MaskedPaths   []string // paths of /proc and /sys hidden in the container, they are not masked in a privileged container
+	ReadonlyPaths []string // paths of /proc and /sys made read only
+	Ulimits       []Rlimit
+	Sysctls       map[string]string // only the sysctls of the net and ipc namespaces owned by the container are allowed
+}
+
+func RunContainer(opts *RunOptions) {
+	if Rootless {
+		if err := prepareRootless(opts); err != nil {
+			logrus.Errorf("%v", err)
+			return
+		}
+	}
+
+	cgroupNs, err := checkCgroupNs(opts.CgroupNs)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	opts.CgroupNs = cgroupNs
+
+	joins, err := checkNamespaces(opts.Namespaces)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	if opts.Network != "" && opts.Namespaces["net"] != "" {
+		logrus.Errorf("--network can't be used with --net")
+		return
+	}
+	if opts.Namespaces["uts"] != "" && (opts.Hostname != "" || opts.Domainname != "") {
+		logrus.Errorf("--hostname and --domainname can't be used with --uts")
+		return
+	}
+
+	if err := checkSysctls(opts.Sysctls, opts.Namespaces); err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+
+	shmSize, err := subsystem.ParseBytes(opts.ShmSize)
+	if err != nil || shmSize == 0 {
+		logrus.Errorf("invalid shm size %s", opts.ShmSize)
+		return
+	}
+	// the image gives the command, the environment, the working dir, the user and the published ports by default
+	opts.Image = normalizeImageName(opts.Image)
+	image, err := getImage(opts.Image)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	if len(opts.Command) == 0 {
+		opts.Command = image.Config.Cmd
+	}
+	opts.Command = append(append([]string{}, image.Config.Entrypoint...), opts.Command...)
+	if len(opts.Command) == 0 {
+		logrus.Errorf("no command is given and the image %s has no command", opts.Image)
+		return
+	}
+	if opts.User == "" {
+		opts.User = image.Config.User
+	}
+	workingDir := image.Config.WorkingDir
+	if workingDir == "" {
+		workingDir = "/"
+	}
+	if opts.PublishAll && (opts.Network == "" || Rootless) {
+		logrus.Warnf("--publish-all is ignored, the ports are only published on a network when ganker is run by root")
+	} else if opts.PublishAll {
+		ports, err := publishPorts(image.Config.ExposedPorts, opts.PortMapping)
+		if err != nil {
+			logrus.Errorf("%v", err)
+			return
+		}
+		opts.PortMapping = append(opts.PortMapping, ports...)
+	}
+
+	// the device cgroup of a rootless container can't be set, as loading the eBPF device filter needs root
+	if !Rootless && !opts.Privileged {
+		opts.Resource.Devices = deviceRules(opts.Devices)
+	}
+
+	// the seccomp filter depends on the capabilities, as some syscalls are allowed with a capability
+	var seccompFilter []syscall.SockFilter
+	opts.Security.SeccompProfile, opts.Security.Seccomp = resolveSeccomp(opts.Security, opts.Privileged)
+	if opts.Security.SeccompProfile != nil {
+		if seccompFilter, err = compileSeccomp(opts.Security.SeccompProfile, opts.Capabilities); err != nil {
+			logrus.Errorf("compile seccomp profile error: %v", err)
+			return
+		}
+	}
+
 	id := generateContainerId(15)
-	if containerName == "" {
-		containerName = imageName + "-" + id[:10]
+	if opts.Name == "" {
+		opts.Name = opts.Image + "-" + id[:10]
+	}
+	if opts.Hostname == "" && opts.Namespaces["uts"] == "" {
+		opts.Hostname = id[:12]
+	}
+	env, err := containerEnv(image.Config.Env, opts)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
 	}
 
-	parent, writePipe, containerDir, containerId := initNewParentProcess(tty, id, imageName, volume, env)
+	parent, writePipe, containerDir, containerId := initNewParentProcess(id, opts)
 	if parent == nil {
 		logrus.Errorf("fail to init new parent process")
 		return
 	}
 
-	if err := parent.Start(); err != nil {
+	if err := startInNamespaces(parent, joins); err != nil {
 		logrus.Error(err)
 		return
 	}
 
-	if err := recordContainerInfo(strconv.Itoa(parent.Process.Pid), id, containerName, imageName, volume, portMapping, comArray); err != nil {
-		logrus.Error("fail to create container info: %v", err)
-		return
+	// the network stack of a rootless container is slirp4netns in user mode
+	networkPid := 0
+	if Rootless && opts.Network == NetworkSlirp4netns {
+		if networkPid, err = startSlirp4netns(parent.Process.Pid); err != nil {
+			logrus.Errorf("%v", err)
+			os.Exit(-1)
+		}
 	}
 
-	if network != "" {
+	if err := recordContainerInfo(strconv.Itoa(parent.Process.Pid), id, networkPid, opts); err != nil {
+		logrus.Errorf("fail to create container info: %v", err)
+		return
+	}
+	if opts.Security.SeccompProfile != nil {
+		if err := recordSeccompProfile(containerId, opts.Security.SeccompProfile); err != nil {
+			logrus.Errorf("%v", err)
+			os.Exit(-1)
+		}
+	}
 
+	// connect the container to the network, its ip is written into the hosts file
+	var ip net.IP
+	if opts.Network != "" && !Rootless {
+		if err := InitNet(); err != nil {
+			logrus.Errorf("%v", err)
+			os.Exit(-1)
+		}
+		endpoint, err := connectNetwork(containerId, opts.Network)
+		if err != nil {
+			logrus.Errorf("%v", err)
+			os.Exit(-1)
+		}
+		ip = endpoint.IP
 	}
 	// Initialize cGroup manager
-	cGroupManager := cgroup.NewCgroupManager("GankerCgroup" + "/" + containerId)
+	cgroupPath := getCgroupPath(containerId)
+	if cgroupPath == "" {
+		logrus.Warnf("the cgroup isn't delegated to the user, the resource limits are ignored")
+	}
+	cGroupManager := cgroup.NewCgroupManager(cgroupPath)
 	// set resource limitation
-	if err := cGroupManager.Set(resourceConfig); err != nil {
+	if err := cGroupManager.Set(opts.Resource); err != nil {
 		logrus.Errorf("%v", err)
 		os.Exit(-1)
 	}
@@ -47,29 +209,202 @@ func RunContainer(tty bool, comArray []string, imageName string, volume string,
 		logrus.Errorf("%v", err)
 		os.Exit(-1)
 	}
+	// watch the oom killer while the container is running in foreground
+	var oomNotify <-chan struct{}
+	if opts.Tty && cgroupPath != "" {
+		if oomNotify, err = cGroupManager.NotifyOOM(); err != nil {
+			logrus.Warnf("watch oom event error %v", err)
+		}
+	}
 	// send command to child process
-	if err := sendInitCommand(comArray, writePipe); err != nil {
+	initMessage := &InitMessage{
+		Args: opts.Command,
+		Env:  env,
+		Cwd:  workingDir,
+		User: opts.User,
+
+		Rlimits: opts.Ulimits,
+		Sysctls: opts.Sysctls,
+
+		Hostname:   opts.Hostname,
+		Domainname: opts.Domainname,
+
+		CgroupNs:    opts.CgroupNs == CgroupNsPrivate,
+		TimeOffsets: opts.TimeOffsets,
+
+		Devices: opts.Devices,
+		ShmSize: shmSize,
+
+		ReadonlyRootfs: opts.ReadOnly,
+
+		Capabilities:    opts.Capabilities,
+		Seccomp:         seccompFilter,
+		NoNewPrivileges: opts.Security.NoNewPrivileges,
+	}
+	if !opts.Privileged {
+		initMessage.MaskedPaths = opts.MaskedPaths
+		initMessage.ReadonlyPaths = opts.ReadonlyPaths
+	}
+	if opts.ReadOnly {
+		initMessage.Mounts = append(initMessage.Mounts, readOnlyTmpfs...)
+	}
+	// a rootless container mounts its overlay and volume in its own mount namespace
+	if Rootless {
+		if initMessage.Rootfs, err = rootfsMount(containerDir); err != nil {
+			logrus.Errorf("%v", err)
+			os.Exit(-1)
+		}
+		if opts.Volume != "" {
+			m, err := volumeMount(opts.Volume)
+			if err != nil {
+				logrus.Errorf("%v", err)
+				os.Exit(-1)
+			}
+			initMessage.Mounts = append(initMessage.Mounts, *m)
+		}
+	}
+	etcMounts, err := setupEtcFiles(containerId, opts, ip)
+	if err != nil {
+		logrus.Errorf("%v", err)
+		os.Exit(-1)
+	}
+	initMessage.Mounts = append(initMessage.Mounts, etcMounts...)
+	if err := sendInitCommand(initMessage, writePipe); err != nil {
 		logrus.Errorf("%v", err)
 		os.Exit(-1)
 	}
 	// if tty,it means that the container is running in foreground
-	if tty {
-		// wait for child process to exit
+	if opts.Tty {
+		// wait for child process to exit, a non-zero exit code is recorded instead of being an error
 		if err := parent.Wait(); err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				logrus.Errorf("%v", err)
+				os.Exit(-1)
+			}
+		}
+
+		oomKilled := cGroupManager.OOMKilled()
+		select {
+		case _, ok := <-oomNotify:
+			oomKilled = oomKilled || ok
+		default:
+		}
+		exitCode := getExitCode(parent.ProcessState)
+
+		// a stop or update of the container may be running at the same time
+		unlock, err := lockContainer(containerId)
+		if err != nil {
 			logrus.Errorf("%v", err)
 			os.Exit(-1)
 		}
+		defer unlock()
 
-		err := cGroupManager.Delete()
+		err = cGroupManager.Delete()
 		if err != nil {
 			logrus.Errorf("%v", err)
 			os.Exit(-1)
 		}
 
-		deleteWorkSpace(containerDir, volume)
-		quitContainer(containerId)
+		deleteWorkSpace(containerDir, opts.Volume)
+		quitContainer(containerId, &exitCode, oomKilled)
 
 	} else {
 		fmt.Println("containerId: ", containerId)
 	}
 }
+
+// defaultPath is the PATH of the container if the image doesn't set one
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// containerEnv returns the environment of the container, the env of the image and then the -e of ganker run
+// over a default PATH, HOSTNAME and TERM. Nothing of the host environment gets in, except a "KEY" of -e,
+// which takes the value of KEY in ganker like docker does. the control vars of ganker exec can't be set,
+// they would be read again by the nsenter constructor when a command is exec'd in the container
+func containerEnv(imageEnv []string, opts *RunOptions) ([]string, error) {
+	env := []string{"PATH=" + defaultPath}
+	if opts.Hostname != "" {
+		env = append(env, "HOSTNAME="+opts.Hostname)
+	}
+	if opts.Tty {
+		env = append(env, "TERM=xterm")
+	}
+	for _, e := range append(append([]string{}, imageEnv...), opts.Env...) {
+		key, value, ok := strings.Cut(e, "=")
+		if err := checkEnvKey(key); err != nil {
+			return nil, err
+		}
+		if !ok {
+			if value, ok = os.LookupEnv(key); !ok {
+				continue
+			}
+		}
+		env = setEnv(env, key, value)
+	}
+	return env, nil
+}
+
+// publishPorts maps the tcp and udp ports exposed by the image to free ports of the host, the ports already mapped
+// are skipped. a free port is found by binding port 0 and closing it, so another process may take it before the port
+// mapping is configured, network.ConfigurePortMapping checks the port again and fails then
+func publishPorts(exposedPorts map[string]struct{}, portMapping []string) ([]string, error) {
+	mapped := map[string]bool{}
+	for _, mapping := range portMapping {
+		if _, containerPort, ok := strings.Cut(mapping, ":"); ok {
+			if !strings.Contains(containerPort, "/") {
+				containerPort += "/tcp"
+			}
+			mapped[containerPort] = true
+		}
+	}
+	var ports []string
+	for exposedPort := range exposedPorts {
+		port, protocol, err := parseExposedPort(exposedPort)
+		if err != nil {
+			return nil, err
+		}
+		if mapped[fmt.Sprintf("%d/%s", port, protocol)] {
+			continue
+		}
+		hostPort, err := freePort(protocol)
+		if err != nil {
+			return nil, fmt.Errorf("publish port %s error: %v", exposedPort, err)
+		}
+		if protocol == "tcp" {
+			ports = append(ports, fmt.Sprintf("%d:%d", hostPort, port))
+		} else {
+			ports = append(ports, fmt.Sprintf("%d:%d/%s", hostPort, port, protocol))
+		}
+	}
+	sort.Strings(ports)
+	return ports, nil
+}
+
+// freePort returns a port of the host the kernel picks as free for the protocol
+func freePort(protocol string) (int, error) {
+	switch protocol {
+	case "tcp":
+		listener, err := net.Listen("tcp", ":0")
+		if err != nil {
+			return 0, err
+		}
+		defer listener.Close()
+		return listener.Addr().(*net.TCPAddr).Port, nil
+	case "udp":
+		conn, err := net.ListenPacket("udp", ":0")
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+	return 0, fmt.Errorf("only tcp and udp ports can be mapped")
+}
+
+// getExitCode returns the exit code of the process, it is 128+signal if the process was killed by a signal, like a shell does
+func getExitCode(state *os.ProcessState) int {
+	// the status of os.ProcessState is the WaitStatus of the syscall package, not the one of x/sys
+	if status, ok := state.Sys().(gosyscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
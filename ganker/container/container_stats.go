@@ -0,0 +1,261 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"go_docker_learning/ganker/cgroup"
+	"go_docker_learning/ganker/cgroup/subsystem"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StatsFormatTable prints the stats in a table
+	StatsFormatTable = "table"
+	// StatsFormatJson prints the stats in a json array
+	StatsFormatJson = "json"
+
+	// statsInterval is the interval between two samples of cpu usage
+	statsInterval = time.Second
+
+	// clockTicks is the USER_HZ of /proc/stat, it is 100 on almost all platforms
+	clockTicks = 100
+)
+
+// ContainerStats is the resource usage of a container at some moment
+type ContainerStats struct {
+	ContainerId   string  `json:"id"`
+	Name          string  `json:"name"`
+	CpuPercent    float64 `json:"cpu_percent"`
+	MemoryUsage   uint64  `json:"memory_usage"`
+	MemoryLimit   uint64  `json:"memory_limit"`
+	MemoryPercent float64 `json:"memory_percent"`
+	Pids          uint64  `json:"pids"`
+	BlockRead     uint64  `json:"block_read"`
+	BlockWrite    uint64  `json:"block_write"`
+}
+
+// cpuSample is the cpu time of a container and of the whole system at the same moment
+type cpuSample struct {
+	container uint64
+	system    uint64
+}
+
+// StatsContainers prints the resource usage of containers, all running containers are shown if containerIds is empty.
+// the output is refreshed every second unless noStream is set
+func StatsContainers(containerIds []string, noStream bool, format string) {
+	if format != StatsFormatTable && format != StatsFormatJson {
+		log.Errorf("Unknown stats format %s, it should be %s or %s", format, StatsFormatTable, StatsFormatJson)
+		return
+	}
+
+	previous := map[string]cpuSample{}
+	for {
+		infos := getStatsTargets(containerIds)
+		// cpu usage is a rate, a container needs one more sample before its first output
+		if hasUnsampled(infos, previous) {
+			collectStats(infos, previous)
+			time.Sleep(statsInterval)
+		}
+
+		stats := collectStats(infos, previous)
+		if err := printStats(stats, format, !noStream); err != nil {
+			log.Errorf("Print stats error %v", err)
+			return
+		}
+		if noStream {
+			return
+		}
+		time.Sleep(statsInterval)
+	}
+}
+
+// hasUnsampled checks if any of the containers has no cpu sample yet, it's false if there are no containers
+func hasUnsampled(infos []*Info, previous map[string]cpuSample) bool {
+	for _, info := range infos {
+		if _, ok := previous[info.ContainerId]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getStatsTargets returns the running and paused containers to show, a paused container uses no cpu like docker shows
+func getStatsTargets(containerIds []string) []*Info {
+	if len(containerIds) == 0 {
+		files, err := os.ReadDir(ContainerRootPath)
+		if err != nil {
+			log.Errorf("Read dir %s error %v", ContainerRootPath, err)
+			return nil
+		}
+		for _, file := range files {
+			containerIds = append(containerIds, file.Name())
+		}
+	}
+
+	var infos []*Info
+	for _, containerId := range containerIds {
+		containerInfo, err := getContainerInfo(containerId)
+		if err != nil {
+			log.Errorf("Get container %s info error %v", containerId, err)
+			continue
+		}
+		if containerInfo.Status == RUNNING || containerInfo.Status == PAUSED {
+			infos = append(infos, containerInfo)
+		}
+	}
+	return infos
+}
+
+// collectStats reads the cgroup of each container, previous is updated with the new cpu samples
+func collectStats(infos []*Info, previous map[string]cpuSample) []*ContainerStats {
+	systemUsage, err := getSystemCpuUsage()
+	if err != nil {
+		log.Errorf("Get system cpu usage error %v", err)
+		return nil
+	}
+	hostMemory := getHostMemory()
+
+	var result []*ContainerStats
+	for _, info := range infos {
+		cGroupManager := cgroup.NewCgroupManager(getCgroupPath(info.ContainerId))
+		stats, err := cGroupManager.GetStats()
+		if err != nil {
+			log.Errorf("Get container %s stats error %v", info.ContainerId, err)
+			continue
+		}
+
+		sample := cpuSample{container: stats.Cpu.TotalUsage, system: systemUsage}
+		result = append(result, newContainerStats(info, stats, previous[info.ContainerId], sample, hostMemory))
+		previous[info.ContainerId] = sample
+	}
+	return result
+}
+
+func newContainerStats(info *Info, stats *subsystem.Stats, previous, current cpuSample, hostMemory uint64) *ContainerStats {
+	containerStats := &ContainerStats{
+		ContainerId: info.ContainerId,
+		Name:        info.Name,
+		MemoryUsage: stats.Memory.Usage,
+		MemoryLimit: stats.Memory.Limit,
+		Pids:        stats.Pids.Current,
+		BlockRead:   stats.Blkio.ReadBytes,
+		BlockWrite:  stats.Blkio.WriteBytes,
+	}
+
+	// the same as docker, 100% means one cpu is fully used
+	if previous.system != 0 && current.system > previous.system && current.container >= previous.container {
+		cpuDelta := float64(current.container - previous.container)
+		systemDelta := float64(current.system - previous.system)
+		containerStats.CpuPercent = cpuDelta / systemDelta * float64(runtime.NumCPU()) * 100
+	}
+
+	// the limit of an unlimited cgroup is a huge number, show the host memory instead
+	if containerStats.MemoryLimit == 0 || (hostMemory != 0 && containerStats.MemoryLimit > hostMemory) {
+		containerStats.MemoryLimit = hostMemory
+	}
+	if containerStats.MemoryLimit != 0 {
+		containerStats.MemoryPercent = float64(containerStats.MemoryUsage) / float64(containerStats.MemoryLimit) * 100
+	}
+	return containerStats
+}
+
+func printStats(stats []*ContainerStats, format string, refresh bool) error {
+	if format == StatsFormatJson {
+		if stats == nil {
+			stats = []*ContainerStats{}
+		}
+		body, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(body))
+		return nil
+	}
+
+	if refresh {
+		// clear the screen and move the cursor to the top left
+		fmt.Print("\033[2J\033[H")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "ID\tNAME\tCPU %%\tMEM USAGE / LIMIT\tMEM %%\tPIDS\tBLOCK I/O\n")
+	for _, item := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%d\t%s / %s\n",
+			item.ContainerId,
+			item.Name,
+			item.CpuPercent,
+			formatBytes(item.MemoryUsage),
+			formatBytes(item.MemoryLimit),
+			item.MemoryPercent,
+			item.Pids,
+			formatBytes(item.BlockRead),
+			formatBytes(item.BlockWrite))
+	}
+	return w.Flush()
+}
+
+// getSystemCpuUsage returns the cpu time of the host in nanoseconds, summed up from the first line of /proc/stat
+func getSystemCpuUsage() (uint64, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+		var ticks uint64
+		for _, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parse /proc/stat error: %v", err)
+			}
+			ticks += value
+		}
+		return ticks * uint64(time.Second) / clockTicks, nil
+	}
+	return 0, fmt.Errorf("cpu line not found in /proc/stat")
+}
+
+// getHostMemory returns MemTotal of /proc/meminfo in bytes, 0 if it can't be read
+func getHostMemory() uint64 {
+	content, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			value, _ := strconv.ParseUint(fields[1], 10, 64)
+			return value * 1024
+		}
+	}
+	return 0
+}
+
+// formatBytes formats a size into a human readable string, like "12.5MiB"
+func formatBytes(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.2f%s", value, units[i])
+}
@@ -10,29 +10,51 @@ import (
 )
 
 func StopContainer(containerId string) {
+	unlock, err := lockContainer(containerId)
+	if err != nil {
+		log.Errorf("Lock container %s error %v", containerId, err)
+		return
+	}
+	defer unlock()
+
 	containerInfo, err := getContainerInfo(containerId)
 	if err != nil {
 		log.Errorf("Get container %s info error:  %v", containerId, err)
 		return
 	}
 
-	if containerInfo.Status != RUNNING {
+	if containerInfo.Status != RUNNING && containerInfo.Status != PAUSED {
 		log.Errorf("Container %s is not running now", containerId)
 		return
 	}
 
 	pid, err1 := strconv.Atoi(containerInfo.Pid)
 	if err1 != nil {
-		log.Errorf("Conver pid %s error %v", pid, err)
+		log.Errorf("Conver pid %s error %v", containerInfo.Pid, err1)
 		return
 	}
 
+	cGroupManager := cgroup.NewCgroupManager(getCgroupPath(containerId))
+	// a frozen process can't handle SIGTERM, thaw it first
+	if containerInfo.Status == PAUSED {
+		if err := cGroupManager.Thaw(); err != nil {
+			log.Errorf("Unpause container %s error %v", containerId, err)
+			return
+		}
+		containerInfo.Status = RUNNING
+		if err := dumpContainerInfo(containerInfo); err != nil {
+			log.Errorf("Dump container %s info error %v", containerId, err)
+			return
+		}
+	}
+
 	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
 		log.Errorf("Stop container %s error %v", containerId, err)
 		return
 	}
 
-	cGroupManager := cgroup.NewCgroupManager("GankerCgroup" + "/" + containerId)
+	// the oom kills of a detached container have no one watching them, read the count before the cgroup is gone
+	oomKilled := cGroupManager.OOMKilled()
 	if err := cGroupManager.Delete(); err != nil {
 		log.Errorf("Destroy cgroup fail %v", err)
 		return
@@ -41,7 +63,7 @@ func StopContainer(containerId string) {
 	containerDir := StorageRootPath + containerId + "/"
 	deleteWorkSpace(containerDir, containerInfo.Volume)
 
-	quitContainer(containerId)
+	quitContainer(containerId, nil, oomKilled)
 }
 
 func checkProcessIsAlive(pId int) bool {
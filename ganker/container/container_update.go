@@ -0,0 +1,158 @@
+package container
+
+import (
+	"fmt"
+	"go_docker_learning/ganker/cgroup"
+	"go_docker_learning/ganker/cgroup/subsystem"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	syscall "golang.org/x/sys/unix"
+)
+
+// UpdateContainer changes the resource limits of a running container,
+// only the non-empty fields of update are applied, the others keep their saved value
+func UpdateContainer(containerId string, update *subsystem.ResourceConfig) {
+	unlock, err := lockContainer(containerId)
+	if err != nil {
+		log.Errorf("Lock container %s error %v", containerId, err)
+		return
+	}
+	defer unlock()
+
+	containerInfo, err := getContainerInfo(containerId)
+	if err != nil {
+		log.Errorf("Get container %s info error %v", containerId, err)
+		return
+	}
+
+	if containerInfo.Status != RUNNING && containerInfo.Status != PAUSED {
+		log.Errorf("Container %s is not running now", containerId)
+		return
+	}
+
+	// containers created before the resource config was saved have no record of it
+	previous := &subsystem.ResourceConfig{}
+	if containerInfo.Resource != nil {
+		previous = containerInfo.Resource
+	}
+	resourceConfig := mergeResourceConfig(previous, update)
+
+	cGroupManager := cgroup.NewCgroupManager(getCgroupPath(containerId))
+	if err := checkResourceUpdate(cGroupManager, resourceConfig); err != nil {
+		log.Errorf("Update container %s error %v", containerId, err)
+		return
+	}
+
+	if err := cGroupManager.Update(resourceConfig); err != nil {
+		log.Errorf("Update container %s cgroup error %v", containerId, err)
+		// some subsystems may have been changed already, set them back
+		if err := cGroupManager.Update(previous); err != nil {
+			log.Errorf("Restore container %s cgroup error %v", containerId, err)
+		}
+		return
+	}
+
+	containerInfo.Resource = resourceConfig
+	if err := dumpContainerInfo(containerInfo); err != nil {
+		log.Errorf("Dump container %s info error %v", containerId, err)
+		return
+	}
+	fmt.Println(containerId)
+}
+
+// mergeResourceConfig returns a copy of previous with the non-empty fields of update, the device rates
+// of update replace the ones of the same devices
+func mergeResourceConfig(previous, update *subsystem.ResourceConfig) *subsystem.ResourceConfig {
+	merged := *previous
+	for _, field := range []struct {
+		target *string
+		value  string
+	}{
+		{&merged.Memory, update.Memory},
+		{&merged.CpuShare, update.CpuShare},
+		{&merged.CpuQuota, update.CpuQuota},
+		{&merged.Cpus, update.Cpus},
+		{&merged.Mems, update.Mems},
+		{&merged.PidsLimit, update.PidsLimit},
+		{&merged.BlkioWeight, update.BlkioWeight},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+
+	for _, field := range []struct {
+		target *[]string
+		value  []string
+	}{
+		{&merged.DeviceReadBps, update.DeviceReadBps},
+		{&merged.DeviceWriteBps, update.DeviceWriteBps},
+		{&merged.DeviceReadIops, update.DeviceReadIops},
+		{&merged.DeviceWriteIops, update.DeviceWriteIops},
+	} {
+		*field.target = mergeDeviceRates(*field.target, field.value)
+	}
+	return &merged
+}
+
+// mergeDeviceRates returns the rates of previous with the devices in update replaced, like "/dev/sda:1mb".
+// a rate of 0 in update removes the limit of the device, it's kept so the cgroup file of the device is cleared
+func mergeDeviceRates(previous, update []string) []string {
+	if len(update) == 0 {
+		return previous
+	}
+	updated := map[string]bool{}
+	for _, rate := range update {
+		updated[deviceOfRate(rate)] = true
+	}
+	var merged []string
+	for _, rate := range previous {
+		if !updated[deviceOfRate(rate)] {
+			merged = append(merged, rate)
+		}
+	}
+	return append(merged, update...)
+}
+
+// deviceOfRate returns the device path of a rate like "/dev/sda:1mb"
+func deviceOfRate(rate string) string {
+	if index := strings.LastIndex(rate, ":"); index > 0 {
+		return rate[:index]
+	}
+	return rate
+}
+
+// checkResourceUpdate rejects the limits that the kernel would refuse, and turns the memory limit into
+// the byte count, like "100m" into "104857600", which is what memory.limit_in_bytes and memory.max take.
+// Without swap the memory in use can't be pushed out, so the memory limit can't be below the usage
+func checkResourceUpdate(cGroupManager *cgroup.CgroupManager, resourceConfig *subsystem.ResourceConfig) error {
+	if resourceConfig.Memory == "" || resourceConfig.Memory == "-1" {
+		return nil
+	}
+
+	limit, err := subsystem.ParseBytes(resourceConfig.Memory)
+	if err != nil {
+		return fmt.Errorf("invalid memory limit %s: %v", resourceConfig.Memory, err)
+	}
+	resourceConfig.Memory = strconv.FormatUint(limit, 10)
+
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return fmt.Errorf("get system info error: %v", err)
+	}
+	if info.Totalswap != 0 {
+		return nil
+	}
+
+	stats, err := cGroupManager.GetStats()
+	if err != nil {
+		return fmt.Errorf("get cgroup stats error: %v", err)
+	}
+	if limit < stats.Memory.Usage {
+		return fmt.Errorf("memory limit %s is below the current usage %s, and there is no swap to hold the rest",
+			formatBytes(limit), formatBytes(stats.Memory.Usage))
+	}
+	return nil
+}
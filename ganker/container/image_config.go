@@ -0,0 +1,156 @@
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	json "github.com/goccy/go-json"
+)
+
+// ImageConfig is how a container of the image runs by default, the fields are named like the config of an OCI image
+type ImageConfig struct {
+	Cmd          []string            `json:"Cmd,omitempty"`          // the command if ganker run isn't given one
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`   // prepended to the command
+	Env          []string            `json:"Env,omitempty"`          // like "KEY=value", the ones of ganker run -e come after them
+	WorkingDir   string              `json:"WorkingDir,omitempty"`   // "/" if it's empty
+	User         string              `json:"User,omitempty"`         // the user if ganker run isn't given one
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"` // like "80/tcp", published by ganker run --publish-all
+	Labels       map[string]string   `json:"Labels,omitempty"`
+}
+
+// applyChanges changes the config by the dockerfile instructions given by ganker commit --change,
+// like "CMD [\"sh\", \"-c\", \"top\"]", "CMD top", "ENV KEY=value" or "WORKDIR /app"
+func (c *ImageConfig) applyChanges(changes []string) error {
+	cmdChanged := false
+	for _, change := range changes {
+		instruction, value, _ := strings.Cut(strings.TrimSpace(change), " ")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return fmt.Errorf("invalid change %s, it should be like <instruction> <value>", change)
+		}
+		switch strings.ToUpper(instruction) {
+		case "CMD":
+			c.Cmd = parseCommandForm(value)
+			cmdChanged = true
+		case "ENTRYPOINT":
+			c.Entrypoint = parseCommandForm(value)
+			// the inherited command was the arguments of the former entrypoint, like dockerfile
+			if !cmdChanged {
+				c.Cmd = nil
+			}
+		case "ENV":
+			pairs, err := parseKeyValues(value)
+			if err != nil {
+				return fmt.Errorf("invalid change %s: %v", change, err)
+			}
+			for _, pair := range pairs {
+				if err := checkEnvKey(pair[0]); err != nil {
+					return fmt.Errorf("invalid change %s: %v", change, err)
+				}
+				c.Env = setEnv(c.Env, pair[0], pair[1])
+			}
+		case "LABEL":
+			pairs, err := parseKeyValues(value)
+			if err != nil {
+				return fmt.Errorf("invalid change %s: %v", change, err)
+			}
+			if c.Labels == nil {
+				c.Labels = map[string]string{}
+			}
+			for _, pair := range pairs {
+				c.Labels[pair[0]] = pair[1]
+			}
+		case "EXPOSE":
+			if c.ExposedPorts == nil {
+				c.ExposedPorts = map[string]struct{}{}
+			}
+			for _, port := range strings.Fields(value) {
+				if _, _, err := parseExposedPort(port); err != nil {
+					return fmt.Errorf("invalid change %s: %v", change, err)
+				}
+				if !strings.Contains(port, "/") {
+					port += "/tcp"
+				}
+				c.ExposedPorts[port] = struct{}{}
+			}
+		case "USER":
+			c.User = value
+		case "WORKDIR":
+			// a relative dir is relative to the former one, like dockerfile
+			if !filepath.IsAbs(value) {
+				value = filepath.Join("/", c.WorkingDir, value)
+			}
+			c.WorkingDir = filepath.Clean(value)
+		default:
+			return fmt.Errorf("invalid change %s, only CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, USER and WORKDIR can be changed", change)
+		}
+	}
+	return nil
+}
+
+// parseExposedPort parses an exposed port like "80/tcp", the protocol is tcp if it's not given
+func parseExposedPort(port string) (int, string, error) {
+	number, protocol, _ := strings.Cut(port, "/")
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	value, err := strconv.Atoi(number)
+	if err != nil || value <= 0 || value > 65535 || (protocol != "tcp" && protocol != "udp" && protocol != "sctp") {
+		return 0, "", fmt.Errorf("invalid port %s, it should be like 80 or 80/tcp", port)
+	}
+	return value, protocol, nil
+}
+
+// parseCommandForm parses the exec form of CMD and ENTRYPOINT like ["sh", "-c", "top"],
+// the shell form like "top -b" is run by /bin/sh -c
+func parseCommandForm(value string) []string {
+	var command []string
+	if strings.HasPrefix(value, "[") && json.Unmarshal([]byte(value), &command) == nil {
+		return command
+	}
+	return []string{"/bin/sh", "-c", value}
+}
+
+// parseKeyValues parses "KEY=value OTHER=value", or "KEY value" which sets a single key to the rest of the line,
+// the values may be quoted like KEY="a b"
+func parseKeyValues(value string) ([][2]string, error) {
+	if key, rest, ok := strings.Cut(value, " "); ok && !strings.Contains(key, "=") {
+		return [][2]string{{key, strings.TrimSpace(rest)}}, nil
+	}
+
+	var pairs [][2]string
+	for value != "" {
+		key, rest, ok := strings.Cut(value, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s should be like KEY=value", value)
+		}
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %s", value)
+			}
+			val, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			val, rest, _ = strings.Cut(rest, " ")
+		}
+		pairs = append(pairs, [2]string{key, val})
+		value = strings.TrimSpace(rest)
+	}
+	return pairs, nil
+}
+
+// setEnv returns env with KEY=value, replacing the former value of the key. env itself isn't changed,
+// it may be the env of the parent image
+func setEnv(env []string, key, value string) []string {
+	env = append([]string{}, env...)
+	for i, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
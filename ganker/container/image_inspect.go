@@ -0,0 +1,76 @@
+package container
+
+import (
+	"fmt"
+	"runtime"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// imageDetail is what image inspect prints, the record of the image with the layers in the store
+type imageDetail struct {
+	Name         string        `json:"name"`
+	Id           string        `json:"id"`
+	Digest       string        `json:"digest,omitempty"` // the digest of the manifest in the registry
+	Parent       string        `json:"parent,omitempty"`
+	Created      string        `json:"created"`
+	Author       string        `json:"author,omitempty"`
+	Comment      string        `json:"comment,omitempty"`
+	Architecture string        `json:"architecture"`
+	OS           string        `json:"os"`
+	Size         int64         `json:"size"`
+	Config       ImageConfig   `json:"config"`
+	Layers       []layerDetail `json:"layers"` // the lowest one first
+	Containers   []string      `json:"containers,omitempty"`
+}
+
+type layerDetail struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Path   string `json:"path"` // the dir of the layer in the store
+}
+
+// InspectImage prints the detail of an image in json
+func InspectImage(image string) {
+	record, err := getImage(image)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	name := normalizeImageName(image)
+	detail := &imageDetail{
+		Name:         name,
+		Id:           imageID(record),
+		Digest:       record.Digest,
+		Parent:       record.Parent,
+		Created:      record.Created,
+		Author:       record.Author,
+		Comment:      record.Comment,
+		Architecture: record.Architecture,
+		OS:           record.OS,
+		Config:       record.Config,
+	}
+	if detail.OS == "" {
+		detail.Architecture, detail.OS = runtime.GOARCH, runtime.GOOS
+	}
+	for _, digest := range record.Layers {
+		size, err := layerSize(digest)
+		if err != nil {
+			log.Warnf("get the size of layer %s error %v", digest, err)
+		}
+		detail.Size += size
+		detail.Layers = append(detail.Layers, layerDetail{Digest: digest, Size: size, Path: getLayerDir(digest, nil)})
+	}
+	if detail.Containers, err = imageContainers(name); err != nil {
+		log.Warnf("get the containers of image %s error %v", name, err)
+	}
+
+	body, err := json.MarshalIndent(detail, "", "    ")
+	if err != nil {
+		log.Errorf("Json marshal error %v", err)
+		return
+	}
+	fmt.Println(string(body))
+}
@@ -0,0 +1,127 @@
+package container
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ListImages prints the images in the store, an image given as a tar is listed by the tar until it's imported
+// when it's used first, listing never changes the store
+func ListImages() {
+	names, err := listImageRecords()
+	if err != nil {
+		log.Errorf("list images error %v", err)
+		return
+	}
+	tars, err := listImageFiles(".tar")
+	if err != nil {
+		log.Errorf("list images error %v", err)
+		return
+	}
+	for _, tar := range tars {
+		if !contains(names, tar) {
+			names = append(names, tar)
+		}
+	}
+	sort.Strings(names)
+
+	sizes := map[string]int64{}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "REPOSITORY\tTAG\tIMAGE ID\tDIGEST\tCREATED\tSIZE\n")
+	for _, name := range names {
+		ref, err := parseImageReference(name)
+		if err != nil {
+			log.Errorf("%v", err)
+			continue
+		}
+		record, err := readImageRecord(name)
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("%v", err)
+			continue
+		}
+		tag, id, digest := ref.tag, "<none>", ref.digest
+		if tag == "" {
+			tag = "<none>"
+		}
+		var created string
+		var size int64
+		// the tar isn't imported yet, or it's replaced after it's imported
+		if tarInfo, err := os.Stat(ImageRootPath + name + ".tar"); err == nil &&
+			(record == nil || record.TarSize != 0 && !tarImported(record, tarInfo)) {
+			created, size = tarInfo.ModTime().Format("2006-01-02 15:04:05"), tarInfo.Size()
+		} else if record != nil {
+			id = strings.TrimPrefix(imageID(record), digestPrefix)[:12]
+			created, size = record.Created, imageSize(record, sizes)
+			if record.Digest != "" {
+				digest = record.Digest
+			}
+		} else {
+			log.Errorf("get image %s error %v", name, err)
+			continue
+		}
+		if digest == "" {
+			digest = "<none>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", ref.repositoryName(), tag, id, digest, created, formatBytes(uint64(size)))
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return
+	}
+}
+
+// imageID returns the id of an image, which is the digest of its config like docker. an image imported from a tar,
+// or recorded before the id was kept, has no config, the one with the diff ids of its layers in the store is used
+func imageID(record *ImageRecord) string {
+	if record.ID != "" {
+		return record.ID
+	}
+	data, _ := json.Marshal(imageConfigOf(record, record.Layers))
+	return contentDigest(data)
+}
+
+// imageSize returns the size of the files in the layers of the image, the sizes of the layers are cached
+// as the layers are shared by the images
+func imageSize(record *ImageRecord, sizes map[string]int64) int64 {
+	var total int64
+	for _, digest := range record.Layers {
+		size, ok := sizes[digest]
+		if !ok {
+			var err error
+			if size, err = layerSize(digest); err != nil {
+				log.Warnf("get the size of layer %s error %v", digest, err)
+			}
+			sizes[digest] = size
+		}
+		total += size
+	}
+	return total
+}
+
+// layerSize returns the size of the files in a layer of the store
+func layerSize(digest string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(filepath.Join(getLayerDir(digest, nil), LayerDiffName), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
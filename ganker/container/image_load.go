@@ -0,0 +1,324 @@
+package container
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// archivedImage is an image found in an unpacked archive, the paths are relative to the archive
+type archivedImage struct {
+	names        []string
+	config       string
+	configDigest string   // checked if it's not empty, the archives of docker save have no digests
+	layers       []string // the lowest one first
+	layerDigests []string // the digests of the compressed layers, checked if they're not empty
+}
+
+// LoadImage loads the images in an archive of the OCI image layout or an archive written by docker save,
+// which may be gzipped. the archive is read from stdin if input is empty
+func LoadImage(input string) {
+	var reader io.Reader = os.Stdin
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			log.Errorf("open %s error %v", input, err)
+			return
+		}
+		defer file.Close()
+		reader = file
+	}
+	archive, err := decompress(reader)
+	if err != nil {
+		log.Errorf("read the archive error %v", err)
+		return
+	}
+	defer archive.Close()
+
+	// the archive is unpacked aside in the layer store, which has room for the layers
+	dir, err := os.MkdirTemp(LayerRootPath, "tmp-load-")
+	if err != nil {
+		log.Errorf("create temp dir error %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	cmd := exec.Command("tar", "-xf", "-", "-C", dir)
+	cmd.Stdin = archive
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Errorf("unpack the archive error %v, %s", err, out)
+		return
+	}
+
+	images, err := readArchive(dir)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	for _, image := range images {
+		if err := loadArchivedImage(dir, image); err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+	}
+}
+
+// readArchive returns the images in an unpacked archive, the index.json of the OCI image layout is preferred
+// as docker save writes both since docker 25
+func readArchive(dir string) ([]archivedImage, error) {
+	if exists, err := checkFileOrDirExist(filepath.Join(dir, ociIndexFile)); err != nil {
+		return nil, err
+	} else if exists {
+		return readImageLayout(dir)
+	}
+	if exists, err := checkFileOrDirExist(filepath.Join(dir, dockerManifestFile)); err != nil {
+		return nil, err
+	} else if exists {
+		return readDockerArchive(dir)
+	}
+	return nil, fmt.Errorf("the archive has neither %s nor %s", ociIndexFile, dockerManifestFile)
+}
+
+// readImageLayout returns the images in the index.json of an OCI image layout
+func readImageLayout(dir string) ([]archivedImage, error) {
+	index := &ociIndex{}
+	if err := readArchiveJSON(dir, ociIndexFile, "", index); err != nil {
+		return nil, err
+	}
+
+	var images []archivedImage
+	for _, descriptor := range index.Manifests {
+		manifest, err := readLayoutManifest(dir, descriptor)
+		if err != nil {
+			return nil, err
+		}
+		image := archivedImage{
+			names:        imageNamesOf(descriptor.Annotations),
+			config:       blobPath(manifest.Config.Digest),
+			configDigest: manifest.Config.Digest,
+		}
+		for _, layer := range manifest.Layers {
+			image.layers = append(image.layers, blobPath(layer.Digest))
+			image.layerDigests = append(image.layerDigests, layer.Digest)
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// readLayoutManifest reads the manifest of the descriptor, the manifest of the platform of the host is chosen
+// if the descriptor points to an index
+func readLayoutManifest(dir string, descriptor ociDescriptor) (*ociManifest, error) {
+	if err := checkDigest(descriptor.Digest); err != nil {
+		return nil, err
+	}
+	if isIndex(descriptor.MediaType) {
+		index := &ociIndex{}
+		if err := readArchiveJSON(dir, blobPath(descriptor.Digest), descriptor.Digest, index); err != nil {
+			return nil, err
+		}
+		platformDescriptor, err := selectPlatform(index)
+		if err != nil {
+			return nil, err
+		}
+		return readLayoutManifest(dir, *platformDescriptor)
+	}
+
+	manifest := &ociManifest{}
+	if err := readArchiveJSON(dir, blobPath(descriptor.Digest), descriptor.Digest, manifest); err != nil {
+		return nil, err
+	}
+	if err := checkDigest(manifest.Config.Digest); err != nil {
+		return nil, err
+	}
+	for _, layer := range manifest.Layers {
+		if err := checkDigest(layer.Digest); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, nil
+}
+
+// readDockerArchive returns the images in the manifest.json written by docker save
+func readDockerArchive(dir string) ([]archivedImage, error) {
+	var manifests []dockerManifest
+	if err := readArchiveJSON(dir, dockerManifestFile, "", &manifests); err != nil {
+		return nil, err
+	}
+	var images []archivedImage
+	for _, manifest := range manifests {
+		images = append(images, archivedImage{
+			names:        manifest.RepoTags,
+			config:       manifest.Config,
+			layers:       manifest.Layers,
+			layerDigests: make([]string, len(manifest.Layers)),
+		})
+	}
+	return images, nil
+}
+
+// imageNamesOf returns the name of an image in the annotations of its descriptor in index.json.
+// the ref name is usually only the tag, it's taken as the name if it has a repository
+func imageNamesOf(annotations map[string]string) []string {
+	if name := annotations[annotationImageName]; name != "" {
+		return []string{name}
+	}
+	if name := annotations[annotationRefName]; strings.ContainsAny(name, ":/") {
+		return []string{name}
+	}
+	return nil
+}
+
+// loadArchivedImage imports the layers of an image into the layer store and records the image by its names,
+// an image without a name is named by the digest of its config like docker shows the id of an image
+func loadArchivedImage(dir string, image archivedImage) error {
+	configPath, err := archiveFile(dir, image.config)
+	if err != nil {
+		return err
+	}
+	configDigest, err := fileDigest(configPath)
+	if err != nil {
+		return err
+	}
+	if image.configDigest != "" && configDigest != image.configDigest {
+		return fmt.Errorf("config %s doesn't match its digest %s", image.config, image.configDigest)
+	}
+	config := &ociImage{}
+	if err := readArchiveJSON(dir, image.config, "", config); err != nil {
+		return err
+	}
+	if len(config.RootFS.DiffIDs) != len(image.layers) {
+		return fmt.Errorf("the image has %d layers but its config has %d", len(image.layers), len(config.RootFS.DiffIDs))
+	}
+	// the diff ids are the names of the layer dirs in the store
+	for _, diffID := range config.RootFS.DiffIDs {
+		if err := checkDigest(diffID); err != nil {
+			return err
+		}
+	}
+	warnPlatform(config)
+
+	for i, layer := range image.layers {
+		diffID := config.RootFS.DiffIDs[i]
+		// the layers shared with the images in the store are not imported again
+		if exists, err := checkFileOrDirExist(getLayerDir(diffID, nil)); err != nil || exists {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		layerPath, err := archiveFile(dir, layer)
+		if err != nil {
+			return err
+		}
+		if digest := image.layerDigests[i]; digest != "" {
+			if blobDigest, err := fileDigest(layerPath); err != nil {
+				return err
+			} else if blobDigest != digest {
+				return fmt.Errorf("layer %s doesn't match its digest %s", layer, digest)
+			}
+		}
+		if _, err := importLayer(layerPath, diffID); err != nil {
+			return err
+		}
+	}
+
+	record := imageRecordOf(config)
+	record.ID = configDigest
+	names := image.names
+	if len(names) == 0 {
+		names = []string{strings.TrimPrefix(configDigest, digestPrefix)[:12]}
+	}
+	for _, name := range names {
+		name = normalizeImageName(name)
+		if err := checkImageName(name); err != nil {
+			return err
+		}
+		if err := writeImageRecord(name, record); err != nil {
+			return err
+		}
+		log.Infof("load image %s success", name)
+	}
+	return nil
+}
+
+// imageRecordOf returns the record of an image by its config, the layers of the image are in the store
+func imageRecordOf(config *ociImage) *ImageRecord {
+	record := &ImageRecord{
+		Layers:       config.RootFS.DiffIDs,
+		Created:      recordTime(config.Created),
+		Author:       config.Author,
+		Config:       config.Config,
+		Architecture: config.Architecture,
+		OS:           config.OS,
+	}
+	if len(config.History) > 0 {
+		record.Comment = config.History[len(config.History)-1].Comment
+	}
+	return record
+}
+
+// archiveFile returns the path of a file in an unpacked archive, it must be a regular file in the archive
+func archiveFile(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if !strings.HasPrefix(path, filepath.Clean(dir)+"/") {
+		return "", fmt.Errorf("%s is out of the archive", name)
+	}
+	// a parent dir may be a symlink out of the archive too
+	for parent := filepath.Dir(path); parent != filepath.Clean(dir); parent = filepath.Dir(parent) {
+		if info, err := os.Lstat(parent); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%s is out of the archive", name)
+		}
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", fmt.Errorf("%s is not found in the archive", name)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", name)
+	}
+	return path, nil
+}
+
+// readArchiveJSON unmarshals a json file in an unpacked archive, the file is checked against the digest if it's not empty
+func readArchiveJSON(dir, name, digest string, v interface{}) error {
+	path, err := archiveFile(dir, name)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if digest != "" {
+		if hash := sha256.Sum256(content); digestPrefix+hex.EncodeToString(hash[:]) != digest {
+			return fmt.Errorf("%s doesn't match its digest %s", name, digest)
+		}
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("read %s error: %v", name, err)
+	}
+	return nil
+}
+
+// fileDigest returns the digest of the content of the file as it is
+func fileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("read %s error: %v", path, err)
+	}
+	return digestPrefix + hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,89 @@
+package container
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// defaultRegistry is the registry of the images named without a registry, like busybox
+	defaultRegistry = "docker.io"
+	// defaultTag is the tag of the images named without a tag, the images are stored without it
+	defaultTag = "latest"
+)
+
+// imageNamePattern matches the names like busybox, busybox:1.36, library/busybox, localhost:5000/app:v1
+// or busybox@sha256:<hex> of an image pulled by its digest
+var imageNamePattern = regexp.MustCompile(`^[a-z0-9]+([._-]+[a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-]+[a-z0-9]+)*)*(:[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+
+// imageReference is the name of an image split into the parts of the distribution api
+type imageReference struct {
+	registry   string // like docker.io or localhost:5000
+	repository string // like library/busybox
+	tag        string // empty if the image is named only by its digest
+	digest     string // the digest of the manifest, like sha256:<hex>
+}
+
+// normalizeImageName returns the name an image is stored by, the default registry and tag are left out,
+// so docker.io/library/busybox:latest, busybox:latest and busybox are all stored as busybox
+func normalizeImageName(name string) string {
+	name = strings.TrimPrefix(name, defaultRegistry+"/")
+	name = strings.TrimPrefix(name, "library/")
+	return strings.TrimSuffix(name, ":"+defaultTag)
+}
+
+// checkImageName checks if the name can be stored, the name is a path under ImageRootPath
+func checkImageName(name string) error {
+	if !imageNamePattern.MatchString(name) || strings.HasPrefix(name+"/", "layers/") {
+		return fmt.Errorf("invalid image name %s", name)
+	}
+	return nil
+}
+
+// parseImageReference splits the name of an image, the registry is docker.io if the first part of the name
+// isn't a host, and the tag is latest if the name has neither a tag nor a digest
+func parseImageReference(name string) (*imageReference, error) {
+	if err := checkImageName(normalizeImageName(name)); err != nil {
+		return nil, err
+	}
+	ref := &imageReference{}
+	name, ref.digest, _ = strings.Cut(name, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.tag = name[:i], name[i+1:]
+	} else if ref.digest == "" {
+		ref.tag = defaultTag
+	}
+
+	domain, _, found := strings.Cut(name, "/")
+	if !found || !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+		if !found {
+			name = "library/" + name
+		}
+		name = defaultRegistry + "/" + name
+	}
+	ref.registry, ref.repository, _ = strings.Cut(name, "/")
+	return ref, nil
+}
+
+// String returns the full name of the image, like docker.io/library/busybox:latest
+func (ref *imageReference) String() string {
+	name := ref.registry + "/" + ref.repository
+	if ref.tag != "" {
+		name += ":" + ref.tag
+	}
+	if ref.digest != "" {
+		name += "@" + ref.digest
+	}
+	return name
+}
+
+// repositoryName returns the repository without the default registry, like busybox or localhost:5000/app
+func (ref *imageReference) repositoryName() string {
+	return normalizeImageName(ref.registry + "/" + ref.repository)
+}
+
+// localName returns the name the image is stored by
+func (ref *imageReference) localName() string {
+	return normalizeImageName(ref.String())
+}
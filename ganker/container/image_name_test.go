@@ -0,0 +1,135 @@
+package container
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseImageReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		name       string
+		want       imageReference
+		full       string // the String of the reference
+		local      string // the name the image is stored by
+		repository string // the repositoryName of the reference
+	}{
+		{
+			name:       "busybox",
+			want:       imageReference{registry: "docker.io", repository: "library/busybox", tag: "latest"},
+			full:       "docker.io/library/busybox:latest",
+			local:      "busybox",
+			repository: "busybox",
+		},
+		{
+			name:       "library/busybox",
+			want:       imageReference{registry: "docker.io", repository: "library/busybox", tag: "latest"},
+			full:       "docker.io/library/busybox:latest",
+			local:      "busybox",
+			repository: "busybox",
+		},
+		{
+			name:       "docker.io/library/busybox:latest",
+			want:       imageReference{registry: "docker.io", repository: "library/busybox", tag: "latest"},
+			full:       "docker.io/library/busybox:latest",
+			local:      "busybox",
+			repository: "busybox",
+		},
+		{
+			name:       "busybox:1.36",
+			want:       imageReference{registry: "docker.io", repository: "library/busybox", tag: "1.36"},
+			full:       "docker.io/library/busybox:1.36",
+			local:      "busybox:1.36",
+			repository: "busybox",
+		},
+		{
+			name:       "user/app",
+			want:       imageReference{registry: "docker.io", repository: "user/app", tag: "latest"},
+			full:       "docker.io/user/app:latest",
+			local:      "user/app",
+			repository: "user/app",
+		},
+		{
+			name:       "localhost:5000/app:v1",
+			want:       imageReference{registry: "localhost:5000", repository: "app", tag: "v1"},
+			full:       "localhost:5000/app:v1",
+			local:      "localhost:5000/app:v1",
+			repository: "localhost:5000/app",
+		},
+		{
+			name:       "localhost:5000/app",
+			want:       imageReference{registry: "localhost:5000", repository: "app", tag: "latest"},
+			full:       "localhost:5000/app:latest",
+			local:      "localhost:5000/app",
+			repository: "localhost:5000/app",
+		},
+		{
+			name:       "localhost/app",
+			want:       imageReference{registry: "localhost", repository: "app", tag: "latest"},
+			full:       "localhost/app:latest",
+			local:      "localhost/app",
+			repository: "localhost/app",
+		},
+		{
+			name:       "example.com/a/b",
+			want:       imageReference{registry: "example.com", repository: "a/b", tag: "latest"},
+			full:       "example.com/a/b:latest",
+			local:      "example.com/a/b",
+			repository: "example.com/a/b",
+		},
+		{
+			name:       "busybox@" + digest,
+			want:       imageReference{registry: "docker.io", repository: "library/busybox", digest: digest},
+			full:       "docker.io/library/busybox@" + digest,
+			local:      "busybox@" + digest,
+			repository: "busybox",
+		},
+		{
+			name:       "localhost:5000/app:v1@" + digest,
+			want:       imageReference{registry: "localhost:5000", repository: "app", tag: "v1", digest: digest},
+			full:       "localhost:5000/app:v1@" + digest,
+			local:      "localhost:5000/app:v1@" + digest,
+			repository: "localhost:5000/app",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := parseImageReference(tt.name)
+			if err != nil {
+				t.Fatalf("parseImageReference(%q) error = %v", tt.name, err)
+			}
+			if *ref != tt.want {
+				t.Errorf("parseImageReference(%q) = %+v, want %+v", tt.name, *ref, tt.want)
+			}
+			if got := ref.String(); got != tt.full {
+				t.Errorf("String() = %q, want %q", got, tt.full)
+			}
+			if got := ref.localName(); got != tt.local {
+				t.Errorf("localName() = %q, want %q", got, tt.local)
+			}
+			if got := ref.repositoryName(); got != tt.repository {
+				t.Errorf("repositoryName() = %q, want %q", got, tt.repository)
+			}
+		})
+	}
+}
+
+func TestParseImageReferenceInvalid(t *testing.T) {
+	for _, name := range []string{
+		"",
+		"BusyBox",
+		"busybox:",
+		"busybox::1",
+		"/busybox",
+		"busybox/",
+		"../busybox",
+		"busybox@sha256:abc",
+		"busybox@md5:" + strings.Repeat("ab", 16),
+		"layers",
+		"layers/busybox",
+	} {
+		if ref, err := parseImageReference(name); err == nil {
+			t.Errorf("parseImageReference(%q) = %+v, want an error", name, *ref)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig    = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	mediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// annotationImageName is the full name of the image in the index of an image layout, like docker.io/library/busybox:latest
+	annotationImageName = "io.containerd.image.name"
+	// annotationRefName is the tag of the image in the index of an image layout, some tools put the name of the image in it
+	annotationRefName = "org.opencontainers.image.ref.name"
+
+	ociLayoutFile      = "oci-layout"
+	ociIndexFile       = "index.json"
+	dockerManifestFile = "manifest.json"
+	ociBlobsDir        = "blobs"
+)
+
+// digestPattern matches the sha256 digests, the only algorithm supported
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ociDescriptor points to a blob, like a manifest, a config or a layer
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ociIndex is the index.json of an image layout, or a manifest list pointing to the manifests of each platform
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociImage is the config blob of an image
+type ociImage struct {
+	Created      string       `json:"created,omitempty"`
+	Author       string       `json:"author,omitempty"`
+	Architecture string       `json:"architecture"`
+	OS           string       `json:"os"`
+	Variant      string       `json:"variant,omitempty"`
+	Config       ImageConfig  `json:"config"`
+	RootFS       ociRootFS    `json:"rootfs"`
+	History      []ociHistory `json:"history,omitempty"`
+}
+
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"` // the digests of the uncompressed layers, which are the digests in the layer store
+}
+
+type ociHistory struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// dockerManifest is an entry of the manifest.json written by docker save, the paths are relative to the archive
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// isIndex checks if the media type is an index of the manifests of several platforms
+func isIndex(mediaType string) bool {
+	return mediaType == mediaTypeOCIIndex || mediaType == mediaTypeDockerManifestList
+}
+
+// checkDigest checks the digest before it's used as a path
+func checkDigest(digest string) error {
+	if !digestPattern.MatchString(digest) {
+		return fmt.Errorf("invalid digest %s", digest)
+	}
+	return nil
+}
+
+// blobPath returns the path of a blob in an image layout, like blobs/sha256/<hex>
+func blobPath(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join(ociBlobsDir, algorithm, hex)
+}
+
+// selectPlatform returns the manifest of the platform of the host in the index
+func selectPlatform(index *ociIndex) (*ociDescriptor, error) {
+	var platforms []string
+	for i, manifest := range index.Manifests {
+		platform := manifest.Platform
+		if platform == nil {
+			continue
+		}
+		if platform.OS == runtime.GOOS && platform.Architecture == runtime.GOARCH &&
+			(runtime.GOARCH != "arm64" || platform.Variant == "" || platform.Variant == "v8") {
+			return &index.Manifests[i], nil
+		}
+		platforms = append(platforms, platform.OS+"/"+platform.Architecture)
+	}
+	return nil, fmt.Errorf("no manifest for %s/%s, the image is for %s", runtime.GOOS, runtime.GOARCH, strings.Join(platforms, ", "))
+}
+
+// warnPlatform warns if the image isn't for the platform of the host
+func warnPlatform(config *ociImage) {
+	if config.OS != "" && (config.OS != runtime.GOOS || config.Architecture != runtime.GOARCH) {
+		log.Warnf("the image is for %s/%s, it may not run on %s/%s", config.OS, config.Architecture, runtime.GOOS, runtime.GOARCH)
+	}
+}
+
+// ociTime converts the time of an image record to the RFC 3339 time of an image config
+func ociTime(created string) string {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", created, time.Local)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// recordTime converts the RFC 3339 time of an image config to the time of an image record
+func recordTime(created string) string {
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,97 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LayerDownloadsName is the dir in the layer store holding the layers being downloaded,
+// a layer left by a broken pull is resumed by the next pull
+const LayerDownloadsName = "downloads"
+
+// PullImage pulls an image from its registry into the layer store, the layers already in the store are not downloaded
+func PullImage(image, authFile string, insecure bool) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	client, err := newRegistryClient(ref, authFile, insecure, "pull")
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	if err := pullImage(client, ref); err != nil {
+		log.Errorf("pull image %s error %v", ref, err)
+	}
+}
+
+func pullImage(client *registryClient, ref *imageReference) error {
+	reference := ref.digest
+	if reference == "" {
+		reference = ref.tag
+	}
+	manifest, digest, err := client.fetchManifest(reference)
+	if err != nil {
+		return err
+	}
+	content, err := client.fetchBlob(manifest.Config)
+	if err != nil {
+		return err
+	}
+	config := &ociImage{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return fmt.Errorf("read config %s error: %v", manifest.Config.Digest, err)
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return fmt.Errorf("the image has %d layers but its config has %d", len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+	warnPlatform(config)
+
+	downloadDir := filepath.Join(LayerRootPath, LayerDownloadsName)
+	if err := os.MkdirAll(downloadDir, 0755); err != nil {
+		return err
+	}
+	for i, layer := range manifest.Layers {
+		diffID := config.RootFS.DiffIDs[i]
+		if err := checkDigest(diffID); err != nil {
+			return err
+		}
+		// the layers shared with the images in the store are not downloaded again
+		if exists, err := checkFileOrDirExist(getLayerDir(diffID, nil)); err != nil {
+			return err
+		} else if exists {
+			log.Infof("layer %s already exists", diffID)
+			continue
+		}
+		log.Infof("pull layer %s", layer.Digest)
+		path := filepath.Join(downloadDir, strings.TrimPrefix(layer.Digest, digestPrefix))
+		if err := client.downloadBlob(layer, path); err != nil {
+			return err
+		}
+		if _, err := importLayer(path, diffID); err != nil {
+			return err
+		}
+		// the blob is kept so pushing the image uploads nothing the registry doesn't have
+		if err := storeLayerBlob(diffID, path, layer); err != nil {
+			log.Warnf("keep the blob of layer %s error %v", diffID, err)
+		}
+		// the blob isn't moved if it can't be kept or the layer has one already
+		os.Remove(path)
+	}
+
+	name := ref.localName()
+	record := imageRecordOf(config)
+	record.ID, record.Digest = manifest.Config.Digest, digest
+	if err := writeImageRecord(name, record); err != nil {
+		return err
+	}
+	log.Infof("pull image %s success", name)
+	return nil
+}
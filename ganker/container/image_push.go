@@ -0,0 +1,89 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PushImage pushes an image to the registry of the destination, which is the name of the image if it's empty.
+// the pulled layers are pushed as the blobs they are pulled as, the others are packed like ganker save does.
+// the blobs the repository has already are not uploaded
+func PushImage(image, destination, authFile string, insecure bool) {
+	if destination == "" {
+		destination = image
+	}
+	ref, err := parseImageReference(destination)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	if ref.tag == "" {
+		log.Errorf("the image is pushed by a tag, %s has none", destination)
+		return
+	}
+	record, err := getImage(image)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	client, err := newRegistryClient(ref, authFile, insecure, "pull,push")
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+
+	dir, err := os.MkdirTemp(LayerRootPath, "tmp-push-")
+	if err != nil {
+		log.Errorf("create temp dir error %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	if err := pushImage(client, ref, dir, record); err != nil {
+		log.Errorf("push image %s error %v", ref, err)
+		return
+	}
+	if err := writeImageRecord(image, record); err != nil {
+		log.Errorf("record the digest of image %s error %v", image, err)
+	}
+}
+
+func pushImage(client *registryClient, ref *imageReference, dir string, record *ImageRecord) error {
+	manifest, err := writeImageBlobs(dir, record, map[string]savedLayer{})
+	if err != nil {
+		return err
+	}
+	descriptor, err := writeJSONBlob(dir, mediaTypeOCIManifest, manifest)
+	if err != nil {
+		return err
+	}
+
+	// the config is uploaded after the layers, as a registry may check the blobs a manifest points to
+	blobs := append(append([]ociDescriptor{}, manifest.Layers...), manifest.Config)
+	for _, blob := range blobs {
+		if exists, err := client.blobExists(blob.Digest); err != nil {
+			return err
+		} else if exists {
+			log.Infof("blob %s already exists", blob.Digest)
+			continue
+		}
+		log.Infof("push blob %s", blob.Digest)
+		if err := client.uploadBlob(filepath.Join(dir, blobPath(blob.Digest)), blob); err != nil {
+			return err
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, blobPath(descriptor.Digest)))
+	if err != nil {
+		return fmt.Errorf("read the manifest error: %v", err)
+	}
+	if err := client.putManifest(ref.tag, descriptor, content); err != nil {
+		return err
+	}
+	// the config is written again from the record, the id is the one of the config pushed
+	record.ID, record.Digest = manifest.Config.Digest, descriptor.Digest
+	log.Infof("push image %s success, digest %s", ref, descriptor.Digest)
+	return nil
+}
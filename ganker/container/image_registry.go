@@ -0,0 +1,590 @@
+package container
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// dockerHubHost serves the distribution api of docker.io
+	dockerHubHost = "registry-1.docker.io"
+	// maxManifestSize limits the manifests and the configs read into memory
+	maxManifestSize = 16 << 20
+	// downloadRetries is how many times a broken download is resumed before pull gives up
+	downloadRetries = 3
+)
+
+// DefaultAuthFile keeps the credentials of the registries, it has the format of the config.json of docker,
+// so the file written by docker login can be used as it is
+var DefaultAuthFile = dataRoot() + "auth.json"
+
+// challengePattern matches the parameters of a WWW-Authenticate header, like realm="https://auth.docker.io/token"
+var challengePattern = regexp.MustCompile(`([A-Za-z_]+)="([^"]*)"`)
+
+// errDownloadBroken is returned if the connection is broken during a download, which is resumed then
+var errDownloadBroken = errors.New("the download is broken")
+
+// registryAuthFile is the credentials file, the auth of a registry is the base64 of username:password
+type registryAuthFile struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+// registryError is the body of an error response of the distribution api
+type registryError struct {
+	Errors []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// registryClient speaks the distribution api to a repository of a registry
+type registryClient struct {
+	ref      *imageReference
+	endpoint string // like https://registry-1.docker.io/v2/library/busybox
+	actions  string // the actions the token is asked for, pull or pull,push
+	username string
+	password string
+	basic    bool   // the registry asks for the credentials in each request
+	token    string // given by the auth server of the registry
+	client   *http.Client
+}
+
+// newRegistryClient connects to the registry of the image. a registry on localhost or given as insecure is
+// tried with https first and then with plain http, like the insecure registries of docker
+func newRegistryClient(ref *imageReference, authFile string, insecure bool, actions string) (*registryClient, error) {
+	username, password, err := readCredentials(authFile, ref.registry)
+	if err != nil {
+		return nil, err
+	}
+	host := ref.registry
+	if host == defaultRegistry {
+		host = dockerHubHost
+	}
+	insecure = insecure || isLocalRegistry(host)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	c := &registryClient{
+		ref:      ref,
+		actions:  actions,
+		username: username,
+		password: password,
+		client:   &http.Client{Transport: transport},
+	}
+
+	err = c.ping("https://" + host)
+	if err != nil && insecure {
+		log.Debugf("ping %s with https error %v, try http", ref.registry, err)
+		err = c.ping("http://" + host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s error: %v", ref.registry, err)
+	}
+	return c, nil
+}
+
+// ping checks if the registry speaks the distribution api, and answers its challenge if it asks for the credentials
+func (c *registryClient) ping(base string) error {
+	resp, err := c.client.Get(base + "/v2/")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	c.endpoint = base + "/v2/" + c.ref.repository
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return c.authenticate(resp.Header.Get("WWW-Authenticate"))
+	}
+	return fmt.Errorf("the registry doesn't speak the distribution api: %v", responseError(resp))
+}
+
+// authenticate answers the challenge of the registry, a token is fetched from the auth server for the bearer scheme,
+// and the credentials are sent in each request for the basic scheme
+func (c *registryClient) authenticate(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.username == "" {
+			return fmt.Errorf("%s needs the credentials, add them into the credentials file", c.ref.registry)
+		}
+		c.basic = true
+		return nil
+	case "bearer":
+		return c.fetchToken(params)
+	}
+	return fmt.Errorf("unsupported auth scheme %q of %s", scheme, c.ref.registry)
+}
+
+// fetchToken fetches a token of the repository from the auth server given by the challenge,
+// the token is anonymous if there're no credentials of the registry
+func (c *registryClient) fetchToken(params string) error {
+	values := map[string]string{}
+	for _, match := range challengePattern.FindAllStringSubmatch(params, -1) {
+		values[strings.ToLower(match[1])] = match[2]
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || realm.Scheme == "" {
+		return fmt.Errorf("invalid auth realm %q of %s", values["realm"], c.ref.registry)
+	}
+	query := realm.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	query.Set("scope", "repository:"+c.ref.repository+":"+c.actions)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("get the token of %s error: %v", c.ref.registry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get the token of %s error: %v", c.ref.registry, responseError(resp))
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&token); err != nil {
+		return fmt.Errorf("read the token of %s error: %v", c.ref.registry, err)
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("the auth server of %s gives no token", c.ref.registry)
+	}
+	return nil
+}
+
+// do sends the request with the credentials, it's sent again once the challenge is answered
+// if the registry asks for the credentials again, like when the token expires
+func (c *registryClient) do(req *http.Request) (*http.Response, error) {
+	c.authorize(req)
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if challenge == "" || c.basic || req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if err := c.authenticate(challenge); err != nil {
+		return nil, err
+	}
+	if req.GetBody != nil {
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	c.authorize(req)
+	return c.client.Do(req)
+}
+
+// authorize puts the token or the credentials into the request
+func (c *registryClient) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	} else if c.basic {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// fetchManifest fetches the manifest of the tag or the digest, the manifest of the platform of the host
+// is chosen if it's a manifest list. it returns the digest of the manifest the reference points to as well,
+// which is the one of the manifest list if there's one
+func (c *registryClient) fetchManifest(reference string) (*ociManifest, string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint+"/manifests/"+reference, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		mediaTypeOCIIndex, mediaTypeOCIManifest, mediaTypeDockerManifestList, mediaTypeDockerManifest,
+	}, ", "))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("get manifest %s error: %v", reference, responseError(resp))
+	}
+	content, err := readLimited(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("read manifest %s error: %v", reference, err)
+	}
+	// a manifest fetched by a tag is checked against the digest told by the registry
+	expected := resp.Header.Get("Docker-Content-Digest")
+	if digestPattern.MatchString(reference) {
+		expected = reference
+	}
+	digest := contentDigest(content)
+	if expected != "" && digest != expected {
+		return nil, "", fmt.Errorf("manifest %s doesn't match its digest %s", reference, expected)
+	}
+
+	// the media type in the manifest is preferred, some registries serve every manifest as json
+	index := &ociIndex{}
+	if err := json.Unmarshal(content, index); err != nil {
+		return nil, "", fmt.Errorf("read manifest %s error: %v", reference, err)
+	}
+	mediaType, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if index.MediaType != "" {
+		mediaType = index.MediaType
+	}
+	switch mediaType {
+	case mediaTypeOCIIndex, mediaTypeDockerManifestList:
+		descriptor, err := selectPlatform(index)
+		if err != nil {
+			return nil, "", err
+		}
+		if err := checkDigest(descriptor.Digest); err != nil {
+			return nil, "", err
+		}
+		manifest, _, err := c.fetchManifest(descriptor.Digest)
+		return manifest, digest, err
+	case mediaTypeOCIManifest, mediaTypeDockerManifest:
+		manifest := &ociManifest{}
+		if err := json.Unmarshal(content, manifest); err != nil {
+			return nil, "", fmt.Errorf("read manifest %s error: %v", reference, err)
+		}
+		if err := checkDigest(manifest.Config.Digest); err != nil {
+			return nil, "", err
+		}
+		for _, layer := range manifest.Layers {
+			if err := checkDigest(layer.Digest); err != nil {
+				return nil, "", err
+			}
+		}
+		return manifest, digest, nil
+	}
+	return nil, "", fmt.Errorf("unsupported manifest type %q of %s", mediaType, reference)
+}
+
+// fetchBlob fetches a small blob like a config into memory
+func (c *registryClient) fetchBlob(descriptor ociDescriptor) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, c.endpoint+"/blobs/"+descriptor.Digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get blob %s error: %v", descriptor.Digest, responseError(resp))
+	}
+	content, err := readLimited(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read blob %s error: %v", descriptor.Digest, err)
+	}
+	if contentDigest(content) != descriptor.Digest {
+		return nil, fmt.Errorf("blob %s doesn't match its digest", descriptor.Digest)
+	}
+	return content, nil
+}
+
+// downloadBlob downloads a blob into the file, the file left by a broken download is resumed from where it's broken.
+// the file is removed if it doesn't match the digest of the blob
+func (c *registryClient) downloadBlob(descriptor ociDescriptor, path string) error {
+	_, err := os.Stat(path)
+	resumed := err == nil
+	for retries := 0; ; retries++ {
+		err := c.resumeDownload(descriptor, path)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errDownloadBroken) || retries == downloadRetries {
+			return err
+		}
+		log.Warnf("%v, resume it", err)
+	}
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+	if digest != descriptor.Digest {
+		os.Remove(path)
+		// the file left by an earlier pull may be broken, the blob is downloaded again from the start
+		if resumed {
+			log.Warnf("the download of blob %s resumed doesn't match its digest, download it again", descriptor.Digest)
+			return c.downloadBlob(descriptor, path)
+		}
+		return fmt.Errorf("blob %s doesn't match its digest", descriptor.Digest)
+	}
+	return nil
+}
+
+// resumeDownload downloads the rest of a blob into the file by a range request
+func (c *registryClient) resumeDownload(descriptor ociDescriptor, path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset == descriptor.Size {
+		return nil
+	}
+	if offset > descriptor.Size {
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if offset, err = file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.endpoint+"/blobs/"+descriptor.Digest, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("%w: get blob %s error: %v", errDownloadBroken, descriptor.Digest, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-", offset)) {
+			return fmt.Errorf("get blob %s error: unexpected range %q", descriptor.Digest, contentRange)
+		}
+	case http.StatusOK:
+		// the registry doesn't support the range requests, the whole blob is downloaded again
+		if offset > 0 {
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+			if offset, err = file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("get blob %s error: %v", descriptor.Digest, responseError(resp))
+	}
+
+	written, err := io.Copy(file, io.LimitReader(resp.Body, descriptor.Size-offset))
+	if err == nil && offset+written < descriptor.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("%w: blob %s at %d of %d bytes: %v", errDownloadBroken, descriptor.Digest, offset+written, descriptor.Size, err)
+	}
+	return nil
+}
+
+// blobExists checks if the repository has the blob
+func (c *registryClient) blobExists(digest string) (bool, error) {
+	req, err := http.NewRequest(http.MethodHead, c.endpoint+"/blobs/"+digest, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("check blob %s error: %v", digest, responseError(resp))
+}
+
+// uploadBlob uploads the file as a blob, the whole blob is put in one request once the upload is started
+func (c *registryClient) uploadBlob(path string, descriptor ociDescriptor) error {
+	req, err := http.NewRequest(http.MethodPost, c.endpoint+"/blobs/uploads/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("start the upload of blob %s error: %v", descriptor.Digest, responseError(resp))
+	}
+	if resp.Header.Get("Location") == "" {
+		return fmt.Errorf("start the upload of blob %s error: the registry gives no location", descriptor.Digest)
+	}
+	// the location may be relative to the request, and may have a query already
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+	query := location.Query()
+	query.Set("digest", descriptor.Digest)
+	location.RawQuery = query.Encode()
+
+	req, err = http.NewRequest(http.MethodPut, location.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return os.Open(path)
+	}
+	if req.Body, err = req.GetBody(); err != nil {
+		return err
+	}
+	req.ContentLength = descriptor.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if resp, err = c.do(req); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("upload blob %s error: %v", descriptor.Digest, responseError(resp))
+	}
+	return nil
+}
+
+// putManifest puts the manifest by the tag, the registry must store it by the same digest
+func (c *registryClient) putManifest(tag string, descriptor ociDescriptor, content []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.endpoint+"/manifests/"+tag, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", descriptor.MediaType)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("put manifest %s error: %v", tag, responseError(resp))
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" && digest != descriptor.Digest {
+		return fmt.Errorf("the registry stores the manifest as %s instead of %s", digest, descriptor.Digest)
+	}
+	return nil
+}
+
+// responseError returns the error of an unexpected response, with the errors in its body if there're any
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	errs := &registryError{}
+	if json.Unmarshal(body, errs) != nil || len(errs.Errors) == 0 {
+		return errors.New(resp.Status)
+	}
+	var messages []string
+	for _, e := range errs.Errors {
+		messages = append(messages, strings.TrimSpace(e.Code+" "+e.Message))
+	}
+	return fmt.Errorf("%s, %s", resp.Status, strings.Join(messages, "; "))
+}
+
+// readCredentials returns the username and the password of the registry in the credentials file, the default
+// file is used if authFile is empty, and there're no credentials if it doesn't exist
+func readCredentials(authFile, registry string) (string, string, error) {
+	path := authFile
+	if path == "" {
+		path = DefaultAuthFile
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) && authFile == "" {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	auths := &registryAuthFile{}
+	if err := json.Unmarshal(content, auths); err != nil {
+		return "", "", fmt.Errorf("read %s error: %v", path, err)
+	}
+	for key, auth := range auths.Auths {
+		if credentialRegistry(key) != registry {
+			continue
+		}
+		if auth.Auth == "" {
+			return auth.Username, auth.Password, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		username, password, found := strings.Cut(string(decoded), ":")
+		if err != nil || !found {
+			return "", "", fmt.Errorf("invalid auth of %s in %s", key, path)
+		}
+		return username, password, nil
+	}
+	return "", "", nil
+}
+
+// credentialRegistry returns the registry of a key in the credentials file,
+// the keys may be urls like https://index.docker.io/v1/ as docker login writes them
+func credentialRegistry(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key, _, _ = strings.Cut(key, "/")
+	if key == "index.docker.io" || key == dockerHubHost {
+		return defaultRegistry
+	}
+	return key
+}
+
+// isLocalRegistry checks if the registry is on the host
+func isLocalRegistry(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// readLimited reads a body no larger than maxManifestSize
+func readLimited(reader io.Reader) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(reader, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxManifestSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxManifestSize)
+	}
+	return content, nil
+}
+
+// contentDigest returns the digest of the content
+func contentDigest(content []byte) string {
+	hash := sha256.Sum256(content)
+	return digestPrefix + hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,93 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RemoveImages removes the images, and the layers no other image or container uses. an image used by a container
+// is only removed by force, the container keeps its layers until it's removed then
+func RemoveImages(images []string, force bool) {
+	for _, image := range images {
+		if err := removeImage(image, force); err != nil {
+			log.Errorf("remove image %s error %v", image, err)
+			continue
+		}
+		log.Infof("remove image %s success", image)
+	}
+}
+
+func removeImage(image string, force bool) error {
+	image = normalizeImageName(image)
+	if err := checkImageName(image); err != nil {
+		return err
+	}
+	record, err := readImageRecord(image)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	tarPath := ImageRootPath + image + ".tar"
+	tarExists, err := checkFileOrDirExist(tarPath)
+	if err != nil {
+		return err
+	}
+	if record == nil && !tarExists {
+		return fmt.Errorf("image %s not found", image)
+	}
+
+	containers, err := imageContainers(image)
+	if err != nil {
+		return err
+	}
+	if len(containers) > 0 {
+		if !force {
+			return fmt.Errorf("the image is used by container %s, remove the containers or use --force", strings.Join(containers, ", "))
+		}
+		log.Warnf("the image is used by container %s", strings.Join(containers, ", "))
+	}
+
+	if tarExists {
+		if err := os.Remove(tarPath); err != nil {
+			return err
+		}
+	}
+	if record == nil {
+		return nil
+	}
+	recordPath := ImageRootPath + image + ".json"
+	if err := os.Remove(recordPath); err != nil {
+		return err
+	}
+	// the dirs of the repository are removed with its last image
+	imageRoot := filepath.Clean(ImageRootPath)
+	for dir := filepath.Dir(recordPath); dir != imageRoot && strings.HasPrefix(dir, imageRoot); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	pruneLayers(record.Layers)
+	return nil
+}
+
+// imageContainers returns the ids of the containers created from the image
+func imageContainers(image string) ([]string, error) {
+	entries, err := os.ReadDir(ContainerRootPath)
+	if err != nil {
+		return nil, err
+	}
+	var containers []string
+	for _, entry := range entries {
+		containerInfo, err := getContainerFileInfo(entry.Name())
+		if err != nil {
+			continue
+		}
+		if normalizeImageName(containerInfo.Image) == image {
+			containers = append(containers, containerInfo.ContainerId)
+		}
+	}
+	return containers, nil
+}
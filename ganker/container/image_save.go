@@ -0,0 +1,255 @@
+package container
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// savedLayer is a layer written into an image layout, a layer shared by the images is written once
+type savedLayer struct {
+	descriptor ociDescriptor
+	diffID     string
+}
+
+// SaveImage saves the images into an archive of the OCI image layout, the archive also has the manifest.json
+// of docker save so the older docker can load it. the archive is written to stdout if output is empty
+func SaveImage(images []string, output string) {
+	if output == "" {
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			log.Errorf("the archive can't be written to a terminal, use --output or redirect the output")
+			return
+		}
+	}
+
+	dir, err := os.MkdirTemp(LayerRootPath, "tmp-save-")
+	if err != nil {
+		log.Errorf("create temp dir error %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	index := &ociIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex}
+	var manifests []dockerManifest
+	layers := map[string]savedLayer{}
+	for _, image := range images {
+		descriptor, manifest, err := saveImageLayout(dir, image, layers)
+		if err != nil {
+			log.Errorf("save image %s error %v", image, err)
+			return
+		}
+		index.Manifests = append(index.Manifests, *descriptor)
+		manifests = append(manifests, *manifest)
+	}
+	files := map[string]interface{}{
+		ociLayoutFile:      map[string]string{"imageLayoutVersion": "1.0.0"},
+		ociIndexFile:       index,
+		dockerManifestFile: manifests,
+	}
+	for name, value := range files {
+		content, err := json.Marshal(value)
+		if err != nil {
+			log.Errorf("%v", err)
+			return
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			log.Errorf("write %s error %v", name, err)
+			return
+		}
+	}
+
+	archive := output
+	if archive == "" {
+		archive = "-"
+	}
+	cmd := exec.Command("tar", "-cf", archive, "--owner=0", "--group=0", "--numeric-owner",
+		"-C", dir, ociLayoutFile, ociIndexFile, dockerManifestFile, ociBlobsDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Errorf("write the archive error %v", err)
+		if output != "" {
+			os.Remove(output)
+		}
+	}
+}
+
+// saveImageLayout writes the blobs of the image into the image layout, it returns the descriptor of the manifest
+// for index.json and the entry of manifest.json
+func saveImageLayout(dir, image string, layers map[string]savedLayer) (*ociDescriptor, *dockerManifest, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return nil, nil, err
+	}
+	record, err := getImage(image)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest, err := writeImageBlobs(dir, record, layers)
+	if err != nil {
+		return nil, nil, err
+	}
+	descriptor, err := writeJSONBlob(dir, mediaTypeOCIManifest, manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	descriptor.Annotations = map[string]string{annotationImageName: ref.String()}
+
+	entry := &dockerManifest{Config: blobPath(manifest.Config.Digest)}
+	// docker save keeps only the tags, an image pulled by its digest is saved without a name in manifest.json
+	if ref.tag != "" {
+		descriptor.Annotations[annotationRefName] = ref.tag
+		entry.RepoTags = []string{ref.repositoryName() + ":" + ref.tag}
+	}
+	for _, layer := range manifest.Layers {
+		entry.Layers = append(entry.Layers, blobPath(layer.Digest))
+	}
+	return &descriptor, entry, nil
+}
+
+// writeImageBlobs writes the layers and the config of the image as the blobs of an image layout,
+// and returns the manifest of them
+func writeImageBlobs(dir string, record *ImageRecord, layers map[string]savedLayer) (*ociManifest, error) {
+	// the files of the layers are owned by the user in rootless mode, they are root in the image
+	var userNs *UserNamespace
+	if Rootless {
+		userNs = rootlessUserNamespace()
+	}
+
+	manifest := &ociManifest{SchemaVersion: 2, MediaType: mediaTypeOCIManifest}
+	var diffIDs []string
+	for _, digest := range record.Layers {
+		layer, ok := layers[digest]
+		if !ok {
+			var err error
+			if layer, ok, err = linkLayerBlob(dir, digest); err != nil {
+				return nil, err
+			}
+			if !ok {
+				if layer, err = writeLayerBlob(dir, digest, userNs); err != nil {
+					return nil, err
+				}
+			}
+			layers[digest] = layer
+		}
+		diffIDs = append(diffIDs, layer.diffID)
+		manifest.Layers = append(manifest.Layers, layer.descriptor)
+	}
+	config := imageConfigOf(record, diffIDs)
+
+	var err error
+	if manifest.Config, err = writeJSONBlob(dir, mediaTypeOCIConfig, config); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// imageConfigOf returns the OCI config of the image whose layers have the diff ids
+func imageConfigOf(record *ImageRecord, diffIDs []string) *ociImage {
+	config := &ociImage{
+		Created:      ociTime(record.Created),
+		Author:       record.Author,
+		Architecture: record.Architecture,
+		OS:           record.OS,
+		Config:       record.Config,
+		RootFS:       ociRootFS{Type: "layers", DiffIDs: diffIDs},
+	}
+	if config.OS == "" {
+		config.Architecture, config.OS = runtime.GOARCH, runtime.GOOS
+	}
+	for range diffIDs {
+		config.History = append(config.History, ociHistory{Created: config.Created})
+	}
+	if len(config.History) > 0 {
+		config.History[len(config.History)-1].Author = record.Author
+		config.History[len(config.History)-1].Comment = record.Comment
+	}
+	return config
+}
+
+// linkLayerBlob links the blob a layer is pulled as into the image layout, it's false if the layer has no blob.
+// the blob of a docker image is the same gzipped tar as an OCI layer
+func linkLayerBlob(dir, digest string) (savedLayer, bool, error) {
+	descriptor, err := readLayerBlob(digest)
+	if err != nil || descriptor == nil {
+		return savedLayer{}, false, err
+	}
+	if descriptor.MediaType == mediaTypeDockerLayerGzip {
+		descriptor.MediaType = mediaTypeOCILayerGzip
+	}
+	path := filepath.Join(dir, blobPath(descriptor.Digest))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return savedLayer{}, false, err
+	}
+	if err := os.Link(filepath.Join(getLayerDir(digest, nil), LayerBlobName), path); err != nil && !os.IsExist(err) {
+		return savedLayer{}, false, fmt.Errorf("link the blob of layer %s error: %v", digest, err)
+	}
+	return savedLayer{descriptor: *descriptor, diffID: digest}, true, nil
+}
+
+// writeLayerBlob packs a layer in the store into a gzipped blob of the image layout, for a layer without a pulled blob.
+// the tar is packed again from the files of the layer, so its diff id may differ from the digest in the store
+func writeLayerBlob(dir, digest string, userNs *UserNamespace) (savedLayer, error) {
+	file, err := os.CreateTemp(dir, "layer-")
+	if err != nil {
+		return savedLayer{}, err
+	}
+	defer file.Close()
+	if err := file.Chmod(0644); err != nil {
+		return savedLayer{}, err
+	}
+
+	blobHash, diffHash := sha256.New(), sha256.New()
+	gzipWriter := gzip.NewWriter(io.MultiWriter(file, blobHash))
+	if err := archiveLayer(filepath.Join(getLayerDir(digest, nil), LayerDiffName), io.MultiWriter(gzipWriter, diffHash), userNs); err != nil {
+		return savedLayer{}, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return savedLayer{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return savedLayer{}, err
+	}
+
+	blobDigest := digestPrefix + hex.EncodeToString(blobHash.Sum(nil))
+	path := filepath.Join(dir, blobPath(blobDigest))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return savedLayer{}, err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return savedLayer{}, fmt.Errorf("write layer %s error: %v", digest, err)
+	}
+	return savedLayer{
+		descriptor: ociDescriptor{MediaType: mediaTypeOCILayerGzip, Digest: blobDigest, Size: info.Size()},
+		diffID:     digestPrefix + hex.EncodeToString(diffHash.Sum(nil)),
+	}, nil
+}
+
+// writeJSONBlob writes the value as a json blob of the image layout
+func writeJSONBlob(dir, mediaType string, value interface{}) (ociDescriptor, error) {
+	content, err := json.Marshal(value)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	hash := sha256.Sum256(content)
+	digest := digestPrefix + hex.EncodeToString(hash[:])
+	path := filepath.Join(dir, blobPath(digest))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return ociDescriptor{}, err
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(content))}, nil
+}
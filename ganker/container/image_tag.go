@@ -0,0 +1,37 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TagImage names the image by another name, the names share the layers of the image.
+// the image named by the target before is replaced
+func TagImage(source, target string) {
+	record, err := getImage(source)
+	if err != nil {
+		log.Errorf("%v", err)
+		return
+	}
+	if err := tagImage(record, target); err != nil {
+		log.Errorf("tag image %s error %v", source, err)
+		return
+	}
+	log.Infof("tag image %s as %s success", source, target)
+}
+
+func tagImage(record *ImageRecord, target string) error {
+	target = normalizeImageName(target)
+	if err := checkImageName(target); err != nil {
+		return err
+	}
+	if strings.Contains(target, "@") {
+		return fmt.Errorf("can't tag the image by a digest, %s", target)
+	}
+	// the tar and the digest in the registry belong to the source name
+	tagged := *record
+	tagged.Digest, tagged.TarSize, tagged.TarModTime = "", 0, 0
+	return writeImageRecord(target, &tagged)
+}
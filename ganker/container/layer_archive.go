@@ -0,0 +1,279 @@
+package container
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+const (
+	// whiteoutPrefix marks a file deleted from the lower layers in a layer tar, like ".wh.foo" for foo
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque marks a dir whose files in the lower layers are hidden in a layer tar
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// opaqueXattrs are the xattrs of overlay marking an opaque dir, the user one is used in a user namespace
+var opaqueXattrs = []string{"trusted.overlay.opaque", "user.overlay.opaque"}
+
+// getOpaqueXattr returns the xattr marking an opaque dir in the layer store, a rootless overlay is mounted
+// with userxattr as the trusted xattrs can't be set by the user
+func getOpaqueXattr() string {
+	if Rootless {
+		return opaqueXattrs[1]
+	}
+	return opaqueXattrs[0]
+}
+
+// isWhiteout checks if the file is a whiteout of overlay, which is a char device with 0/0 device number
+func isWhiteout(path string, info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	var stat syscall.Stat_t
+	return syscall.Lstat(path, &stat) == nil && stat.Rdev == 0
+}
+
+// isOpaque checks if the dir is an opaque dir of overlay
+func isOpaque(path string) bool {
+	value := make([]byte, 1)
+	for _, xattr := range opaqueXattrs {
+		if n, err := syscall.Lgetxattr(path, xattr, value); err == nil && n == 1 && value[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+// xattrRecords returns the xattrs of the file as the PAX records GNU tar --xattrs writes, like
+// "SCHILY.xattr.security.capability". the xattrs of overlay are left out, they're whiteouts in the tar
+func xattrRecords(path string) (map[string]string, error) {
+	size, err := syscall.Llistxattr(path, nil)
+	if err == syscall.ENOTSUP || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]byte, size)
+	if size, err = syscall.Llistxattr(path, names); err != nil {
+		return nil, err
+	}
+
+	records := map[string]string{}
+	for _, name := range strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00") {
+		if name == "" || strings.HasPrefix(name, "trusted.overlay.") || strings.HasPrefix(name, "user.overlay.") {
+			continue
+		}
+		valueSize, err := syscall.Lgetxattr(path, name, nil)
+		if err == syscall.ENODATA {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get xattr %s of %s error: %v", name, path, err)
+		}
+		value := make([]byte, valueSize)
+		if valueSize, err = syscall.Lgetxattr(path, name, value); err != nil {
+			return nil, fmt.Errorf("get xattr %s of %s error: %v", name, path, err)
+		}
+		records["SCHILY.xattr."+name] = string(value[:valueSize])
+	}
+	return records, nil
+}
+
+// archiveLayer packs the dir of a layer in overlay format, like the upper dir of a container, into an uncompressed tar.
+// the whiteouts are packed as ".wh." files and the opaque dirs get a ".wh..wh..opq" file, the same as an OCI layer.
+// the xattrs are packed as PAX records, so the file capabilities are kept like they're unpacked by importLayer.
+// if userNs is not nil, the host ids of the files are replaced by the ids in the container,
+// files owned by unmapped ids are packed as owned by root
+func archiveLayer(dir string, writer io.Writer, userNs *UserNamespace) error {
+	tarWriter := tar.NewWriter(writer)
+
+	// hard links are packed as links to the first path of the inode
+	links := map[uint64]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		// the same names as "tar -C dir ." gives, like "./" and "./bin/sh"
+		header.Name = "./"
+		if name != "." {
+			header.Name += name
+			if info.IsDir() {
+				header.Name += "/"
+			}
+		}
+		// the names are looked up in the image when it's extracted
+		header.Uname, header.Gname = "", ""
+		// info.Sys() is a stat of the syscall package, not the one of x/sys
+		var stat syscall.Stat_t
+		if err := syscall.Lstat(path, &stat); err != nil {
+			return err
+		}
+		if userNs != nil {
+			header.Uid, _ = toContainer(userNs.UidMappings, int(stat.Uid))
+			header.Gid, _ = toContainer(userNs.GidMappings, int(stat.Gid))
+			if header.Uid < 0 {
+				header.Uid = 0
+			}
+			if header.Gid < 0 {
+				header.Gid = 0
+			}
+		}
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			if first, ok := links[stat.Ino]; ok {
+				header.Typeflag = tar.TypeLink
+				header.Linkname = first
+				header.Size = 0
+			} else {
+				links[stat.Ino] = header.Name
+			}
+		}
+
+		if isWhiteout(path, info) {
+			header.Typeflag = tar.TypeReg
+			header.Name = "./" + filepath.Join(filepath.Dir(name), whiteoutPrefix+info.Name())
+			header.Mode, header.Size, header.Devmajor, header.Devminor = 0644, 0, 0, 0
+			return tarWriter.WriteHeader(header)
+		}
+		if header.PAXRecords, err = xattrRecords(path); err != nil {
+			return err
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() && name != "." && isOpaque(path) {
+			opaque := *header
+			opaque.Typeflag = tar.TypeReg
+			opaque.Name = header.Name + whiteoutOpaque
+			opaque.Mode, opaque.Size, opaque.PAXRecords = 0644, 0, nil
+			return tarWriter.WriteHeader(&opaque)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		content, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		_, err = io.Copy(tarWriter, content)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("archive %s error: %v", dir, err)
+	}
+	return tarWriter.Close()
+}
+
+// convertWhiteouts turns the ".wh." files of an unpacked layer into the whiteouts and opaque dirs of overlay,
+// so the layer can be a lowerdir. the files are kept if they can't be converted, fuse-overlayfs and the vfs
+// driver understand them too
+func convertWhiteouts(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if !strings.HasPrefix(name, whiteoutPrefix) || info.IsDir() {
+			return nil
+		}
+		parent := filepath.Dir(path)
+		if name == whiteoutOpaque {
+			if err := syscall.Lsetxattr(parent, getOpaqueXattr(), []byte("y"), 0); err != nil {
+				return nil
+			}
+			return os.Remove(path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		target := filepath.Join(parent, strings.TrimPrefix(name, whiteoutPrefix))
+		if err := syscall.Mknod(target, syscall.S_IFCHR, 0); err != nil {
+			// the whiteout can't be created by an unprivileged user before linux 5.8
+			return os.WriteFile(path, nil, 0644)
+		}
+		return nil
+	})
+}
+
+// applyLayer copies a layer onto the rootfs like overlay merges it: the files hidden by the whiteouts
+// and the opaque dirs of the layer are removed from the rootfs first, then the other files are copied
+func applyLayer(layerDir, rootfs string, copyDir func(source, target string) error) error {
+	var whiteouts []string
+	err := filepath.Walk(layerDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(layerDir, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case isWhiteout(path, info):
+			whiteouts = append(whiteouts, name)
+			return os.RemoveAll(filepath.Join(rootfs, name))
+		case info.Name() == whiteoutOpaque || (info.IsDir() && name != "." && isOpaque(path)):
+			dir := name
+			if !info.IsDir() {
+				dir = filepath.Dir(name)
+				whiteouts = append(whiteouts, name)
+			}
+			return removeDirContents(filepath.Join(rootfs, dir))
+		case strings.HasPrefix(info.Name(), whiteoutPrefix):
+			whiteouts = append(whiteouts, name)
+			return os.RemoveAll(filepath.Join(rootfs, filepath.Dir(name), strings.TrimPrefix(info.Name(), whiteoutPrefix)))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := copyDir(layerDir, rootfs); err != nil {
+		return err
+	}
+	// the whiteouts themselves are copied too, they are not files of the rootfs
+	for _, whiteout := range whiteouts {
+		if err := os.RemoveAll(filepath.Join(rootfs, whiteout)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDirContents removes everything in the dir, it's fine if the dir doesn't exist
+func removeDirContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
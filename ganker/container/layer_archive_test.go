@@ -0,0 +1,214 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+const (
+	kindFile     = "file"
+	kindWhiteout = "whiteout"
+	kindOpaque   = "opaque"
+)
+
+// the same layer as the files of an OCI layer tar, and as a dir in the overlay format
+var whiteoutTests = []struct {
+	name    string
+	oci     []string          // the files in the tar, the dirs of them are left out
+	overlay map[string]string // the files, whiteouts and opaque dirs in overlay
+}{
+	{
+		name:    "whiteout",
+		oci:     []string{"a/.wh.foo"},
+		overlay: map[string]string{"a/foo": kindWhiteout},
+	},
+	{
+		name:    "whiteout at the root",
+		oci:     []string{".wh.dir"},
+		overlay: map[string]string{"dir": kindWhiteout},
+	},
+	{
+		name:    "opaque dir",
+		oci:     []string{"b/.wh..wh..opq", "b/x"},
+		overlay: map[string]string{"b": kindOpaque, "b/x": kindFile},
+	},
+	{
+		name:    "whiteout in an opaque dir",
+		oci:     []string{"d/.wh..wh..opq", "d/e/.wh.f"},
+		overlay: map[string]string{"d": kindOpaque, "d/e/f": kindWhiteout},
+	},
+	{
+		name:    "not a whiteout",
+		oci:     []string{"c/.whale", "c/wh.foo"},
+		overlay: map[string]string{"c/.whale": kindFile, "c/wh.foo": kindFile},
+	},
+}
+
+// requireOverlayFormat skips the test if the whiteouts and the trusted xattrs of overlay can't be created
+func requireOverlayFormat(t *testing.T) string {
+	if os.Geteuid() != 0 {
+		t.Skip("the whiteouts and opaque dirs of overlay need root")
+	}
+	dir := t.TempDir()
+	if err := syscall.Lsetxattr(dir, opaqueXattrs[0], []byte("y"), 0); err != nil {
+		t.Skipf("the file system of %s has no trusted xattrs: %v", dir, err)
+	}
+	if err := syscall.Lremovexattr(dir, opaqueXattrs[0]); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// overlayFiles describes the files, whiteouts and opaque dirs in the dir
+func overlayFiles(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+		switch {
+		case isWhiteout(path, info):
+			files[name] = kindWhiteout
+		case info.IsDir() && isOpaque(path):
+			files[name] = kindOpaque
+		case !info.IsDir():
+			files[name] = kindFile
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestConvertWhiteouts(t *testing.T) {
+	for _, tt := range whiteoutTests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := requireOverlayFormat(t)
+			for _, name := range tt.oci {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := convertWhiteouts(dir); err != nil {
+				t.Fatalf("convertWhiteouts error = %v", err)
+			}
+			if got := overlayFiles(t, dir); !reflect.DeepEqual(got, tt.overlay) {
+				t.Errorf("convertWhiteouts gives %v, want %v", got, tt.overlay)
+			}
+		})
+	}
+}
+
+func TestArchiveLayerWhiteouts(t *testing.T) {
+	for _, tt := range whiteoutTests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := requireOverlayFormat(t)
+			for name, kind := range tt.overlay {
+				path := filepath.Join(dir, name)
+				if kind == kindOpaque {
+					if err := os.MkdirAll(path, 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := syscall.Lsetxattr(path, opaqueXattrs[0], []byte("y"), 0); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if kind == kindWhiteout {
+					if err := syscall.Mknod(path, syscall.S_IFCHR, 0); err != nil {
+						t.Fatal(err)
+					}
+				} else if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var layer bytes.Buffer
+			if err := archiveLayer(dir, &layer, nil); err != nil {
+				t.Fatalf("archiveLayer error = %v", err)
+			}
+			reader := tar.NewReader(&layer)
+			var got []string
+			for {
+				header, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Typeflag == tar.TypeDir {
+					// the opaque xattr is the ".wh..wh..opq" file in the tar
+					for key := range header.PAXRecords {
+						if strings.Contains(key, "overlay.") {
+							t.Errorf("%s is packed with the xattr %s", header.Name, key)
+						}
+					}
+					continue
+				}
+				if header.Typeflag != tar.TypeReg || header.Size != 0 {
+					t.Errorf("%s is packed as type %c of size %d, want an empty file", header.Name, header.Typeflag, header.Size)
+				}
+				got = append(got, strings.TrimPrefix(header.Name, "./"))
+			}
+			want := append([]string{}, tt.oci...)
+			sort.Strings(got)
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("archiveLayer packs %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestArchiveLayerXattrs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Lsetxattr(path, "user.ganker", []byte("value"), 0); err != nil {
+		t.Skipf("the file system of %s has no user xattrs: %v", dir, err)
+	}
+
+	var layer bytes.Buffer
+	if err := archiveLayer(dir, &layer, nil); err != nil {
+		t.Fatalf("archiveLayer error = %v", err)
+	}
+	reader := tar.NewReader(&layer)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			t.Fatal("./file is not packed")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name != "./file" {
+			continue
+		}
+		if got := header.PAXRecords["SCHILY.xattr.user.ganker"]; got != "value" {
+			t.Errorf("the xattr user.ganker of ./file is packed as %q, want %q", got, "value")
+		}
+		return
+	}
+}
@@ -0,0 +1,518 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	json "github.com/goccy/go-json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// LayerDiffName is the dir holding the unpacked files of a layer
+	LayerDiffName = "diff"
+	// LayerRefsName is the dir holding an empty file for each container using a layer
+	LayerRefsName = "refs"
+	// LayersFileName lists the layers used by a container in its storage dir
+	LayersFileName = "layers.json"
+	// LayerBlobName is the compressed tar a layer is pulled as, it's pushed and saved as it is
+	LayerBlobName = "blob"
+	// LayerBlobDescriptorName is the descriptor of the blob in the manifest the layer is pulled by
+	LayerBlobDescriptorName = "blob.json"
+
+	digestPrefix = "sha256:"
+)
+
+// ImageRecord is kept in ImageRootPath/<image>.json, it lists the layers of an image in the layer store.
+// an image given as ImageRootPath/<image>.tar is imported as a single layer, the size and the modification time
+// of the tar are kept so the image is imported again if the tar is replaced
+type ImageRecord struct {
+	Layers       []string    `json:"layers"`       // digests of the layers, the lowest one first
+	Parent       string      `json:"parent"`       // the image of the container the image is committed from
+	Created      string      `json:"created"`      // the time the image is committed, or the time the tar is modified
+	Author       string      `json:"author"`       // given by ganker commit --author
+	Comment      string      `json:"comment"`      // given by ganker commit --message
+	Config       ImageConfig `json:"config"`       // how a container of the image runs by default
+	Architecture string      `json:"architecture"` // the platform of a loaded image, the one of the host if it's empty
+	OS           string      `json:"os"`
+	ID           string      `json:"id"`           // the digest of the config of the image, like the image id of docker
+	Digest       string      `json:"digest"`       // the digest of the manifest in the registry the image is pulled from or pushed to last
+	TarSize      int64       `json:"tar size"`     // size of the imported tar, 0 if the image isn't from a tar
+	TarModTime   int64       `json:"tar mod time"` // modification time of the imported tar in nanoseconds
+}
+
+// getImage returns the record of the image, the tar of the image is imported into the layer store
+// the first time it's used, so every layer is only unpacked once however many containers use it
+func getImage(image string) (*ImageRecord, error) {
+	image = normalizeImageName(image)
+	tarPath := ImageRootPath + image + ".tar"
+	tarInfo, tarErr := os.Stat(tarPath)
+	record, err := readImageRecord(image)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if record != nil && (tarErr != nil || record.TarSize == 0 || tarImported(record, tarInfo)) {
+		return record, nil
+	}
+	if tarErr != nil {
+		if os.IsNotExist(tarErr) {
+			return nil, fmt.Errorf("image %s not found", image)
+		}
+		return nil, tarErr
+	}
+
+	log.Infof("import image %s into the layer store", image)
+	digest, err := importLayer(tarPath, "")
+	if err != nil {
+		return nil, err
+	}
+	previous := record
+	record = &ImageRecord{
+		Layers:     []string{digest},
+		Created:    tarInfo.ModTime().Format("2006-01-02 15:04:05"),
+		TarSize:    tarInfo.Size(),
+		TarModTime: tarInfo.ModTime().UnixNano(),
+	}
+	if err := writeImageRecord(image, record); err != nil {
+		return nil, err
+	}
+	// the layers of the tar before it was changed are left to the containers still using them
+	if previous != nil {
+		pruneLayers(previous.Layers)
+	}
+	return record, nil
+}
+
+// tarImported checks if the record is imported from the tar as it is now, the tar may be replaced after it
+func tarImported(record *ImageRecord, tarInfo os.FileInfo) bool {
+	return record.TarSize == tarInfo.Size() && record.TarModTime == tarInfo.ModTime().UnixNano()
+}
+
+// readImageRecord reads the record of the image, the error is os.ErrNotExist if the image has no record
+func readImageRecord(image string) (*ImageRecord, error) {
+	content, err := os.ReadFile(ImageRootPath + normalizeImageName(image) + ".json")
+	if err != nil {
+		return nil, err
+	}
+	record := &ImageRecord{}
+	if err := json.Unmarshal(content, record); err != nil {
+		return nil, fmt.Errorf("read image %s record error: %v", image, err)
+	}
+	return record, nil
+}
+
+// writeImageRecord writes the record of the image into a temp file and renames it, so it's replaced atomically
+func writeImageRecord(image string, record *ImageRecord) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	recordPath := ImageRootPath + normalizeImageName(image) + ".json"
+	// the images of a repository like library/busybox are kept in the dir of the repository
+	if err := os.MkdirAll(filepath.Dir(recordPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(recordPath+".tmp", content, 0644); err != nil {
+		return fmt.Errorf("write image %s record error: %v", image, err)
+	}
+	return os.Rename(recordPath+".tmp", recordPath)
+}
+
+// importLayer unpacks the tar, which may be compressed, into the layer store and returns the digest of the layer,
+// which is the sha256 of the uncompressed tar. the tar must have the digest diffID if it's not empty.
+// a layer already in the store is not unpacked again. the whiteouts in the tar are converted to the ones of overlay
+func importLayer(tarPath, diffID string) (string, error) {
+	digest, err := tarDigest(tarPath)
+	if err != nil {
+		return "", err
+	}
+	if diffID != "" && digest != diffID {
+		return "", fmt.Errorf("layer %s doesn't match its diff id %s", tarPath, diffID)
+	}
+	layerDir := getLayerDir(digest, nil)
+	if exists, err := checkFileOrDirExist(layerDir); err != nil || exists {
+		return digest, err
+	}
+
+	// the layer is unpacked aside and renamed into the store, a layer in the store is always complete
+	tmpDir, err := newLayerTmpDir()
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(filepath.Join(tmpDir, LayerDiffName), 0755); err != nil {
+		return "", err
+	}
+	// the owners are kept as the ids in the tar and not looked up in the users of the host, and the permissions,
+	// file capabilities and other xattrs are kept too
+	tarCmd := exec.Command("tar", "--numeric-owner", "--xattrs", "--xattrs-include=*", "-xpf", tarPath,
+		"-C", filepath.Join(tmpDir, LayerDiffName))
+	if out, err := tarCmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("unpack layer %s error: %v, %s", tarPath, err, out)
+	}
+	if err := convertWhiteouts(filepath.Join(tmpDir, LayerDiffName)); err != nil {
+		return "", fmt.Errorf("convert the whiteouts of layer %s error: %v", tarPath, err)
+	}
+	// another ganker may have unpacked the same layer meanwhile
+	if err := os.Rename(tmpDir, layerDir); err != nil && !os.IsExist(err) {
+		if exists, _ := checkFileOrDirExist(layerDir); !exists {
+			return "", fmt.Errorf("store layer %s error: %v", digest, err)
+		}
+	}
+	return digest, nil
+}
+
+// storeLayerBlob moves the blob a layer is pulled as into the dir of the layer, so the layer is pushed again
+// by the same digest and diff id. a layer already having a blob keeps it
+func storeLayerBlob(diffID, blobFile string, descriptor ociDescriptor) error {
+	layerDir := getLayerDir(diffID, nil)
+	if exists, err := checkFileOrDirExist(filepath.Join(layerDir, LayerBlobName)); err != nil || exists {
+		return err
+	}
+	content, err := json.Marshal(ociDescriptor{MediaType: descriptor.MediaType, Digest: descriptor.Digest, Size: descriptor.Size})
+	if err != nil {
+		return err
+	}
+	// the descriptor is written first, a layer having the blob always has its descriptor
+	descriptorFile := filepath.Join(layerDir, LayerBlobDescriptorName)
+	if err := os.WriteFile(descriptorFile+".tmp", content, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(descriptorFile+".tmp", descriptorFile); err != nil {
+		return err
+	}
+	return os.Rename(blobFile, filepath.Join(layerDir, LayerBlobName))
+}
+
+// readLayerBlob returns the descriptor of the blob a layer is pulled as, it's nil if the layer has no blob,
+// like a layer committed or imported from a tar
+func readLayerBlob(diffID string) (*ociDescriptor, error) {
+	layerDir := getLayerDir(diffID, nil)
+	if exists, err := checkFileOrDirExist(filepath.Join(layerDir, LayerBlobName)); err != nil || !exists {
+		return nil, err
+	}
+	content, err := os.ReadFile(filepath.Join(layerDir, LayerBlobDescriptorName))
+	if err != nil {
+		return nil, err
+	}
+	descriptor := &ociDescriptor{}
+	if err := json.Unmarshal(content, descriptor); err != nil {
+		return nil, fmt.Errorf("read the blob descriptor of layer %s error: %v", diffID, err)
+	}
+	return descriptor, nil
+}
+
+// newLayerTmpDir creates a temp dir in the layer store with an empty refs dir, which is renamed to a layer once it's ready
+func newLayerTmpDir() (string, error) {
+	tmpDir, err := os.MkdirTemp(LayerRootPath, "tmp-")
+	if err != nil {
+		return "", fmt.Errorf("create layer dir error: %v", err)
+	}
+	// the layers are looked up by the user namespaces of the containers too
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, LayerRefsName), 0755); err != nil {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+	return tmpDir, nil
+}
+
+// tarDigest returns the digest of the tar, a gzipped or zstd compressed tar is uncompressed first
+func tarDigest(tarPath string) (string, error) {
+	file, err := os.Open(tarPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	content, err := decompress(file)
+	if err != nil {
+		return "", fmt.Errorf("read %s error: %v", tarPath, err)
+	}
+	defer content.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", fmt.Errorf("read %s error: %v", tarPath, err)
+	}
+	if err := content.Close(); err != nil {
+		return "", fmt.Errorf("read %s error: %v", tarPath, err)
+	}
+	return digestPrefix + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// decompress returns the uncompressed content of a gzipped or zstd compressed reader, the other content is returned as is.
+// zstd is uncompressed by the zstd command like tar does
+func decompress(reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		cmd := exec.Command("zstd", "-d", "-c")
+		cmd.Stdin = buffered
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("start zstd error: %v", err)
+		}
+		return &commandReader{ReadCloser: stdout, cmd: cmd}, nil
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// commandReader reads the output of a command, closing it waits for the command
+type commandReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	closed bool
+}
+
+func (r *commandReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.ReadCloser.Close()
+	if err := r.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s error: %v", r.cmd.Path, err)
+	}
+	return nil
+}
+
+// getLayerDir returns the dir of a layer in the store. the files of a layer used in a user namespace are owned by
+// the mapped ids, so each id mapping has its own copy of the layer, like "<digest>-<hash of the mappings>"
+func getLayerDir(digest string, userNs *UserNamespace) string {
+	name := strings.TrimPrefix(digest, digestPrefix)
+	if userNs != nil {
+		mappings, _ := json.Marshal(userNs)
+		hash := sha256.Sum256(mappings)
+		name += "-" + hex.EncodeToString(hash[:6])
+	}
+	return LayerRootPath + name
+}
+
+// acquireLayers takes a reference of the layers for the container, and records them in its storage dir.
+// it returns the diff dirs of the layers, the lowest one first
+func acquireLayers(containerDir, containerId string, digests []string, userNs *UserNamespace) ([]string, error) {
+	var layerDirs, diffDirs []string
+	for _, digest := range digests {
+		layerDir := getLayerDir(digest, userNs)
+		if userNs != nil {
+			if err := shiftLayer(digest, layerDir, userNs); err != nil {
+				return nil, err
+			}
+		}
+		if err := os.WriteFile(filepath.Join(layerDir, LayerRefsName, containerId), nil, 0644); err != nil {
+			return nil, fmt.Errorf("reference layer %s error: %v", digest, err)
+		}
+		layerDirs = append(layerDirs, layerDir)
+		diffDirs = append(diffDirs, filepath.Join(layerDir, LayerDiffName))
+	}
+
+	content, err := json.Marshal(layerDirs)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(containerDir+LayersFileName, content, 0644); err != nil {
+		return nil, fmt.Errorf("write layers of container %s error: %v", containerId, err)
+	}
+	return diffDirs, nil
+}
+
+// shiftLayer copies a layer for an id mapping, and shifts the owners of the copy to the mapped ids
+func shiftLayer(digest, layerDir string, userNs *UserNamespace) error {
+	if exists, err := checkFileOrDirExist(layerDir); err != nil || exists {
+		return err
+	}
+	tmpDir, err := newLayerTmpDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	source := filepath.Join(getLayerDir(digest, nil), LayerDiffName)
+	if out, err := exec.Command("cp", "-a", source, filepath.Join(tmpDir, LayerDiffName)).CombinedOutput(); err != nil {
+		return fmt.Errorf("copy layer %s error: %v, %s", digest, err, out)
+	}
+	if err := userNs.shiftOwnership(filepath.Join(tmpDir, LayerDiffName)); err != nil {
+		return fmt.Errorf("shift the ownership of layer %s error: %v", digest, err)
+	}
+	if err := os.Rename(tmpDir, layerDir); err != nil && !os.IsExist(err) {
+		if exists, _ := checkFileOrDirExist(layerDir); !exists {
+			return fmt.Errorf("store layer %s error: %v", digest, err)
+		}
+	}
+	return nil
+}
+
+// getContainerLayerDigests returns the digests of the layers used by the container, the lowest one first
+func getContainerLayerDigests(containerDir string) ([]string, error) {
+	diffDirs, err := getContainerLayers(containerDir)
+	if err != nil {
+		return nil, err
+	}
+	digests := make([]string, len(diffDirs))
+	for i, diffDir := range diffDirs {
+		digests[i] = layerDirDigest(filepath.Dir(diffDir))
+	}
+	return digests, nil
+}
+
+// layerDirDigest returns the digest of the layer in the dir, the dir of a layer copied for an id mapping has a suffix
+func layerDirDigest(layerDir string) string {
+	return digestPrefix + strings.SplitN(filepath.Base(layerDir), "-", 2)[0]
+}
+
+// getContainerLayers returns the diff dirs of the layers used by the container, the lowest one first
+func getContainerLayers(containerDir string) ([]string, error) {
+	content, err := os.ReadFile(containerDir + LayersFileName)
+	if err != nil {
+		return nil, fmt.Errorf("read layers of container error: %v", err)
+	}
+	var layerDirs []string
+	if err := json.Unmarshal(content, &layerDirs); err != nil {
+		return nil, fmt.Errorf("read layers of container error: %v", err)
+	}
+	diffDirs := make([]string, len(layerDirs))
+	for i, layerDir := range layerDirs {
+		diffDirs[i] = filepath.Join(layerDir, LayerDiffName)
+	}
+	return diffDirs, nil
+}
+
+// overlayLowerDirs joins the layers as the lowerdir option of overlay, where the uppermost layer comes first
+func overlayLowerDirs(diffDirs []string) string {
+	dirs := make([]string, len(diffDirs))
+	for i, dir := range diffDirs {
+		if absDir, err := filepath.Abs(dir); err == nil {
+			dir = absDir
+		}
+		dirs[len(diffDirs)-1-i] = dir
+	}
+	return strings.Join(dirs, ":")
+}
+
+// releaseLayers drops the references of the container to its layers, a layer is removed
+// once it's neither used by a container nor by an image
+func releaseLayers(containerDir, containerId string) {
+	content, err := os.ReadFile(containerDir + LayersFileName)
+	if os.IsNotExist(err) {
+		return
+	}
+	var layerDirs []string
+	if err == nil {
+		err = json.Unmarshal(content, &layerDirs)
+	}
+	if err != nil {
+		log.Errorf("read layers of container %s error %v", containerId, err)
+		return
+	}
+
+	used := imageLayerDirs()
+	for _, layerDir := range layerDirs {
+		if err := os.Remove(filepath.Join(layerDir, LayerRefsName, containerId)); err != nil && !os.IsNotExist(err) {
+			log.Errorf("release layer %s error %v", layerDir, err)
+			continue
+		}
+		removeUnusedLayer(layerDir, used)
+	}
+}
+
+// pruneLayers removes the layers of the digests and their copies for the id mappings,
+// if they're neither used by a container nor by an image
+func pruneLayers(digests []string) {
+	entries, err := os.ReadDir(LayerRootPath)
+	if err != nil {
+		log.Errorf("read dir %s error %v", LayerRootPath, err)
+		return
+	}
+	pruned := map[string]bool{}
+	for _, digest := range digests {
+		pruned[digest] = true
+	}
+	used := imageLayerDirs()
+	for _, entry := range entries {
+		layerDir := LayerRootPath + entry.Name()
+		if entry.IsDir() && pruned[layerDirDigest(layerDir)] {
+			removeUnusedLayer(layerDir, used)
+		}
+	}
+}
+
+// removeUnusedLayer removes the layer if no container has a reference of it and no image uses it
+func removeUnusedLayer(layerDir string, used map[string]bool) {
+	if refs, err := os.ReadDir(filepath.Join(layerDir, LayerRefsName)); err != nil || len(refs) != 0 {
+		return
+	}
+	if used[layerDirDigest(layerDir)] {
+		return
+	}
+	if err := os.RemoveAll(layerDir); err != nil {
+		log.Errorf("remove layer %s error %v", layerDir, err)
+	}
+}
+
+// imageLayerDirs returns the digests of the layers used by the images
+func imageLayerDirs() map[string]bool {
+	used := map[string]bool{}
+	images, err := listImageRecords()
+	if err != nil {
+		return used
+	}
+	for _, image := range images {
+		record, err := readImageRecord(image)
+		if err != nil {
+			continue
+		}
+		for _, digest := range record.Layers {
+			used[digest] = true
+		}
+	}
+	return used
+}
+
+// listImageRecords returns the names of the images having a record, the records of the images
+// in a repository like library/busybox are in the dir of the repository
+func listImageRecords() ([]string, error) {
+	return listImageFiles(".json")
+}
+
+// listImageFiles returns the names of the images having a file of the suffix in ImageRootPath
+func listImageFiles(suffix string) ([]string, error) {
+	var images []string
+	layerRoot := filepath.Clean(LayerRootPath)
+	err := filepath.WalkDir(ImageRootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && filepath.Clean(path) == layerRoot {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, suffix) {
+			return nil
+		}
+		name, err := filepath.Rel(ImageRootPath, strings.TrimSuffix(path, suffix))
+		if err != nil {
+			return err
+		}
+		images = append(images, name)
+		return nil
+	})
+	return images, err
+}
@@ -0,0 +1,298 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go_docker_learning/ganker/cgroup/subsystem"
+
+	syscall "golang.org/x/sys/unix"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StorageDriverOverlay mounts the overlay in the user namespace of the container, it needs linux 5.11 or later
+	StorageDriverOverlay = "overlay"
+	// StorageDriverFuseOverlay mounts the overlay with fuse-overlayfs on the host
+	StorageDriverFuseOverlay = "fuse-overlayfs"
+	// StorageDriverVfs copies the image into the rootfs of the container, it works everywhere but is slow
+	StorageDriverVfs = "vfs"
+
+	// NetworkSlirp4netns connects the container to the host by the user-mode network stack of slirp4netns
+	NetworkSlirp4netns = "slirp4netns"
+	// NetworkNone leaves the container with only a loopback device
+	NetworkNone = "none"
+
+	// slirpTapName is the tap device created by slirp4netns in the network namespace of the container
+	slirpTapName = "tap0"
+)
+
+// Rootless is true if ganker is run by an unprivileged user, the containers then always run in a user namespace
+// where root is mapped to the user, and the files of ganker are kept in $XDG_DATA_HOME/ganker
+var Rootless = os.Geteuid() != 0
+
+var (
+	storageDriverOnce sync.Once
+	storageDriver     string
+)
+
+// dataRoot returns the dir all paths of ganker are relative to, it is the working dir when run by root
+func dataRoot() string {
+	if !Rootless {
+		return "./"
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		dataHome = filepath.Join(os.Getenv("HOME"), ".local", "share")
+	}
+	return filepath.Join(dataHome, "ganker") + "/"
+}
+
+// prepareRootless checks the options of a rootless container, and fills in its user namespace and network
+func prepareRootless(opts *RunOptions) error {
+	if opts.UserNamespace != nil {
+		return fmt.Errorf("--userns-remap, --uidmap and --gidmap need root, a rootless container always maps root to the user")
+	}
+	opts.UserNamespace = rootlessUserNamespace()
+
+	network, err := checkRootlessNetwork(opts.Network)
+	if err != nil {
+		return err
+	}
+	opts.Network = network
+	return nil
+}
+
+// rootlessUserNamespace maps root of the container to the user running ganker,
+// an unprivileged user is only allowed to map its own ids without the setuid newuidmap helper
+func rootlessUserNamespace() *UserNamespace {
+	return &UserNamespace{
+		UidMappings: []IDMapping{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}},
+		GidMappings: []IDMapping{{ContainerID: 0, HostID: os.Getegid(), Size: 1}},
+	}
+}
+
+// getStorageDriver returns how the rootfs of a rootless container is built, fuse-overlayfs is preferred if installed,
+// then the overlay in the user namespace, copying the image is the last resort
+func getStorageDriver() string {
+	storageDriverOnce.Do(func() {
+		switch {
+		case !Rootless:
+			storageDriver = StorageDriverOverlay
+		case commandExists("fuse-overlayfs"):
+			storageDriver = StorageDriverFuseOverlay
+		case kernelAtLeast(5, 11):
+			storageDriver = StorageDriverOverlay
+		default:
+			storageDriver = StorageDriverVfs
+		}
+	})
+	return storageDriver
+}
+
+// rootfsMount returns the overlay mounted by init on the merged dir of a rootless container,
+// it is nil if the rootfs is ready before the container is started
+func rootfsMount(containerDir string) (*Mount, error) {
+	if !Rootless || getStorageDriver() != StorageDriverOverlay {
+		return nil, nil
+	}
+	lowerDirs, err := getContainerLayers(containerDir)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(containerDir)
+	if err != nil {
+		absDir = containerDir
+	}
+	return &Mount{
+		Source:      "overlay",
+		Destination: "/",
+		Type:        "overlay",
+		// the xattrs of overlay are kept in the user namespace instead of the trusted namespace
+		Data: fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,userxattr",
+			overlayLowerDirs(lowerDirs), filepath.Join(absDir, UpperName), filepath.Join(absDir, WorkSpaceName)),
+	}, nil
+}
+
+// mountRootlessFS prepares the merged dir of a rootless container with the storage driver
+func mountRootlessFS(containerDir string, lowerDirs []string) error {
+	switch getStorageDriver() {
+	case StorageDriverFuseOverlay:
+		data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", overlayLowerDirs(lowerDirs), containerDir+UpperName, containerDir+WorkSpaceName)
+		if out, err := exec.Command("fuse-overlayfs", "-o", data, containerDir+MergeLayerName).CombinedOutput(); err != nil {
+			return fmt.Errorf("fuse-overlayfs error: %v, %s", err, out)
+		}
+	case StorageDriverVfs:
+		// the layers are copied from the lowest one, the upper ones replace or remove its files
+		copyDir := func(source, target string) error {
+			if out, err := exec.Command("cp", "-a", source+"/.", target).CombinedOutput(); err != nil {
+				return fmt.Errorf("copy image error: %v, %s", err, out)
+			}
+			return nil
+		}
+		for _, lowerDir := range lowerDirs {
+			if err := applyLayer(lowerDir, containerDir+MergeLayerName, copyDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unmountRootlessFS unmounts the fuse-overlayfs of a rootless container,
+// the overlay mounted in the user namespace goes away with the mount namespace of the container
+func unmountRootlessFS(mountPoint string) error {
+	if !isMountPoint(mountPoint) {
+		return nil
+	}
+	for _, fusermount := range []string{"fusermount3", "fusermount"} {
+		if commandExists(fusermount) {
+			if out, err := exec.Command(fusermount, "-u", mountPoint).CombinedOutput(); err != nil {
+				return fmt.Errorf("%s error: %v, %s", fusermount, err, out)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("fusermount is not found")
+}
+
+// getCgroupPath returns the cgroup of the container relative to the cgroup root,
+// a rootless container gets one only if systemd has delegated the cgroup of the user manager to the user
+func getCgroupPath(containerId string) string {
+	if !Rootless {
+		return "GankerCgroup" + "/" + containerId
+	}
+	if !subsystem.IsCgroup2() {
+		return ""
+	}
+	uid := strconv.Itoa(os.Geteuid())
+	userService := filepath.Join("/user.slice", "user-"+uid+".slice", "user@"+uid+".service")
+	mountPoint := subsystem.FindCgroupMountPoint("")
+	if syscall.Access(filepath.Join(mountPoint, userService, "cgroup.subtree_control"), syscall.W_OK) != nil {
+		return ""
+	}
+	// a process can only be moved between cgroups the user owns, so ganker must run inside the delegated cgroup too
+	if !strings.HasPrefix(getSelfCgroup(), userService+"/") {
+		return ""
+	}
+	return filepath.Join(userService, "ganker", containerId)
+}
+
+// getSelfCgroup returns the cgroup v2 path of the current process, like "/user.slice/user-1000.slice/session-1.scope"
+func getSelfCgroup() string {
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if cgroupPath, ok := strings.CutPrefix(line, "0::"); ok {
+			return cgroupPath
+		}
+	}
+	return ""
+}
+
+// checkRootlessNetwork returns the network of a rootless container, slirp4netns is used by default if installed
+func checkRootlessNetwork(network string) (string, error) {
+	switch network {
+	case "":
+		if commandExists("slirp4netns") {
+			return NetworkSlirp4netns, nil
+		}
+		return NetworkNone, nil
+	case NetworkSlirp4netns:
+		if !commandExists("slirp4netns") {
+			return "", fmt.Errorf("slirp4netns is not found")
+		}
+		return network, nil
+	case NetworkNone:
+		return network, nil
+	}
+	return "", fmt.Errorf("network %s is not supported in rootless mode, it should be %s or %s", network, NetworkSlirp4netns, NetworkNone)
+}
+
+// startSlirp4netns connects the network namespace of the process to the host by slirp4netns,
+// it returns the pid of slirp4netns which must be killed when the container exits
+func startSlirp4netns(pid int) (int, error) {
+	readyRead, readyWrite, err := newPipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyRead.Close()
+
+	// slirp4netns writes to the ready fd (the first extra file is fd 3) once the tap device is configured
+	cmd := exec.Command("slirp4netns", "--configure", "--mtu=65520", "--disable-host-loopback", "--ready-fd=3",
+		strconv.Itoa(pid), slirpTapName)
+	cmd.ExtraFiles = []*os.File{readyWrite}
+	// slirp4netns keeps running after a detached container's ganker exits
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	readyWrite.Close()
+	if err != nil {
+		return 0, fmt.Errorf("start slirp4netns error: %v", err)
+	}
+	go cmd.Wait()
+
+	if _, err := readyRead.Read(make([]byte, 1)); err != nil {
+		return 0, fmt.Errorf("slirp4netns exited before the network is ready")
+	}
+	return cmd.Process.Pid, nil
+}
+
+// stopSlirp4netns kills the slirp4netns of a container
+func stopSlirp4netns(pid int) {
+	if pid == 0 {
+		return
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		log.Warnf("stop slirp4netns %d error %v", pid, err)
+	}
+}
+
+// isMountPoint checks if path is the mount point of a mount in /proc/self/mountinfo
+func isMountPoint(path string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		if len(fields) > 4 && fields[4] == absPath {
+			return true
+		}
+	}
+	return false
+}
+
+// kernelAtLeast checks the release of the running kernel, like "5.15.0-91-generic"
+func kernelAtLeast(major, minor int) bool {
+	var uname syscall.Utsname
+	if err := syscall.Uname(&uname); err != nil {
+		return false
+	}
+	release := syscall.ByteSliceToString(uname.Release[:])
+	var kernelMajor, kernelMinor int
+	if _, err := fmt.Sscanf(release, "%d.%d", &kernelMajor, &kernelMinor); err != nil {
+		return false
+	}
+	return kernelMajor > major || (kernelMajor == major && kernelMinor >= minor)
+}
+
+func commandExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
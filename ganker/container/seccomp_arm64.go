@@ -0,0 +1,326 @@
+package container
+
+import (
+	syscall "golang.org/x/sys/unix"
+)
+
+const (
+	// seccompNativeArch is the audit arch of the syscalls filtered by seccomp
+	seccompNativeArch = syscall.AUDIT_ARCH_AARCH64
+	// seccompNativeArchName is the name of the native arch in a seccomp profile
+	seccompNativeArchName = "SCMP_ARCH_AARCH64"
+	// seccompX32SyscallBit is only used on x86_64
+	seccompX32SyscallBit = 0
+)
+
+// seccompSyscalls maps the names of the syscalls in a seccomp profile to their numbers
+var seccompSyscalls = map[string]int{
+	"io_setup":                syscall.SYS_IO_SETUP,
+	"io_destroy":              syscall.SYS_IO_DESTROY,
+	"io_submit":               syscall.SYS_IO_SUBMIT,
+	"io_cancel":               syscall.SYS_IO_CANCEL,
+	"io_getevents":            syscall.SYS_IO_GETEVENTS,
+	"setxattr":                syscall.SYS_SETXATTR,
+	"lsetxattr":               syscall.SYS_LSETXATTR,
+	"fsetxattr":               syscall.SYS_FSETXATTR,
+	"getxattr":                syscall.SYS_GETXATTR,
+	"lgetxattr":               syscall.SYS_LGETXATTR,
+	"fgetxattr":               syscall.SYS_FGETXATTR,
+	"listxattr":               syscall.SYS_LISTXATTR,
+	"llistxattr":              syscall.SYS_LLISTXATTR,
+	"flistxattr":              syscall.SYS_FLISTXATTR,
+	"removexattr":             syscall.SYS_REMOVEXATTR,
+	"lremovexattr":            syscall.SYS_LREMOVEXATTR,
+	"fremovexattr":            syscall.SYS_FREMOVEXATTR,
+	"getcwd":                  syscall.SYS_GETCWD,
+	"lookup_dcookie":          syscall.SYS_LOOKUP_DCOOKIE,
+	"eventfd2":                syscall.SYS_EVENTFD2,
+	"epoll_create1":           syscall.SYS_EPOLL_CREATE1,
+	"epoll_ctl":               syscall.SYS_EPOLL_CTL,
+	"epoll_pwait":             syscall.SYS_EPOLL_PWAIT,
+	"dup":                     syscall.SYS_DUP,
+	"dup3":                    syscall.SYS_DUP3,
+	"fcntl":                   syscall.SYS_FCNTL,
+	"inotify_init1":           syscall.SYS_INOTIFY_INIT1,
+	"inotify_add_watch":       syscall.SYS_INOTIFY_ADD_WATCH,
+	"inotify_rm_watch":        syscall.SYS_INOTIFY_RM_WATCH,
+	"ioctl":                   syscall.SYS_IOCTL,
+	"ioprio_set":              syscall.SYS_IOPRIO_SET,
+	"ioprio_get":              syscall.SYS_IOPRIO_GET,
+	"flock":                   syscall.SYS_FLOCK,
+	"mknodat":                 syscall.SYS_MKNODAT,
+	"mkdirat":                 syscall.SYS_MKDIRAT,
+	"unlinkat":                syscall.SYS_UNLINKAT,
+	"symlinkat":               syscall.SYS_SYMLINKAT,
+	"linkat":                  syscall.SYS_LINKAT,
+	"renameat":                syscall.SYS_RENAMEAT,
+	"umount2":                 syscall.SYS_UMOUNT2,
+	"mount":                   syscall.SYS_MOUNT,
+	"pivot_root":              syscall.SYS_PIVOT_ROOT,
+	"nfsservctl":              syscall.SYS_NFSSERVCTL,
+	"statfs":                  syscall.SYS_STATFS,
+	"fstatfs":                 syscall.SYS_FSTATFS,
+	"truncate":                syscall.SYS_TRUNCATE,
+	"ftruncate":               syscall.SYS_FTRUNCATE,
+	"fallocate":               syscall.SYS_FALLOCATE,
+	"faccessat":               syscall.SYS_FACCESSAT,
+	"chdir":                   syscall.SYS_CHDIR,
+	"fchdir":                  syscall.SYS_FCHDIR,
+	"chroot":                  syscall.SYS_CHROOT,
+	"fchmod":                  syscall.SYS_FCHMOD,
+	"fchmodat":                syscall.SYS_FCHMODAT,
+	"fchownat":                syscall.SYS_FCHOWNAT,
+	"fchown":                  syscall.SYS_FCHOWN,
+	"openat":                  syscall.SYS_OPENAT,
+	"close":                   syscall.SYS_CLOSE,
+	"vhangup":                 syscall.SYS_VHANGUP,
+	"pipe2":                   syscall.SYS_PIPE2,
+	"quotactl":                syscall.SYS_QUOTACTL,
+	"getdents64":              syscall.SYS_GETDENTS64,
+	"lseek":                   syscall.SYS_LSEEK,
+	"read":                    syscall.SYS_READ,
+	"write":                   syscall.SYS_WRITE,
+	"readv":                   syscall.SYS_READV,
+	"writev":                  syscall.SYS_WRITEV,
+	"pread64":                 syscall.SYS_PREAD64,
+	"pwrite64":                syscall.SYS_PWRITE64,
+	"preadv":                  syscall.SYS_PREADV,
+	"pwritev":                 syscall.SYS_PWRITEV,
+	"sendfile":                syscall.SYS_SENDFILE,
+	"pselect6":                syscall.SYS_PSELECT6,
+	"ppoll":                   syscall.SYS_PPOLL,
+	"signalfd4":               syscall.SYS_SIGNALFD4,
+	"vmsplice":                syscall.SYS_VMSPLICE,
+	"splice":                  syscall.SYS_SPLICE,
+	"tee":                     syscall.SYS_TEE,
+	"readlinkat":              syscall.SYS_READLINKAT,
+	"fstatat":                 syscall.SYS_FSTATAT,
+	"fstat":                   syscall.SYS_FSTAT,
+	"sync":                    syscall.SYS_SYNC,
+	"fsync":                   syscall.SYS_FSYNC,
+	"fdatasync":               syscall.SYS_FDATASYNC,
+	"sync_file_range":         syscall.SYS_SYNC_FILE_RANGE,
+	"timerfd_create":          syscall.SYS_TIMERFD_CREATE,
+	"timerfd_settime":         syscall.SYS_TIMERFD_SETTIME,
+	"timerfd_gettime":         syscall.SYS_TIMERFD_GETTIME,
+	"utimensat":               syscall.SYS_UTIMENSAT,
+	"acct":                    syscall.SYS_ACCT,
+	"capget":                  syscall.SYS_CAPGET,
+	"capset":                  syscall.SYS_CAPSET,
+	"personality":             syscall.SYS_PERSONALITY,
+	"exit":                    syscall.SYS_EXIT,
+	"exit_group":              syscall.SYS_EXIT_GROUP,
+	"waitid":                  syscall.SYS_WAITID,
+	"set_tid_address":         syscall.SYS_SET_TID_ADDRESS,
+	"unshare":                 syscall.SYS_UNSHARE,
+	"futex":                   syscall.SYS_FUTEX,
+	"set_robust_list":         syscall.SYS_SET_ROBUST_LIST,
+	"get_robust_list":         syscall.SYS_GET_ROBUST_LIST,
+	"nanosleep":               syscall.SYS_NANOSLEEP,
+	"getitimer":               syscall.SYS_GETITIMER,
+	"setitimer":               syscall.SYS_SETITIMER,
+	"kexec_load":              syscall.SYS_KEXEC_LOAD,
+	"init_module":             syscall.SYS_INIT_MODULE,
+	"delete_module":           syscall.SYS_DELETE_MODULE,
+	"timer_create":            syscall.SYS_TIMER_CREATE,
+	"timer_gettime":           syscall.SYS_TIMER_GETTIME,
+	"timer_getoverrun":        syscall.SYS_TIMER_GETOVERRUN,
+	"timer_settime":           syscall.SYS_TIMER_SETTIME,
+	"timer_delete":            syscall.SYS_TIMER_DELETE,
+	"clock_settime":           syscall.SYS_CLOCK_SETTIME,
+	"clock_gettime":           syscall.SYS_CLOCK_GETTIME,
+	"clock_getres":            syscall.SYS_CLOCK_GETRES,
+	"clock_nanosleep":         syscall.SYS_CLOCK_NANOSLEEP,
+	"syslog":                  syscall.SYS_SYSLOG,
+	"ptrace":                  syscall.SYS_PTRACE,
+	"sched_setparam":          syscall.SYS_SCHED_SETPARAM,
+	"sched_setscheduler":      syscall.SYS_SCHED_SETSCHEDULER,
+	"sched_getscheduler":      syscall.SYS_SCHED_GETSCHEDULER,
+	"sched_getparam":          syscall.SYS_SCHED_GETPARAM,
+	"sched_setaffinity":       syscall.SYS_SCHED_SETAFFINITY,
+	"sched_getaffinity":       syscall.SYS_SCHED_GETAFFINITY,
+	"sched_yield":             syscall.SYS_SCHED_YIELD,
+	"sched_get_priority_max":  syscall.SYS_SCHED_GET_PRIORITY_MAX,
+	"sched_get_priority_min":  syscall.SYS_SCHED_GET_PRIORITY_MIN,
+	"sched_rr_get_interval":   syscall.SYS_SCHED_RR_GET_INTERVAL,
+	"restart_syscall":         syscall.SYS_RESTART_SYSCALL,
+	"kill":                    syscall.SYS_KILL,
+	"tkill":                   syscall.SYS_TKILL,
+	"tgkill":                  syscall.SYS_TGKILL,
+	"sigaltstack":             syscall.SYS_SIGALTSTACK,
+	"rt_sigsuspend":           syscall.SYS_RT_SIGSUSPEND,
+	"rt_sigaction":            syscall.SYS_RT_SIGACTION,
+	"rt_sigprocmask":          syscall.SYS_RT_SIGPROCMASK,
+	"rt_sigpending":           syscall.SYS_RT_SIGPENDING,
+	"rt_sigtimedwait":         syscall.SYS_RT_SIGTIMEDWAIT,
+	"rt_sigqueueinfo":         syscall.SYS_RT_SIGQUEUEINFO,
+	"rt_sigreturn":            syscall.SYS_RT_SIGRETURN,
+	"setpriority":             syscall.SYS_SETPRIORITY,
+	"getpriority":             syscall.SYS_GETPRIORITY,
+	"reboot":                  syscall.SYS_REBOOT,
+	"setregid":                syscall.SYS_SETREGID,
+	"setgid":                  syscall.SYS_SETGID,
+	"setreuid":                syscall.SYS_SETREUID,
+	"setuid":                  syscall.SYS_SETUID,
+	"setresuid":               syscall.SYS_SETRESUID,
+	"getresuid":               syscall.SYS_GETRESUID,
+	"setresgid":               syscall.SYS_SETRESGID,
+	"getresgid":               syscall.SYS_GETRESGID,
+	"setfsuid":                syscall.SYS_SETFSUID,
+	"setfsgid":                syscall.SYS_SETFSGID,
+	"times":                   syscall.SYS_TIMES,
+	"setpgid":                 syscall.SYS_SETPGID,
+	"getpgid":                 syscall.SYS_GETPGID,
+	"getsid":                  syscall.SYS_GETSID,
+	"setsid":                  syscall.SYS_SETSID,
+	"getgroups":               syscall.SYS_GETGROUPS,
+	"setgroups":               syscall.SYS_SETGROUPS,
+	"uname":                   syscall.SYS_UNAME,
+	"sethostname":             syscall.SYS_SETHOSTNAME,
+	"setdomainname":           syscall.SYS_SETDOMAINNAME,
+	"getrlimit":               syscall.SYS_GETRLIMIT,
+	"setrlimit":               syscall.SYS_SETRLIMIT,
+	"getrusage":               syscall.SYS_GETRUSAGE,
+	"umask":                   syscall.SYS_UMASK,
+	"prctl":                   syscall.SYS_PRCTL,
+	"getcpu":                  syscall.SYS_GETCPU,
+	"gettimeofday":            syscall.SYS_GETTIMEOFDAY,
+	"settimeofday":            syscall.SYS_SETTIMEOFDAY,
+	"adjtimex":                syscall.SYS_ADJTIMEX,
+	"getpid":                  syscall.SYS_GETPID,
+	"getppid":                 syscall.SYS_GETPPID,
+	"getuid":                  syscall.SYS_GETUID,
+	"geteuid":                 syscall.SYS_GETEUID,
+	"getgid":                  syscall.SYS_GETGID,
+	"getegid":                 syscall.SYS_GETEGID,
+	"gettid":                  syscall.SYS_GETTID,
+	"sysinfo":                 syscall.SYS_SYSINFO,
+	"mq_open":                 syscall.SYS_MQ_OPEN,
+	"mq_unlink":               syscall.SYS_MQ_UNLINK,
+	"mq_timedsend":            syscall.SYS_MQ_TIMEDSEND,
+	"mq_timedreceive":         syscall.SYS_MQ_TIMEDRECEIVE,
+	"mq_notify":               syscall.SYS_MQ_NOTIFY,
+	"mq_getsetattr":           syscall.SYS_MQ_GETSETATTR,
+	"msgget":                  syscall.SYS_MSGGET,
+	"msgctl":                  syscall.SYS_MSGCTL,
+	"msgrcv":                  syscall.SYS_MSGRCV,
+	"msgsnd":                  syscall.SYS_MSGSND,
+	"semget":                  syscall.SYS_SEMGET,
+	"semctl":                  syscall.SYS_SEMCTL,
+	"semtimedop":              syscall.SYS_SEMTIMEDOP,
+	"semop":                   syscall.SYS_SEMOP,
+	"shmget":                  syscall.SYS_SHMGET,
+	"shmctl":                  syscall.SYS_SHMCTL,
+	"shmat":                   syscall.SYS_SHMAT,
+	"shmdt":                   syscall.SYS_SHMDT,
+	"socket":                  syscall.SYS_SOCKET,
+	"socketpair":              syscall.SYS_SOCKETPAIR,
+	"bind":                    syscall.SYS_BIND,
+	"listen":                  syscall.SYS_LISTEN,
+	"accept":                  syscall.SYS_ACCEPT,
+	"connect":                 syscall.SYS_CONNECT,
+	"getsockname":             syscall.SYS_GETSOCKNAME,
+	"getpeername":             syscall.SYS_GETPEERNAME,
+	"sendto":                  syscall.SYS_SENDTO,
+	"recvfrom":                syscall.SYS_RECVFROM,
+	"setsockopt":              syscall.SYS_SETSOCKOPT,
+	"getsockopt":              syscall.SYS_GETSOCKOPT,
+	"shutdown":                syscall.SYS_SHUTDOWN,
+	"sendmsg":                 syscall.SYS_SENDMSG,
+	"recvmsg":                 syscall.SYS_RECVMSG,
+	"readahead":               syscall.SYS_READAHEAD,
+	"brk":                     syscall.SYS_BRK,
+	"munmap":                  syscall.SYS_MUNMAP,
+	"mremap":                  syscall.SYS_MREMAP,
+	"add_key":                 syscall.SYS_ADD_KEY,
+	"request_key":             syscall.SYS_REQUEST_KEY,
+	"keyctl":                  syscall.SYS_KEYCTL,
+	"clone":                   syscall.SYS_CLONE,
+	"execve":                  syscall.SYS_EXECVE,
+	"mmap":                    syscall.SYS_MMAP,
+	"fadvise64":               syscall.SYS_FADVISE64,
+	"swapon":                  syscall.SYS_SWAPON,
+	"swapoff":                 syscall.SYS_SWAPOFF,
+	"mprotect":                syscall.SYS_MPROTECT,
+	"msync":                   syscall.SYS_MSYNC,
+	"mlock":                   syscall.SYS_MLOCK,
+	"munlock":                 syscall.SYS_MUNLOCK,
+	"mlockall":                syscall.SYS_MLOCKALL,
+	"munlockall":              syscall.SYS_MUNLOCKALL,
+	"mincore":                 syscall.SYS_MINCORE,
+	"madvise":                 syscall.SYS_MADVISE,
+	"remap_file_pages":        syscall.SYS_REMAP_FILE_PAGES,
+	"mbind":                   syscall.SYS_MBIND,
+	"get_mempolicy":           syscall.SYS_GET_MEMPOLICY,
+	"set_mempolicy":           syscall.SYS_SET_MEMPOLICY,
+	"migrate_pages":           syscall.SYS_MIGRATE_PAGES,
+	"move_pages":              syscall.SYS_MOVE_PAGES,
+	"rt_tgsigqueueinfo":       syscall.SYS_RT_TGSIGQUEUEINFO,
+	"perf_event_open":         syscall.SYS_PERF_EVENT_OPEN,
+	"accept4":                 syscall.SYS_ACCEPT4,
+	"recvmmsg":                syscall.SYS_RECVMMSG,
+	"arch_specific_syscall":   syscall.SYS_ARCH_SPECIFIC_SYSCALL,
+	"wait4":                   syscall.SYS_WAIT4,
+	"prlimit64":               syscall.SYS_PRLIMIT64,
+	"fanotify_init":           syscall.SYS_FANOTIFY_INIT,
+	"fanotify_mark":           syscall.SYS_FANOTIFY_MARK,
+	"name_to_handle_at":       syscall.SYS_NAME_TO_HANDLE_AT,
+	"open_by_handle_at":       syscall.SYS_OPEN_BY_HANDLE_AT,
+	"clock_adjtime":           syscall.SYS_CLOCK_ADJTIME,
+	"syncfs":                  syscall.SYS_SYNCFS,
+	"setns":                   syscall.SYS_SETNS,
+	"sendmmsg":                syscall.SYS_SENDMMSG,
+	"process_vm_readv":        syscall.SYS_PROCESS_VM_READV,
+	"process_vm_writev":       syscall.SYS_PROCESS_VM_WRITEV,
+	"kcmp":                    syscall.SYS_KCMP,
+	"finit_module":            syscall.SYS_FINIT_MODULE,
+	"sched_setattr":           syscall.SYS_SCHED_SETATTR,
+	"sched_getattr":           syscall.SYS_SCHED_GETATTR,
+	"renameat2":               syscall.SYS_RENAMEAT2,
+	"seccomp":                 syscall.SYS_SECCOMP,
+	"getrandom":               syscall.SYS_GETRANDOM,
+	"memfd_create":            syscall.SYS_MEMFD_CREATE,
+	"bpf":                     syscall.SYS_BPF,
+	"execveat":                syscall.SYS_EXECVEAT,
+	"userfaultfd":             syscall.SYS_USERFAULTFD,
+	"membarrier":              syscall.SYS_MEMBARRIER,
+	"mlock2":                  syscall.SYS_MLOCK2,
+	"copy_file_range":         syscall.SYS_COPY_FILE_RANGE,
+	"preadv2":                 syscall.SYS_PREADV2,
+	"pwritev2":                syscall.SYS_PWRITEV2,
+	"pkey_mprotect":           syscall.SYS_PKEY_MPROTECT,
+	"pkey_alloc":              syscall.SYS_PKEY_ALLOC,
+	"pkey_free":               syscall.SYS_PKEY_FREE,
+	"statx":                   syscall.SYS_STATX,
+	"io_pgetevents":           syscall.SYS_IO_PGETEVENTS,
+	"rseq":                    syscall.SYS_RSEQ,
+	"kexec_file_load":         syscall.SYS_KEXEC_FILE_LOAD,
+	"pidfd_send_signal":       syscall.SYS_PIDFD_SEND_SIGNAL,
+	"io_uring_setup":          syscall.SYS_IO_URING_SETUP,
+	"io_uring_enter":          syscall.SYS_IO_URING_ENTER,
+	"io_uring_register":       syscall.SYS_IO_URING_REGISTER,
+	"open_tree":               syscall.SYS_OPEN_TREE,
+	"move_mount":              syscall.SYS_MOVE_MOUNT,
+	"fsopen":                  syscall.SYS_FSOPEN,
+	"fsconfig":                syscall.SYS_FSCONFIG,
+	"fsmount":                 syscall.SYS_FSMOUNT,
+	"fspick":                  syscall.SYS_FSPICK,
+	"pidfd_open":              syscall.SYS_PIDFD_OPEN,
+	"clone3":                  syscall.SYS_CLONE3,
+	"close_range":             syscall.SYS_CLOSE_RANGE,
+	"openat2":                 syscall.SYS_OPENAT2,
+	"pidfd_getfd":             syscall.SYS_PIDFD_GETFD,
+	"faccessat2":              syscall.SYS_FACCESSAT2,
+	"process_madvise":         syscall.SYS_PROCESS_MADVISE,
+	"epoll_pwait2":            syscall.SYS_EPOLL_PWAIT2,
+	"mount_setattr":           syscall.SYS_MOUNT_SETATTR,
+	"quotactl_fd":             syscall.SYS_QUOTACTL_FD,
+	"landlock_create_ruleset": syscall.SYS_LANDLOCK_CREATE_RULESET,
+	"landlock_add_rule":       syscall.SYS_LANDLOCK_ADD_RULE,
+	"landlock_restrict_self":  syscall.SYS_LANDLOCK_RESTRICT_SELF,
+	"memfd_secret":            syscall.SYS_MEMFD_SECRET,
+	"process_mrelease":        syscall.SYS_PROCESS_MRELEASE,
+	"futex_waitv":             syscall.SYS_FUTEX_WAITV,
+	"set_mempolicy_home_node": syscall.SYS_SET_MEMPOLICY_HOME_NODE,
+	"newfstatat":              syscall.SYS_FSTATAT,
+}
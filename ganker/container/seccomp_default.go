@@ -0,0 +1,141 @@
+package container
+
+// defaultSeccompProfile returns the built-in profile, which is modeled on the default profile of docker:
+// the syscalls are denied with EPERM unless they are allowed, some are only allowed with a capability,
+// like mount with CAP_SYS_ADMIN, and a clone creating namespaces needs CAP_SYS_ADMIN too
+func defaultSeccompProfile() *SeccompProfile {
+	enosys := uint(38)
+	syscalls := []SeccompSyscall{
+		{
+			Names:  defaultAllowedSyscalls,
+			Action: "SCMP_ACT_ALLOW",
+		},
+		{
+			Names:  []string{"ptrace", "kcmp", "pidfd_getfd", "process_madvise", "process_vm_readv", "process_vm_writev"},
+			Action: "SCMP_ACT_ALLOW",
+			// ptrace can't escape seccomp since linux 4.8
+			Includes: SeccompFilter{MinKernel: "4.8"},
+		},
+		{
+			// AF_VSOCK (40) reaches the hypervisor, the other address families are allowed
+			Names:  []string{"socket"},
+			Action: "SCMP_ACT_ALLOW",
+			Args:   []SeccompArg{{Index: 0, Value: 40, Op: "SCMP_CMP_NE"}},
+		},
+		{
+			Names:    []string{"arch_prctl", "modify_ldt"},
+			Action:   "SCMP_ACT_ALLOW",
+			Includes: SeccompFilter{Arches: []string{"amd64"}},
+		},
+		{
+			// the flags of clone are its first argument on x86_64 and arm64, the namespaces need CAP_SYS_ADMIN
+			Names:    []string{"clone"},
+			Action:   "SCMP_ACT_ALLOW",
+			Args:     []SeccompArg{{Index: 0, Value: cloneNamespaceFlags, ValueTwo: 0, Op: "SCMP_CMP_MASKED_EQ"}},
+			Excludes: SeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+		},
+		{
+			// the flags of clone3 are in a struct which can't be checked, the libc falls back to clone on ENOSYS
+			Names:    []string{"clone3"},
+			Action:   "SCMP_ACT_ERRNO",
+			ErrnoRet: &enosys,
+			Excludes: SeccompFilter{Caps: []string{"CAP_SYS_ADMIN"}},
+		},
+	}
+	for _, personality := range []uint64{0x0, 0x0008, 0x20000, 0x20008, 0xffffffff} {
+		syscalls = append(syscalls, SeccompSyscall{
+			Names:  []string{"personality"},
+			Action: "SCMP_ACT_ALLOW",
+			Args:   []SeccompArg{{Index: 0, Value: personality, Op: "SCMP_CMP_EQ"}},
+		})
+	}
+	for _, c := range capabilitySyscalls {
+		syscalls = append(syscalls, SeccompSyscall{
+			Names:    c.names,
+			Action:   "SCMP_ACT_ALLOW",
+			Includes: SeccompFilter{Caps: []string{c.capability}},
+		})
+	}
+
+	return &SeccompProfile{
+		DefaultAction: "SCMP_ACT_ERRNO",
+		Architectures: []string{seccompNativeArchName},
+		Syscalls:      syscalls,
+	}
+}
+
+// cloneNamespaceFlags are CLONE_NEWNS, CLONE_NEWCGROUP, CLONE_NEWUTS, CLONE_NEWIPC, CLONE_NEWUSER, CLONE_NEWPID and CLONE_NEWNET
+const cloneNamespaceFlags = 0x7E020000
+
+// capabilitySyscalls are allowed if the container has the capability
+var capabilitySyscalls = []struct {
+	capability string
+	names      []string
+}{
+	{"CAP_SYS_ADMIN", []string{"bpf", "clone", "clone3", "fanotify_init", "fsconfig", "fsmount", "fsopen", "fspick",
+		"lookup_dcookie", "mount", "mount_setattr", "move_mount", "open_tree", "perf_event_open",
+		"quotactl", "quotactl_fd", "setdomainname", "sethostname", "setns", "syslog", "umount", "umount2", "unshare"}},
+	{"CAP_SYS_BOOT", []string{"reboot"}},
+	{"CAP_SYS_CHROOT", []string{"chroot"}},
+	{"CAP_SYS_MODULE", []string{"delete_module", "init_module", "finit_module"}},
+	{"CAP_SYS_PACCT", []string{"acct"}},
+	{"CAP_SYS_PTRACE", []string{"kcmp", "pidfd_getfd", "process_madvise", "process_vm_readv", "process_vm_writev", "ptrace"}},
+	{"CAP_SYS_RAWIO", []string{"iopl", "ioperm"}},
+	{"CAP_SYS_TIME", []string{"settimeofday", "stime", "clock_settime", "clock_settime64"}},
+	{"CAP_SYS_TTY_CONFIG", []string{"vhangup"}},
+	{"CAP_SYS_NICE", []string{"get_mempolicy", "mbind", "set_mempolicy", "set_mempolicy_home_node"}},
+	{"CAP_SYSLOG", []string{"syslog"}},
+	{"CAP_BPF", []string{"bpf"}},
+	{"CAP_PERFMON", []string{"perf_event_open"}},
+	{"CAP_DAC_READ_SEARCH", []string{"open_by_handle_at"}},
+}
+
+// defaultAllowedSyscalls are allowed in every container, the ones unknown to the native arch are ignored
+var defaultAllowedSyscalls = []string{
+	"accept", "accept4", "access", "adjtimex", "alarm", "bind", "brk", "cachestat", "capget", "capset",
+	"chdir", "chmod", "chown", "chown32", "clock_adjtime", "clock_adjtime64", "clock_getres", "clock_getres_time64",
+	"clock_gettime", "clock_gettime64", "clock_nanosleep", "clock_nanosleep_time64", "close", "close_range",
+	"connect", "copy_file_range", "creat", "dup", "dup2", "dup3", "epoll_create", "epoll_create1", "epoll_ctl",
+	"epoll_ctl_old", "epoll_pwait", "epoll_pwait2", "epoll_wait", "epoll_wait_old", "eventfd", "eventfd2",
+	"execve", "execveat", "exit", "exit_group", "faccessat", "faccessat2", "fadvise64", "fadvise64_64",
+	"fallocate", "fanotify_mark", "fchdir", "fchmod", "fchmodat", "fchmodat2", "fchown", "fchown32", "fchownat",
+	"fcntl", "fcntl64", "fdatasync", "fgetxattr", "flistxattr", "flock", "fork", "fremovexattr", "fsetxattr",
+	"fstat", "fstat64", "fstatat64", "fstatfs", "fstatfs64", "fsync", "ftruncate", "ftruncate64", "futex",
+	"futex_requeue", "futex_time64", "futex_wait", "futex_waitv", "futex_wake", "futimesat", "getcpu", "getcwd",
+	"getdents", "getdents64", "getegid", "getegid32", "geteuid", "geteuid32", "getgid", "getgid32", "getgroups",
+	"getgroups32", "getitimer", "getpeername", "getpgid", "getpgrp", "getpid", "getppid", "getpriority",
+	"getrandom", "getresgid", "getresgid32", "getresuid", "getresuid32", "getrlimit", "get_robust_list",
+	"getrusage", "getsid", "getsockname", "getsockopt", "get_thread_area", "gettid", "gettimeofday", "getuid",
+	"getuid32", "getxattr", "inotify_add_watch", "inotify_init", "inotify_init1", "inotify_rm_watch",
+	"io_cancel", "ioctl", "io_destroy", "io_getevents", "io_pgetevents", "io_pgetevents_time64", "ioprio_get",
+	"ioprio_set", "io_setup", "io_submit", "ipc", "kill", "landlock_add_rule", "landlock_create_ruleset",
+	"landlock_restrict_self", "lchown", "lchown32", "lgetxattr", "link", "linkat", "listen", "listxattr",
+	"llistxattr", "_llseek", "lremovexattr", "lseek", "lsetxattr", "lstat", "lstat64", "madvise",
+	"map_shadow_stack", "membarrier", "memfd_create", "memfd_secret", "mincore", "mkdir", "mkdirat", "mknod",
+	"mknodat", "mlock", "mlock2", "mlockall", "mmap", "mmap2", "mprotect", "mq_getsetattr", "mq_notify",
+	"mq_open", "mq_timedreceive", "mq_timedreceive_time64", "mq_timedsend", "mq_timedsend_time64", "mq_unlink",
+	"mremap", "msgctl", "msgget", "msgrcv", "msgsnd", "msync", "munlock", "munlockall", "munmap", "name_to_handle_at",
+	"nanosleep", "newfstatat", "_newselect", "open", "openat", "openat2", "pause", "pidfd_open", "pidfd_send_signal", "pipe",
+	"pipe2", "pkey_alloc", "pkey_free", "pkey_mprotect", "poll", "ppoll", "ppoll_time64", "prctl", "pread64",
+	"preadv", "preadv2", "prlimit64", "process_mrelease", "pselect6", "pselect6_time64", "pwrite64", "pwritev",
+	"pwritev2", "read", "readahead", "readlink", "readlinkat", "readv", "recv", "recvfrom", "recvmmsg",
+	"recvmmsg_time64", "recvmsg", "remap_file_pages", "removexattr", "rename", "renameat", "renameat2",
+	"restart_syscall", "rmdir", "rseq", "rt_sigaction", "rt_sigpending", "rt_sigprocmask", "rt_sigqueueinfo",
+	"rt_sigreturn", "rt_sigsuspend", "rt_sigtimedwait", "rt_sigtimedwait_time64", "rt_tgsigqueueinfo",
+	"sched_getaffinity", "sched_getattr", "sched_getparam", "sched_get_priority_max", "sched_get_priority_min",
+	"sched_getscheduler", "sched_rr_get_interval", "sched_rr_get_interval_time64", "sched_setaffinity",
+	"sched_setattr", "sched_setparam", "sched_setscheduler", "sched_yield", "seccomp", "select", "semctl",
+	"semget", "semop", "semtimedop", "semtimedop_time64", "send", "sendfile", "sendfile64", "sendmmsg",
+	"sendmsg", "sendto", "setfsgid", "setfsgid32", "setfsuid", "setfsuid32", "setgid", "setgid32", "setgroups",
+	"setgroups32", "setitimer", "setpgid", "setpriority", "setregid", "setregid32", "setresgid", "setresgid32",
+	"setresuid", "setresuid32", "setreuid", "setreuid32", "setrlimit", "set_robust_list", "setsid",
+	"setsockopt", "set_thread_area", "set_tid_address", "setuid", "setuid32", "setxattr", "shmat", "shmctl",
+	"shmdt", "shmget", "shutdown", "sigaltstack", "signalfd", "signalfd4", "sigprocmask", "sigreturn",
+	"socketcall", "socketpair", "splice", "stat", "stat64", "statfs", "statfs64", "statx", "symlink",
+	"symlinkat", "sync", "sync_file_range", "syncfs", "sysinfo", "tee", "tgkill", "time", "timer_create",
+	"timer_delete", "timer_getoverrun", "timer_gettime", "timer_gettime64", "timer_settime",
+	"timer_settime64", "timerfd_create", "timerfd_gettime", "timerfd_gettime64", "timerfd_settime",
+	"timerfd_settime64", "times", "tkill", "truncate", "truncate64", "ugetrlimit", "umask", "uname", "unlink",
+	"unlinkat", "utime", "utimensat", "utimensat_time64", "utimes", "vfork", "vmsplice", "wait4", "waitid",
+	"waitpid", "write", "writev",
+}
@@ -0,0 +1,12 @@
+//go:build !amd64 && !arm64
+
+package container
+
+const (
+	// seccompNativeArch is 0 on the archs without a syscall table, a profile can't be compiled on them
+	seccompNativeArch     = 0
+	seccompNativeArchName = ""
+	seccompX32SyscallBit  = 0
+)
+
+var seccompSyscalls = map[string]int{}
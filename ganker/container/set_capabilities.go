@@ -0,0 +1,236 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// capabilityAll stands for every capability in --cap-add and --cap-drop
+const capabilityAll = "ALL"
+
+// capabilities are the names of the capabilities known by ganker
+var capabilities = map[string]int{
+	"CAP_CHOWN":              syscall.CAP_CHOWN,
+	"CAP_DAC_OVERRIDE":       syscall.CAP_DAC_OVERRIDE,
+	"CAP_DAC_READ_SEARCH":    syscall.CAP_DAC_READ_SEARCH,
+	"CAP_FOWNER":             syscall.CAP_FOWNER,
+	"CAP_FSETID":             syscall.CAP_FSETID,
+	"CAP_KILL":               syscall.CAP_KILL,
+	"CAP_SETGID":             syscall.CAP_SETGID,
+	"CAP_SETUID":             syscall.CAP_SETUID,
+	"CAP_SETPCAP":            syscall.CAP_SETPCAP,
+	"CAP_LINUX_IMMUTABLE":    syscall.CAP_LINUX_IMMUTABLE,
+	"CAP_NET_BIND_SERVICE":   syscall.CAP_NET_BIND_SERVICE,
+	"CAP_NET_BROADCAST":      syscall.CAP_NET_BROADCAST,
+	"CAP_NET_ADMIN":          syscall.CAP_NET_ADMIN,
+	"CAP_NET_RAW":            syscall.CAP_NET_RAW,
+	"CAP_IPC_LOCK":           syscall.CAP_IPC_LOCK,
+	"CAP_IPC_OWNER":          syscall.CAP_IPC_OWNER,
+	"CAP_SYS_MODULE":         syscall.CAP_SYS_MODULE,
+	"CAP_SYS_RAWIO":          syscall.CAP_SYS_RAWIO,
+	"CAP_SYS_CHROOT":         syscall.CAP_SYS_CHROOT,
+	"CAP_SYS_PTRACE":         syscall.CAP_SYS_PTRACE,
+	"CAP_SYS_PACCT":          syscall.CAP_SYS_PACCT,
+	"CAP_SYS_ADMIN":          syscall.CAP_SYS_ADMIN,
+	"CAP_SYS_BOOT":           syscall.CAP_SYS_BOOT,
+	"CAP_SYS_NICE":           syscall.CAP_SYS_NICE,
+	"CAP_SYS_RESOURCE":       syscall.CAP_SYS_RESOURCE,
+	"CAP_SYS_TIME":           syscall.CAP_SYS_TIME,
+	"CAP_SYS_TTY_CONFIG":     syscall.CAP_SYS_TTY_CONFIG,
+	"CAP_MKNOD":              syscall.CAP_MKNOD,
+	"CAP_LEASE":              syscall.CAP_LEASE,
+	"CAP_AUDIT_WRITE":        syscall.CAP_AUDIT_WRITE,
+	"CAP_AUDIT_CONTROL":      syscall.CAP_AUDIT_CONTROL,
+	"CAP_SETFCAP":            syscall.CAP_SETFCAP,
+	"CAP_MAC_OVERRIDE":       syscall.CAP_MAC_OVERRIDE,
+	"CAP_MAC_ADMIN":          syscall.CAP_MAC_ADMIN,
+	"CAP_SYSLOG":             syscall.CAP_SYSLOG,
+	"CAP_WAKE_ALARM":         syscall.CAP_WAKE_ALARM,
+	"CAP_BLOCK_SUSPEND":      syscall.CAP_BLOCK_SUSPEND,
+	"CAP_AUDIT_READ":         syscall.CAP_AUDIT_READ,
+	"CAP_PERFMON":            syscall.CAP_PERFMON,
+	"CAP_BPF":                syscall.CAP_BPF,
+	"CAP_CHECKPOINT_RESTORE": syscall.CAP_CHECKPOINT_RESTORE,
+}
+
+// DefaultCapabilities are kept by a container if no capability is added or dropped, the same as docker
+var DefaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// CapabilityOptions are the capabilities given by --cap-add, --cap-drop and --privileged
+type CapabilityOptions struct {
+	Add        []string
+	Drop       []string
+	Privileged bool // keep all capabilities, --cap-add and --cap-drop are ignored
+}
+
+// Resolve returns the capabilities of the process from base, ordered by their numbers.
+// all capabilities except the dropped ones are kept if ALL is added, and only the added ones if ALL is dropped
+func (o CapabilityOptions) Resolve(base []string) ([]string, error) {
+	all := allCapabilities()
+	if o.Privileged {
+		return all, nil
+	}
+
+	add, err := normalizeCapabilities(o.Add)
+	if err != nil {
+		return nil, err
+	}
+	drop, err := normalizeCapabilities(o.Drop)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]bool{}
+	switch {
+	case contains(add, capabilityAll):
+		for _, c := range all {
+			result[c] = true
+		}
+	case contains(drop, capabilityAll):
+	default:
+		for _, c := range base {
+			result[c] = true
+		}
+	}
+	if !contains(add, capabilityAll) {
+		for _, c := range add {
+			result[c] = true
+		}
+	}
+	if !contains(drop, capabilityAll) {
+		for _, c := range drop {
+			delete(result, c)
+		}
+	}
+
+	// the capabilities unknown to the kernel are left out
+	caps := []string{}
+	for _, c := range all {
+		if result[c] {
+			caps = append(caps, c)
+		}
+	}
+	return caps, nil
+}
+
+// normalizeCapabilities converts names like "sys_admin" to "CAP_SYS_ADMIN"
+func normalizeCapabilities(names []string) ([]string, error) {
+	var caps []string
+	for _, name := range names {
+		c := strings.ToUpper(name)
+		if c == capabilityAll {
+			caps = append(caps, c)
+			continue
+		}
+		if !strings.HasPrefix(c, "CAP_") {
+			c = "CAP_" + c
+		}
+		if _, ok := capabilities[c]; !ok {
+			return nil, fmt.Errorf("unknown capability %s", name)
+		}
+		caps = append(caps, c)
+	}
+	return caps, nil
+}
+
+// allCapabilities returns the capabilities supported by the running kernel, ordered by their numbers
+func allCapabilities() []string {
+	last := syscall.CAP_LAST_CAP
+	if content, err := os.ReadFile("/proc/sys/kernel/cap_last_cap"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+			last = n
+		}
+	}
+	var caps []string
+	for c, n := range capabilities {
+		if n <= last {
+			caps = append(caps, c)
+		}
+	}
+	sort.Slice(caps, func(i, j int) bool { return capabilities[caps[i]] < capabilities[caps[j]] })
+	return caps
+}
+
+// capabilityMask returns the bit mask of the capabilities, bit n is set for capability n
+func capabilityMask(caps []string) uint64 {
+	var mask uint64
+	for _, c := range caps {
+		mask |= 1 << uint(capabilities[c])
+	}
+	return mask
+}
+
+// dropBoundingSet drops the capabilities out of mask from the bounding set, so they can never be regained,
+// even by executing a setuid or file capability binary. it needs CAP_SETPCAP, so it's done before the user is switched
+func dropBoundingSet(mask uint64) error {
+	for _, c := range allCapabilities() {
+		n := capabilities[c]
+		if mask&(1<<uint(n)) != 0 {
+			continue
+		}
+		if err := syscall.Prctl(syscall.PR_CAPBSET_DROP, uintptr(n), 0, 0, 0); err != nil {
+			return fmt.Errorf("drop %s from bounding set error: %v", c, err)
+		}
+	}
+	return nil
+}
+
+// setCapabilities limits the effective, permitted and inheritable sets to mask, and raises the ambient set to them,
+// the ambient capabilities are kept by the command when it's executed as a user other than root.
+// the capabilities ganker itself doesn't have are left out
+func setCapabilities(mask uint64) error {
+	header := syscall.CapUserHeader{Version: syscall.LINUX_CAPABILITY_VERSION_3}
+	var data [2]syscall.CapUserData
+	if err := syscall.Capget(&header, &data[0]); err != nil {
+		return fmt.Errorf("get capabilities error: %v", err)
+	}
+	var permitted uint64
+	for i := range data {
+		m := uint32(mask >> (32 * i))
+		data[i].Permitted &= m
+		data[i].Effective = data[i].Permitted
+		data[i].Inheritable = data[i].Permitted
+		permitted |= uint64(data[i].Permitted) << (32 * i)
+	}
+	if err := syscall.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("set capabilities error: %v", err)
+	}
+
+	if err := syscall.Prctl(syscall.PR_CAP_AMBIENT, syscall.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		// the ambient set needs linux 4.3 or later
+		if err == syscall.EINVAL {
+			return nil
+		}
+		return fmt.Errorf("clear ambient capabilities error: %v", err)
+	}
+	for _, c := range allCapabilities() {
+		n := capabilities[c]
+		if permitted&(1<<uint(n)) == 0 {
+			continue
+		}
+		if err := syscall.Prctl(syscall.PR_CAP_AMBIENT, syscall.PR_CAP_AMBIENT_RAISE, uintptr(n), 0, 0); err != nil {
+			return fmt.Errorf("raise ambient capability %s error: %v", c, err)
+		}
+	}
+	return nil
+}
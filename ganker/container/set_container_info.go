@@ -3,6 +3,7 @@ package container
 import (
 	"fmt"
 	"go_docker_learning/ganker/cgroup"
+	"go_docker_learning/ganker/cgroup/subsystem"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -13,12 +14,17 @@ import (
 	json "github.com/goccy/go-json"
 
 	log "github.com/sirupsen/logrus"
+	syscall "golang.org/x/sys/unix"
 )
 
 const (
 	RUNNING      = "Up"
+	PAUSED       = "Paused"
 	EXIT         = "Exited"
 	InfoFileName = "containerInfo.json"
+
+	// oomExitCode is the exit code of a process killed by SIGKILL, which is what the oom killer sends
+	oomExitCode = 128 + 9
 )
 
 type Info struct {
@@ -31,80 +37,106 @@ type Info struct {
 	Status      string   `json:"status"`       // 容器的状态
 	Volume      string   `json:"volume"`       // 容器的数据卷
 	PortMapping []string `json:"portmapping"`  // 容器的端口映射
+
+	Resource  *subsystem.ResourceConfig `json:"resource"`   // 容器的资源限制
+	ExitCode  *int                      `json:"exit code"`  // 容器退出码, 未能获取时为空
+	OOMKilled bool                      `json:"oom killed"` // 容器是否因内存超限被杀死
+
+	UserNamespace *UserNamespace    `json:"user namespace"` // 容器的用户命名空间映射, 为空时与宿主机共享
+	NetworkPid    int               `json:"network pid"`    // rootless 容器的 slirp4netns 进程 PID
+	Namespaces    map[string]string `json:"namespaces"`     // 与宿主机或其他容器共享的命名空间, 如 net: container:<id>
+	Capabilities  []string          `json:"capabilities"`   // 容器进程保留的 capabilities
+	Privileged    bool              `json:"privileged"`     // 容器是否以特权模式运行
+	Seccomp       string            `json:"seccomp"`        // 容器的 seccomp 配置: default, unconfined 或配置文件路径
+	User          string            `json:"user"`           // 容器进程的用户, 为空时为 root
+	NoNewPrivs    bool              `json:"no new privs"`   // 容器进程是否设置了 no_new_privs
 }
 
-func recordContainerInfo(cPid, containerId, containerName, imageName, volume string, portMapping, commandArray []string) error {
+func recordContainerInfo(cPid, containerId string, networkPid int, opts *RunOptions) error {
 	createTime := time.Now().Format("2006-01-02 15:04:05")
-	command := strings.Join(commandArray, "")
+	command := strings.Join(opts.Command, "")
 	containerInfo := &Info{
-		Pid:         cPid,
-		Image:       imageName,
-		ContainerId: containerId,
-		Name:        containerName,
-		Command:     command,
-		Created:     createTime,
-		Status:      RUNNING,
-		Volume:      volume,
+		Pid:           cPid,
+		Image:         opts.Image,
+		ContainerId:   containerId,
+		Name:          opts.Name,
+		Command:       command,
+		Created:       createTime,
+		Status:        RUNNING,
+		Volume:        opts.Volume,
+		PortMapping:   opts.PortMapping,
+		Resource:      opts.Resource,
+		UserNamespace: opts.UserNamespace,
+		NetworkPid:    networkPid,
+		Namespaces:    opts.Namespaces,
+		Capabilities:  opts.Capabilities,
+		Privileged:    opts.Privileged,
+		Seccomp:       opts.Security.Seccomp,
+		User:          opts.User,
+		NoNewPrivs:    opts.Security.NoNewPrivileges,
 	}
 
+	return dumpContainerInfo(containerInfo)
+}
+
+// lockContainer takes an exclusive lock of the container dir, so that the read-modify-write of the info by
+// update, stop and pause isn't interleaved with another ganker process, call the returned func to unlock
+func lockContainer(containerId string) (func(), error) {
+	dir, err := os.Open(ContainerRootPath + containerId)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(dir.Fd()), syscall.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("lock container %s error: %v", containerId, err)
+	}
+	// the lock goes with the file description
+	return func() { dir.Close() }, nil
+}
+
+// dumpContainerInfo writes the info into a temp file and renames it to the info file,
+// so the info file is replaced atomically and never left half written
+func dumpContainerInfo(containerInfo *Info) error {
 	jsonBody, err := json.Marshal(containerInfo)
 	if err != nil {
 		return err
 	}
 
-	containerPath := ContainerRootPath + containerId
+	containerPath := ContainerRootPath + containerInfo.ContainerId
 
-	if err := os.MkdirAll(containerPath, 0622); err != nil {
+	if err := os.MkdirAll(containerPath, 0755); err != nil {
 		return err
 	}
 
 	jsonFile := filepath.Join(containerPath, InfoFileName)
-	file, err1 := os.OpenFile(jsonFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
-	if err1 != nil {
+	tmpFile := jsonFile + ".tmp"
+	if err := os.WriteFile(tmpFile, jsonBody, 0644); err != nil {
 		return err
 	}
 
-	defer file.Close()
-
-	if _, err := file.Write(jsonBody); err != nil {
-		return err
-	}
-
-	return nil
+	return os.Rename(tmpFile, jsonFile)
 }
 
-func quitContainer(containerId string) *Info {
-	containerInfoPath := filepath.Join(ContainerRootPath+containerId, InfoFileName)
-
-	file, err := os.OpenFile(containerInfoPath, os.O_RDWR|os.O_CREATE, 0644)
+// quitContainer marks the container exited with the exit code, and whether it was killed by the oom killer.
+// the exit code is nil if the exit status can't be collected, as only the parent of a detached container could wait for it
+func quitContainer(containerId string, exitCode *int, oomKilled bool) *Info {
+	containerInfo, err := getContainerFileInfo(containerId)
 	if err != nil {
-		log.Errorf("Open file %s error %v", containerInfoPath, err)
-		return nil
-	}
-
-	defer file.Close()
-
-	// 读取文件内容
-	var containerInfo Info
-
-	if err := json.NewDecoder(file).Decode(&containerInfo); err != nil {
-		log.Errorf("Decode file %s error: %v\n", containerInfoPath, err)
+		log.Errorf("Get container %s info error %v", containerId, err)
 		return nil
 	}
+	stopSlirp4netns(containerInfo.NetworkPid)
 	containerInfo.Status = EXIT
 	containerInfo.Pid = " "
+	containerInfo.NetworkPid = 0
+	containerInfo.ExitCode = exitCode
+	containerInfo.OOMKilled = oomKilled
 
-	jsonBytes, err := json.Marshal(containerInfo)
-	if err != nil {
-		log.Errorf("Json marshal error %v", err)
+	if err := dumpContainerInfo(containerInfo); err != nil {
+		log.Errorf("Dump container %s info error %v", containerId, err)
 		return nil
 	}
-
-	if _, err := file.WriteAt(jsonBytes, 0); err != nil {
-		log.Errorf("Write string to file error %v", err)
-		return nil
-	}
-	return &containerInfo
+	return containerInfo
 }
 
 func deleteContainerInfo(containerID string) {
@@ -131,18 +163,19 @@ func ShowContainersInfo(all bool) {
 		if all {
 			containerInfos = append(containerInfos, containerInfo)
 		} else {
-			if containerInfo.Status == RUNNING {
+			if containerInfo.Status == RUNNING || containerInfo.Status == PAUSED {
 				containerInfos = append(containerInfos, containerInfo)
 			}
 		}
 	}
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprintf(w, "ID\tPID\tSTATUS\tIMAGE\tCREATED\tNAME\tCOMMAND\tVOLUME\n")
+	fmt.Fprintf(w, "ID\tPID\tSTATUS\tPIDS\tIMAGE\tCREATED\tNAME\tCOMMAND\tVOLUME\n")
 	for _, item := range containerInfos {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
 			item.ContainerId,
 			item.Pid,
-			item.Status,
+			formatStatus(item),
+			getContainerPids(item),
 			item.Image,
 			item.Created,
 			item.Name,
@@ -155,6 +188,40 @@ func ShowContainersInfo(all bool) {
 	}
 }
 
+// formatStatus shows the exit code and the oom kill of an exited container, like "Exited (137) OOMKilled"
+func formatStatus(containerInfo *Info) string {
+	if containerInfo.Status != EXIT {
+		return containerInfo.Status
+	}
+	status := containerInfo.Status
+	// an unknown exit code was recorded as -1 before it could be left out
+	if containerInfo.ExitCode != nil && *containerInfo.ExitCode >= 0 {
+		status = fmt.Sprintf("%s (%d)", status, *containerInfo.ExitCode)
+	}
+	if containerInfo.OOMKilled {
+		status += " OOMKilled"
+	}
+	return status
+}
+
+// getContainerPids returns the number of processes in a running container against its limit, like "3/100",
+// followed by the forks rejected by the limit if any, "-" if it can't be read
+func getContainerPids(containerInfo *Info) string {
+	if containerInfo.Status != RUNNING && containerInfo.Status != PAUSED {
+		return "-"
+	}
+	cGroupManager := cgroup.NewCgroupManager(getCgroupPath(containerInfo.ContainerId))
+	stats, err := cGroupManager.PidsStats()
+	if err != nil {
+		return "-"
+	}
+	pids := fmt.Sprintf("%d/%s", stats.Current, stats.Limit)
+	if stats.Rejected > 0 {
+		pids += fmt.Sprintf(" (%d rejected)", stats.Rejected)
+	}
+	return pids
+}
+
 // getContainerInfo check if container is running, if not, quit container and return container info
 func getContainerInfo(containerId string) (*Info, error) {
 	containerInfo, err := getContainerFileInfo(containerId)
@@ -173,11 +240,18 @@ func getContainerInfo(containerId string) (*Info, error) {
 		return nil, err
 	}
 
-	if containerInfo.Status == RUNNING && !checkProcessIsAlive(pid) {
+	if (containerInfo.Status == RUNNING || containerInfo.Status == PAUSED) && !checkProcessIsAlive(pid) {
 
 		log.Infof("container %s process is not running now", containerId)
 
-		cGroupManager := cgroup.NewCgroupManager("GankerCgroup" + "/" + containerId)
+		cGroupManager := cgroup.NewCgroupManager(getCgroupPath(containerId))
+		// the exit status is lost with the parent, but the oom kill is still counted in the cgroup
+		var exitCode *int
+		oomKilled := cGroupManager.OOMKilled()
+		if oomKilled {
+			code := oomExitCode
+			exitCode = &code
+		}
 		if err := cGroupManager.Delete(); err != nil {
 			log.Errorf("Destroy cgroup fail %v", err)
 			return nil, err
@@ -186,7 +260,7 @@ func getContainerInfo(containerId string) (*Info, error) {
 		containerDir := StorageRootPath + containerId + "/"
 		deleteWorkSpace(containerDir, containerInfo.Volume)
 
-		containerInfo = quitContainer(containerId)
+		containerInfo = quitContainer(containerId, exitCode, oomKilled)
 		if containerInfo == nil {
 			return nil, fmt.Errorf("quit container error")
 		}
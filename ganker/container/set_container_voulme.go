@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
 
@@ -33,11 +34,14 @@ func extractVolume(volume string) ([]string, error) {
 	return nil, fmt.Errorf("path includes invalid characters for a local volume name, only '[a-zA-Z0-9][a-zA-Z0-9_.-]' are allowed")
 }
 
-// mountVolume mount the volume to the container
-func mountVolume(volumeArray []string, containerDir string) {
-	if err := os.MkdirAll(volumeArray[0], 0777); err != nil {
-		log.Errorf("Fail to create the volume dir: " + err.Error())
-		os.Exit(-1)
+// mountVolume mount the volume to the container, a new volume dir is owned by root of the container if userNs is not nil
+func mountVolume(volumeArray []string, containerDir string, userNs *UserNamespace) {
+	// an existing dir is left alone, it may be shared with the host or other containers
+	if created := createVolume(volumeArray); created && userNs != nil {
+		if err := userNs.chownRoot(volumeArray[0]); err != nil {
+			log.Errorf("Fail to change the owner of the volume dir: " + err.Error())
+			os.Exit(-1)
+		}
 	}
 
 	containerMountPoint := containerDir + MergeLayerName + volumeArray[1]
@@ -52,6 +56,34 @@ func mountVolume(volumeArray []string, containerDir string) {
 	}
 }
 
+// createVolume creates the volume dir on the host, it returns false if the dir exists already
+func createVolume(volumeArray []string) bool {
+	exist, err := checkFileOrDirExist(volumeArray[0])
+	if err != nil {
+		log.Errorf("Fail to judge if the volume dir exist: " + err.Error())
+		os.Exit(-1)
+	}
+	if err := os.MkdirAll(volumeArray[0], 0777); err != nil {
+		log.Errorf("Fail to create the volume dir: " + err.Error())
+		os.Exit(-1)
+	}
+	return !exist
+}
+
+// volumeMount returns the bind mount of the volume done by init, which is used by rootless containers
+// as they can't mount on the host
+func volumeMount(volume string) (*Mount, error) {
+	volumeArray, err := extractVolume(volume)
+	if volumeArray == nil {
+		return nil, err
+	}
+	source, err := filepath.Abs(volumeArray[0])
+	if err != nil {
+		return nil, err
+	}
+	return &Mount{Source: source, Destination: volumeArray[1], Flags: syscall.MS_BIND | syscall.MS_REC}, nil
+}
+
 // deleteVolume delete the volume from the container
 func deleteVolume(containerDir, volume string) {
 	containerVolumePath := containerDir + MergeLayerName + volume
@@ -0,0 +1,203 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go_docker_learning/ganker/cgroup/subsystem"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// DefaultShmSize is the size of /dev/shm if --shm-size is not given, the same as docker
+const DefaultShmSize = "64m"
+
+// Device is a device node created in /dev of the container
+type Device struct {
+	Path     string `json:"path"`      // absolute path in the container
+	HostPath string `json:"host path"` // the device on the host, it is bind mounted if the node can't be created
+	Type     rune   `json:"type"`      // 'c' or 'b'
+	Major    int64  `json:"major"`
+	Minor    int64  `json:"minor"`
+	FileMode uint32 `json:"file mode"`
+	Access   string `json:"access"` // access allowed by the device cgroup, like "rwm"
+}
+
+// defaultDevices are created in every container
+var defaultDevices = []Device{
+	{Path: "/dev/null", HostPath: "/dev/null", Type: 'c', Major: 1, Minor: 3, FileMode: 0666, Access: "rwm"},
+	{Path: "/dev/zero", HostPath: "/dev/zero", Type: 'c', Major: 1, Minor: 5, FileMode: 0666, Access: "rwm"},
+	{Path: "/dev/full", HostPath: "/dev/full", Type: 'c', Major: 1, Minor: 7, FileMode: 0666, Access: "rwm"},
+	{Path: "/dev/random", HostPath: "/dev/random", Type: 'c', Major: 1, Minor: 8, FileMode: 0666, Access: "rwm"},
+	{Path: "/dev/urandom", HostPath: "/dev/urandom", Type: 'c', Major: 1, Minor: 9, FileMode: 0666, Access: "rwm"},
+	{Path: "/dev/tty", HostPath: "/dev/tty", Type: 'c', Major: 5, Minor: 0, FileMode: 0666, Access: "rwm"},
+}
+
+// defaultDeviceRules are allowed by the device cgroup besides the devices of the container:
+// mknod of any device, the console, /dev/ptmx and the pseudo terminals in /dev/pts
+var defaultDeviceRules = []subsystem.DeviceRule{
+	{Type: 'c', Major: -1, Minor: -1, Access: "m"},
+	{Type: 'b', Major: -1, Minor: -1, Access: "m"},
+	{Type: 'c', Major: 5, Minor: 1, Access: "rwm"},
+	{Type: 'c', Major: 5, Minor: 2, Access: "rwm"},
+	{Type: 'c', Major: 136, Minor: -1, Access: "rwm"},
+}
+
+// devSymlinks are created in /dev of the container, the key is the link and the value is its target
+var devSymlinks = map[string]string{
+	"/dev/fd":     "/proc/self/fd",
+	"/dev/stdin":  "/proc/self/fd/0",
+	"/dev/stdout": "/proc/self/fd/1",
+	"/dev/stderr": "/proc/self/fd/2",
+	"/dev/ptmx":   "pts/ptmx",
+	"/dev/core":   "/proc/kcore",
+}
+
+// ParseDevices parses devices like "/dev/sdc", "/dev/sdc:/dev/xvdc" or "/dev/sdc:/dev/xvdc:rw",
+// the device has the same path in the container if it's not given, and all access if the access is not given
+func ParseDevices(specs []string) ([]Device, error) {
+	var devices []Device
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		hostPath, path, access := parts[0], parts[0], ""
+		switch len(parts) {
+		case 1:
+		case 2:
+			// the second part is the access if it's not a path, like "/dev/sdc:r"
+			if strings.HasPrefix(parts[1], "/") {
+				path = parts[1]
+			} else {
+				access = parts[1]
+			}
+		case 3:
+			path, access = parts[1], parts[2]
+		default:
+			return nil, fmt.Errorf("invalid device %s, it should be like <host-path>[:<container-path>][:<access>]", spec)
+		}
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("invalid device %s, the path in the container must be absolute", spec)
+		}
+		access, err := subsystem.ParseDeviceAccess(access)
+		if err != nil {
+			return nil, err
+		}
+
+		var stat syscall.Stat_t
+		if err := syscall.Stat(hostPath, &stat); err != nil {
+			return nil, fmt.Errorf("stat device %s error: %v", hostPath, err)
+		}
+		device := Device{
+			Path:     filepath.Clean(path),
+			HostPath: hostPath,
+			Major:    int64(syscall.Major(stat.Rdev)),
+			Minor:    int64(syscall.Minor(stat.Rdev)),
+			FileMode: stat.Mode &^ syscall.S_IFMT,
+			Access:   access,
+		}
+		switch stat.Mode & syscall.S_IFMT {
+		case syscall.S_IFCHR:
+			device.Type = 'c'
+		case syscall.S_IFBLK:
+			device.Type = 'b'
+		default:
+			return nil, fmt.Errorf("%s is not a device", hostPath)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// deviceRules returns the rules of the device cgroup allowing the default devices and the devices of the container
+func deviceRules(devices []Device) []subsystem.DeviceRule {
+	rules := append([]subsystem.DeviceRule{}, defaultDeviceRules...)
+	for _, device := range append(append([]Device{}, defaultDevices...), devices...) {
+		rules = append(rules, subsystem.DeviceRule{Type: device.Type, Major: device.Major, Minor: device.Minor, Access: device.Access})
+	}
+	return rules
+}
+
+// setupDev mounts a tmpfs on /dev of the rootfs and populates it with the devices, the pseudo terminals
+// of a private devpts and a /dev/shm of shmSize bytes. it is done before the extra mounts, so that they
+// can be mounted in /dev
+func setupDev(rootfs string, devices []Device, shmSize uint64) error {
+	devPath := filepath.Join(rootfs, "dev")
+	if err := os.MkdirAll(devPath, 0755); err != nil {
+		return fmt.Errorf("create /dev error: %v", err)
+	}
+	if err := syscall.Mount("tmpfs", devPath, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return fmt.Errorf("mount tmpfs on /dev error: %v", err)
+	}
+
+	for _, device := range append(append([]Device{}, defaultDevices...), devices...) {
+		if err := createDevice(rootfs, device); err != nil {
+			return err
+		}
+	}
+	for link, target := range devSymlinks {
+		if err := os.Symlink(target, filepath.Join(rootfs, link)); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("create symlink %s error: %v", link, err)
+		}
+	}
+
+	// a new instance of devpts, so the container can't see the pseudo terminals of the host
+	ptsPath := filepath.Join(devPath, "pts")
+	if err := os.MkdirAll(ptsPath, 0755); err != nil {
+		return fmt.Errorf("create /dev/pts error: %v", err)
+	}
+	ptsFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NOEXEC)
+	if err := syscall.Mount("devpts", ptsPath, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620,gid=5"); err != nil {
+		// gid 5 (tty) may not be mapped in the user namespace of the container
+		if err := syscall.Mount("devpts", ptsPath, "devpts", ptsFlags, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+			return fmt.Errorf("mount devpts error: %v", err)
+		}
+	}
+
+	shmPath := filepath.Join(devPath, "shm")
+	if err := os.MkdirAll(shmPath, 0755); err != nil {
+		return fmt.Errorf("create /dev/shm error: %v", err)
+	}
+	shmFlags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	if err := syscall.Mount("shm", shmPath, "tmpfs", shmFlags, fmt.Sprintf("mode=1777,size=%d", shmSize)); err != nil {
+		return fmt.Errorf("mount /dev/shm error: %v", err)
+	}
+	return nil
+}
+
+// createDevice creates the device node in the rootfs, the device of the host is bind mounted instead
+// if mknod is not allowed, like in a user namespace
+func createDevice(rootfs string, device Device) error {
+	dest := filepath.Join(rootfs, device.Path)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("create dir of device %s error: %v", device.Path, err)
+	}
+
+	fileType := uint32(syscall.S_IFCHR)
+	if device.Type == 'b' {
+		fileType = syscall.S_IFBLK
+	}
+	dev := syscall.Mkdev(uint32(device.Major), uint32(device.Minor))
+	err := syscall.Mknod(dest, fileType|device.FileMode, int(dev))
+	if err == nil {
+		// the mode given to mknod is masked by the umask
+		if err := syscall.Chmod(dest, device.FileMode); err != nil {
+			return fmt.Errorf("chmod device %s error: %v", device.Path, err)
+		}
+		return nil
+	}
+	if !errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("create device %s error: %v", device.Path, err)
+	}
+
+	file, err := os.OpenFile(dest, os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("create mount point of device %s error: %v", device.Path, err)
+	}
+	file.Close()
+	if err := syscall.Mount(device.HostPath, dest, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount device %s error: %v", device.HostPath, err)
+	}
+	return nil
+}
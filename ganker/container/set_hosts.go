@@ -0,0 +1,128 @@
+package container
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+const (
+	HostsFileName    = "hosts"
+	HostnameFileName = "hostname"
+	ResolvFileName   = "resolv.conf"
+
+	// slirpDNS is the dns forwarder of slirp4netns in the network of a rootless container
+	slirpDNS = "10.0.2.3"
+)
+
+// defaultDNS are used when the host only has nameservers on its loopback, which the container can't reach
+var defaultDNS = []string{"8.8.8.8", "8.8.4.4"}
+
+// setupEtcFiles writes the hosts, hostname and resolv.conf of the container into ContainerRootPath/<id>/,
+// and returns the bind mounts of them on the rootfs. a container sharing the network namespace of another one
+// shares its hosts and resolv.conf too
+func setupEtcFiles(containerId string, opts *RunOptions, ip net.IP) ([]Mount, error) {
+	containerPath, err := filepath.Abs(ContainerRootPath + containerId)
+	if err != nil {
+		return nil, err
+	}
+
+	hostsPath := filepath.Join(containerPath, HostsFileName)
+	resolvPath := filepath.Join(containerPath, ResolvFileName)
+	if netContainer, ok := strings.CutPrefix(opts.Namespaces["net"], namespaceContainerPrefix); ok {
+		netContainerPath, err := filepath.Abs(ContainerRootPath + netContainer)
+		if err != nil {
+			return nil, err
+		}
+		hostsPath = filepath.Join(netContainerPath, HostsFileName)
+		resolvPath = filepath.Join(netContainerPath, ResolvFileName)
+	} else {
+		if err := os.WriteFile(hostsPath, []byte(buildHosts(opts.Hostname, opts.Domainname, ip)), 0644); err != nil {
+			return nil, fmt.Errorf("write hosts error: %v", err)
+		}
+		resolv, err := buildResolvConf(opts.Network)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(resolvPath, []byte(resolv), 0644); err != nil {
+			return nil, fmt.Errorf("write resolv.conf error: %v", err)
+		}
+	}
+
+	mounts := []Mount{
+		{Source: hostsPath, Destination: "/etc/hosts", Flags: syscall.MS_BIND},
+		{Source: resolvPath, Destination: "/etc/resolv.conf", Flags: syscall.MS_BIND},
+	}
+	// the hostname of the host is not written into the container
+	if opts.Hostname != "" {
+		hostnamePath := filepath.Join(containerPath, HostnameFileName)
+		if err := os.WriteFile(hostnamePath, []byte(opts.Hostname+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("write hostname error: %v", err)
+		}
+		mounts = append(mounts, Mount{Source: hostnamePath, Destination: "/etc/hostname", Flags: syscall.MS_BIND})
+	}
+	return mounts, nil
+}
+
+// buildHosts returns the hosts file resolving the hostname to the ip of the container in its network,
+// or to the loopback if the container is not connected to a network
+func buildHosts(hostname, domainname string, ip net.IP) string {
+	var hosts strings.Builder
+	hosts.WriteString("127.0.0.1\tlocalhost\n")
+	hosts.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	if hostname == "" {
+		return hosts.String()
+	}
+
+	names := hostname
+	if domainname != "" {
+		names = hostname + "." + domainname + " " + hostname
+	}
+	if ip != nil {
+		fmt.Fprintf(&hosts, "%s\t%s\n", ip.String(), names)
+	} else {
+		fmt.Fprintf(&hosts, "127.0.1.1\t%s\n", names)
+	}
+	return hosts.String()
+}
+
+// buildResolvConf returns the resolv.conf of the host without the nameservers on the loopback,
+// a rootless container with slirp4netns uses its dns forwarder instead
+func buildResolvConf(network string) (string, error) {
+	if Rootless && network == NetworkSlirp4netns {
+		return "nameserver " + slirpDNS + "\n", nil
+	}
+
+	// systemd-resolved keeps the real nameservers here, /etc/resolv.conf only has its stub 127.0.0.53
+	content, err := os.ReadFile("/run/systemd/resolve/resolv.conf")
+	if err != nil {
+		if content, err = os.ReadFile("/etc/resolv.conf"); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("read resolv.conf error: %v", err)
+		}
+	}
+
+	var lines []string
+	hasNameserver := false
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if ip := net.ParseIP(fields[1]); ip != nil && ip.IsLoopback() {
+				continue
+			}
+			hasNameserver = true
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	if !hasNameserver {
+		for _, dns := range defaultDNS {
+			lines = append(lines, "nameserver "+dns)
+		}
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
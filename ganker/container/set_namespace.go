@@ -0,0 +1,295 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"go_docker_learning/ganker/cgroup/subsystem"
+	"go_docker_learning/ganker/nsenter"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+const (
+	// CgroupNsPrivate runs the container in its own cgroup namespace, whose root is the cgroup of the container
+	CgroupNsPrivate = "private"
+	// CgroupNsHost shares the cgroup namespace of the host
+	CgroupNsHost = "host"
+
+	cgroupMountPoint = "/sys/fs/cgroup"
+
+	// NamespaceHost shares a namespace with the host
+	NamespaceHost = "host"
+	// namespaceContainerPrefix shares a namespace with another container, like "container:<id>"
+	namespaceContainerPrefix = "container:"
+)
+
+// sharableNamespaces are the namespaces that can be shared, with their clone flags and the allowed modes
+var sharableNamespaces = map[string]struct {
+	cloneFlag        uintptr
+	hostAllowed      bool
+	containerAllowed bool
+}{
+	"net": {syscall.CLONE_NEWNET, false, true},
+	"pid": {syscall.CLONE_NEWPID, true, true},
+	"ipc": {syscall.CLONE_NEWIPC, true, true},
+	"uts": {syscall.CLONE_NEWUTS, true, false},
+}
+
+// checkNamespaces checks the shared namespaces, like "net": "container:<id>" or "pid": "host",
+// it returns the pid of the container whose namespace is joined for each namespace shared with a container
+func checkNamespaces(namespaces map[string]string) (map[string]string, error) {
+	joins := map[string]string{}
+	for namespace, mode := range namespaces {
+		sharable, ok := sharableNamespaces[namespace]
+		if !ok {
+			return nil, fmt.Errorf("%s namespace can't be shared", namespace)
+		}
+		if Rootless {
+			return nil, fmt.Errorf("sharing the %s namespace isn't supported in rootless mode", namespace)
+		}
+
+		if mode == NamespaceHost && sharable.hostAllowed {
+			continue
+		}
+		containerId, ok := strings.CutPrefix(mode, namespaceContainerPrefix)
+		if !ok || !sharable.containerAllowed {
+			return nil, fmt.Errorf("invalid --%s %s", namespace, mode)
+		}
+		containerInfo, err := getContainerInfo(containerId)
+		if err != nil {
+			return nil, fmt.Errorf("get container %s info error: %v", containerId, err)
+		}
+		if containerInfo.Status != RUNNING && containerInfo.Status != PAUSED {
+			return nil, fmt.Errorf("container %s is not running, its %s namespace can't be joined", containerId, namespace)
+		}
+		joins[namespace] = containerInfo.Pid
+	}
+	return joins, nil
+}
+
+// startInNamespaces starts the init process in the namespaces of other containers.
+// the namespaces are joined by a thread which clones the process, the thread exits with the goroutine
+// as it's never unlocked, so the namespaces of the other threads are not changed
+func startInNamespaces(cmd *exec.Cmd, joins map[string]string) error {
+	if len(joins) == 0 {
+		return cmd.Start()
+	}
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		for namespace, pid := range joins {
+			if err := nsenter.Setns(pid, namespace); err != nil {
+				result <- err
+				return
+			}
+		}
+		result <- cmd.Start()
+	}()
+	return <-result
+}
+
+// getNamespaceUsers returns the containers which are running in the namespaces of the container
+func getNamespaceUsers(containerId string) []string {
+	files, err := os.ReadDir(ContainerRootPath)
+	if err != nil {
+		return nil
+	}
+	var users []string
+	for _, file := range files {
+		if file.Name() == containerId {
+			continue
+		}
+		containerInfo, err := getContainerInfo(file.Name())
+		if err != nil || containerInfo.Status == EXIT {
+			continue
+		}
+		for _, mode := range containerInfo.Namespaces {
+			if mode == namespaceContainerPrefix+containerId {
+				users = append(users, containerInfo.ContainerId)
+				break
+			}
+		}
+	}
+	return users
+}
+
+// TimeOffset shifts a clock in the time namespace of the container
+type TimeOffset struct {
+	Clock    string `json:"clock"` // monotonic or boottime
+	Secs     int64  `json:"secs"`
+	Nanosecs int64  `json:"nanosecs"` // always in range [0, 999999999], a negative offset has a negative Secs
+}
+
+// checkCgroupNs returns the cgroup namespace mode, it is private by default on cgroup v2 and host on cgroup v1,
+// the same as docker
+func checkCgroupNs(cgroupNs string) (string, error) {
+	switch cgroupNs {
+	case "":
+		if subsystem.IsCgroup2() {
+			return CgroupNsPrivate, nil
+		}
+		return CgroupNsHost, nil
+	case CgroupNsPrivate, CgroupNsHost:
+		return cgroupNs, nil
+	}
+	return "", fmt.Errorf("invalid cgroupns %s, it should be %s or %s", cgroupNs, CgroupNsPrivate, CgroupNsHost)
+}
+
+// ParseTimeOffsets parses offsets like "monotonic=3600", "boottime=-1.5" or "monotonic=24h",
+// a number without unit is in seconds
+func ParseTimeOffsets(offsets []string) ([]TimeOffset, error) {
+	var result []TimeOffset
+	for _, offset := range offsets {
+		clock, value, ok := strings.Cut(offset, "=")
+		if !ok || (clock != "monotonic" && clock != "boottime") {
+			return nil, fmt.Errorf("invalid time offset %s, it should be like monotonic=<offset> or boottime=<offset>", offset)
+		}
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			value += "s"
+		}
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time offset %s: %v", offset, err)
+		}
+		secs := int64(duration / time.Second)
+		nanosecs := int64(duration % time.Second)
+		if nanosecs < 0 {
+			secs--
+			nanosecs += int64(time.Second)
+		}
+		result = append(result, TimeOffset{Clock: clock, Secs: secs, Nanosecs: nanosecs})
+	}
+	return result, nil
+}
+
+// setupNamespaces unshares the namespaces init can't get by clone: the cgroup namespace must be created
+// after the parent has moved init into the cgroup of the container, and the offsets of a time namespace
+// can only be written before any process enters it, which happens when init executes the command.
+// unshare only changes the calling thread, init is locked on the main thread
+func setupNamespaces(initMessage *InitMessage) error {
+	if initMessage.CgroupNs {
+		if err := syscall.Unshare(syscall.CLONE_NEWCGROUP); err != nil {
+			return fmt.Errorf("unshare cgroup namespace error: %v", err)
+		}
+	}
+
+	if len(initMessage.TimeOffsets) != 0 {
+		if err := syscall.Unshare(syscall.CLONE_NEWTIME); err != nil {
+			return fmt.Errorf("unshare time namespace error: %v", err)
+		}
+		// the offsets are of the time namespace for the children of the main thread
+		var content strings.Builder
+		for _, offset := range initMessage.TimeOffsets {
+			fmt.Fprintf(&content, "%s %d %d\n", offset.Clock, offset.Secs, offset.Nanosecs)
+		}
+		if err := os.WriteFile("/proc/self/timens_offsets", []byte(content.String()), 0644); err != nil {
+			return fmt.Errorf("write time offsets error: %v", err)
+		}
+	}
+	return nil
+}
+
+// mountSysfs mounts /sys read only, and the cgroup hierarchies on /sys/fs/cgroup read only if the container
+// has its own cgroup namespace, the hierarchies are laid out the same as the host
+func mountSysfs(rootfs string, cgroupNs bool) error {
+	sysPath := filepath.Join(rootfs, "sys")
+	if err := os.MkdirAll(sysPath, 0755); err != nil {
+		return fmt.Errorf("create /sys error: %v", err)
+	}
+	flags := uintptr(syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NOEXEC | syscall.MS_NODEV)
+	if err := syscall.Mount("sysfs", sysPath, "sysfs", flags, ""); err != nil {
+		return fmt.Errorf("mount sysfs error: %v", err)
+	}
+	if !cgroupNs {
+		return nil
+	}
+
+	hierarchies, err := getCgroupHierarchies()
+	if err != nil {
+		return err
+	}
+	cgroupPath := filepath.Join(rootfs, cgroupMountPoint)
+	// the v1 hierarchies are mounted in sub dirs of a tmpfs
+	if len(hierarchies) != 0 && hierarchies[0].dir != "" {
+		if err := syscall.Mount("tmpfs", cgroupPath, "tmpfs", flags&^syscall.MS_RDONLY, "mode=755"); err != nil {
+			return fmt.Errorf("mount tmpfs on %s error: %v", cgroupMountPoint, err)
+		}
+	}
+	for _, h := range hierarchies {
+		target := filepath.Join(cgroupPath, h.dir)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("create %s error: %v", target, err)
+		}
+		if err := syscall.Mount("cgroup", target, h.fsType, flags, h.options); err != nil {
+			return fmt.Errorf("mount cgroup on %s error: %v", filepath.Join(cgroupMountPoint, h.dir), err)
+		}
+	}
+	if len(hierarchies) != 0 && hierarchies[0].dir != "" {
+		if err := syscall.Mount("", cgroupPath, "", flags|syscall.MS_REMOUNT, "mode=755"); err != nil {
+			return fmt.Errorf("remount %s read only error: %v", cgroupMountPoint, err)
+		}
+	}
+	return nil
+}
+
+// cgroupHierarchy is a cgroup mount under /sys/fs/cgroup of the host
+type cgroupHierarchy struct {
+	dir     string // relative to /sys/fs/cgroup, it's empty for the unified hierarchy mounted on /sys/fs/cgroup
+	fsType  string // cgroup or cgroup2
+	options string // the controllers of a v1 hierarchy, like "cpu,cpuacct" or "name=systemd"
+}
+
+// getCgroupHierarchies reads the cgroup mounts of the host from mountinfo, which is still the one of the host
+// as init has not pivoted root yet. the unified hierarchy on /sys/fs/cgroup is listed first
+func getCgroupHierarchies() ([]cgroupHierarchy, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hierarchies []cgroupHierarchy
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), " ")
+		for i := 6; i < len(fields)-3; i++ {
+			if fields[i] != "-" {
+				continue
+			}
+			fsType := fields[i+1]
+			if fsType != "cgroup" && fsType != "cgroup2" {
+				break
+			}
+			dir, err := filepath.Rel(cgroupMountPoint, fields[4])
+			if err != nil || strings.HasPrefix(dir, "..") || seen[dir] {
+				break
+			}
+			seen[dir] = true
+			// the options of cgroup2, like nsdelegate, are global and can't be given again
+			var options []string
+			for _, opt := range strings.Split(fields[i+3], ",") {
+				if fsType == "cgroup" && opt != "rw" && opt != "ro" {
+					options = append(options, opt)
+				}
+			}
+			h := cgroupHierarchy{dir: dir, fsType: fsType, options: strings.Join(options, ",")}
+			if dir == "." {
+				h.dir = ""
+				hierarchies = append([]cgroupHierarchy{h}, hierarchies...)
+			} else {
+				hierarchies = append(hierarchies, h)
+			}
+			break
+		}
+	}
+	return hierarchies, scanner.Err()
+}
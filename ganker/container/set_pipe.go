@@ -2,13 +2,62 @@ package container
 
 import (
 	"fmt"
-	"io"
 	"os"
-	"strings"
 
-	"github.com/sirupsen/logrus"
+	json "github.com/goccy/go-json"
+
+	syscall "golang.org/x/sys/unix"
 )
 
+// initMessageVersion must be increased when InitMessage is changed incompatibly
+const initMessageVersion = 1
+
+// InitMessage is sent by the parent to the init process through the pipe,
+// it carries everything init needs to set up the container before exec
+type InitMessage struct {
+	Version    int      `json:"version"`    // version of the protocol, init refuses a message of another version
+	Args       []string `json:"args"`       // the command and its arguments
+	Env        []string `json:"env"`        // environment of the command, like "KEY=value"
+	Cwd        string   `json:"cwd"`        // working directory in the container
+	User       string   `json:"user"`       // user[:group] the command runs as, names or ids of the container, root if empty
+	Hostname   string   `json:"hostname"`   // hostname in the new uts namespace
+	Domainname string   `json:"domainname"` // NIS domain name in the new uts namespace
+	Rlimits    []Rlimit `json:"rlimits"`    // resource limits of the command
+	Mounts     []Mount  `json:"mounts"`     // extra mounts in the container
+	Rootfs     *Mount   `json:"rootfs"`     // mounted on the working dir first if it's not nil, rootless containers mount the overlay here
+	Devices    []Device `json:"devices"`    // devices created in /dev besides the default ones
+	ShmSize    uint64   `json:"shm size"`   // size of /dev/shm in bytes
+
+	ReadonlyRootfs bool     `json:"readonly rootfs"` // remount the rootfs read only after pivot_root
+	MaskedPaths    []string `json:"masked paths"`    // paths hidden in the container
+	ReadonlyPaths  []string `json:"readonly paths"`  // paths made read only in the container
+
+	Capabilities    []string             `json:"capabilities"`      // the capabilities kept by the command, all others are dropped
+	Seccomp         []syscall.SockFilter `json:"seccomp"`           // the seccomp filter of the command, the syscalls are not filtered if it's empty
+	NoNewPrivileges bool                 `json:"no new privileges"` // set no_new_privs, so the command can't gain privileges by execve
+
+	CgroupNs    bool         `json:"cgroupns"`     // unshare the cgroup namespace after init is moved into the cgroup
+	TimeOffsets []TimeOffset `json:"time offsets"` // unshare the time namespace with the clock offsets if it's not empty
+
+	Sysctls map[string]string `json:"sysctls"` // namespaced sysctls written into /proc/sys, like "net.core.somaxconn": "1024"
+}
+
+// Rlimit is a resource limit set by setrlimit
+type Rlimit struct {
+	Type int    `json:"type"` // RLIMIT_* resource
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// Mount is mounted on Destination in the rootfs before pivot_root, so Source can be a path on the host
+type Mount struct {
+	Source      string  `json:"source"`
+	Destination string  `json:"destination"` // absolute path in the container
+	Type        string  `json:"type"`
+	Flags       uintptr `json:"flags"`
+	Data        string  `json:"data"`
+}
+
 // newPipe create a pipe
 func newPipe() (*os.File, *os.File, error) {
 	// create a pipe
@@ -19,25 +68,27 @@ func newPipe() (*os.File, *os.File, error) {
 	return readPipe, writePipe, nil
 }
 
-// sendInitCommand send command to child process
-func sendInitCommand(comArray []string, writePipe *os.File) error {
-	command := strings.Join(comArray, " ")
-	if _, err := writePipe.WriteString(command); err != nil {
+// sendInitCommand send the init message to child process
+func sendInitCommand(initMessage *InitMessage, writePipe *os.File) error {
+	defer writePipe.Close()
+	initMessage.Version = initMessageVersion
+	if err := json.NewEncoder(writePipe).Encode(initMessage); err != nil {
 		return fmt.Errorf("write pipe error %v", err)
 	}
-	writePipe.Close()
 	return nil
 }
 
-// readParentProcess read command from pipe
-func readParentProcess() []string {
-
+// readParentProcess read the init message from pipe
+func readParentProcess() (*InitMessage, error) {
 	pipe := os.NewFile(uintptr(3), "pipe")
-	msg, err := io.ReadAll(pipe)
-	if err != nil {
-		logrus.Errorf("init read pipe error %v", err)
-		return nil
+	defer pipe.Close()
+
+	initMessage := &InitMessage{}
+	if err := json.NewDecoder(pipe).Decode(initMessage); err != nil {
+		return nil, fmt.Errorf("init read pipe error %v", err)
+	}
+	if initMessage.Version != initMessageVersion {
+		return nil, fmt.Errorf("init message version %d is not supported, expect %d", initMessage.Version, initMessageVersion)
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	return initMessage, nil
 }
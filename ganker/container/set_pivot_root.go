@@ -10,7 +10,8 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func MountSet() error {
+// MountSet sets up the mounts of the container and pivots into its rootfs
+func MountSet(initMessage *InitMessage) error {
 
 	//set "/" as a private mount namespace's mount point
 	//as pivot is prohibited if parent mount is shared
@@ -28,27 +29,104 @@ func MountSet() error {
 		return err
 	}
 
-	// mount rootfs to the current dir
-	if err := pivotRoot(pwd); err != nil {
+	// the working dir is still the dir under the new mount, change into the mount
+	if m := initMessage.Rootfs; m != nil {
+		if err := syscall.Mount(m.Source, pwd, m.Type, m.Flags, m.Data); err != nil {
+			return fmt.Errorf("mount rootfs error: %v", err)
+		}
+		if err := syscall.Chdir(pwd); err != nil {
+			return fmt.Errorf("chdir rootfs error: %v", err)
+		}
+	}
+
+	// populate /dev, the devices of the host may be bind mounted
+	if err := setupDev(pwd, initMessage.Devices, initMessage.ShmSize); err != nil {
+		return err
+	}
+
+	// mount the extra mounts while the host paths are still reachable
+	if err := setupMounts(pwd, initMessage.Mounts); err != nil {
+		return err
+	}
+
+	// mount /sys, and /sys/fs/cgroup in a private cgroup namespace
+	if err := mountSysfs(pwd, initMessage.CgroupNs); err != nil {
 		return err
 	}
 
 	procMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
 
-	// mount /proc to /proc
-	if err := syscall.Mount("", "/proc", "proc", uintptr(procMountFlags), ""); err != nil {
+	// mount /proc before pivot_root, in a user namespace the kernel only allows it
+	// while the proc of the host is still mounted in the mount namespace
+	if err := syscall.Mount("proc", filepath.Join(pwd, "proc"), "proc", uintptr(procMountFlags), ""); err != nil {
 		logrus.Errorf("mount proc error %v", err)
 		return err
 	}
 
-	tmpfsMountFlags := syscall.MS_NOSUID | syscall.MS_STRICTATIME
+	// the sysctls are written in the namespaces of the container before /proc/sys is made read only
+	if err := setupSysctls(pwd, initMessage.Sysctls); err != nil {
+		return err
+	}
 
-	// mount /tmpfs to /sys
-	if err := syscall.Mount("tmpfs", "dev", "tmpfs", uintptr(tmpfsMountFlags), "mode=755"); err != nil {
-		logrus.Errorf("mount tmpfs error %v", err)
+	// hide the sensitive files of /proc and /sys
+	if err := setupSystemPaths(pwd, initMessage.ReadonlyPaths, initMessage.MaskedPaths); err != nil {
 		return err
 	}
 
+	// mount rootfs to the current dir
+	if err := pivotRoot(pwd); err != nil {
+		return err
+	}
+
+	// only the rootfs itself is read only, the mounts on it, like the volume and /tmp, are still writable
+	if initMessage.ReadonlyRootfs {
+		if err := remountReadOnly("/"); err != nil {
+			return fmt.Errorf("remount rootfs read only error: %v", err)
+		}
+	}
+	return nil
+}
+
+// setupMounts mounts each mount on its destination in rootfs, the mount point is created if it doesn't exist
+func setupMounts(rootfs string, mounts []Mount) error {
+	for _, m := range mounts {
+		dest := filepath.Join(rootfs, m.Destination)
+		if err := createMountPoint(m, dest); err != nil {
+			return err
+		}
+		if err := syscall.Mount(m.Source, dest, m.Type, m.Flags, m.Data); err != nil {
+			return fmt.Errorf("mount %s to %s error: %v", m.Source, m.Destination, err)
+		}
+		// the read only flag is ignored when a bind mount is created, it must be set by a remount
+		if m.Flags&syscall.MS_BIND != 0 && m.Flags&syscall.MS_RDONLY != 0 {
+			if err := syscall.Mount("", dest, "", m.Flags|syscall.MS_REMOUNT, ""); err != nil {
+				return fmt.Errorf("remount %s read only error: %v", m.Destination, err)
+			}
+		}
+	}
+	return nil
+}
+
+// createMountPoint creates dest as a file if a file is bind mounted on it, otherwise as a dir
+func createMountPoint(m Mount, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+	if m.Flags&syscall.MS_BIND != 0 {
+		if stat, err := os.Stat(m.Source); err == nil && !stat.IsDir() {
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return fmt.Errorf("create mount point %s error: %v", m.Destination, err)
+			}
+			file, err := os.OpenFile(dest, os.O_CREATE, 0644)
+			if err != nil {
+				return fmt.Errorf("create mount point %s error: %v", m.Destination, err)
+			}
+			return file.Close()
+		}
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return fmt.Errorf("create mount point %s error: %v", m.Destination, err)
+	}
 	return nil
 }
 
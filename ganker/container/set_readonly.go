@@ -0,0 +1,101 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// DefaultMaskedPaths are hidden in the container, a file is covered by /dev/null and a dir by an empty read only tmpfs
+var DefaultMaskedPaths = []string{
+	"/proc/asound",
+	"/proc/acpi",
+	"/proc/kcore",
+	"/proc/keys",
+	"/proc/latency_stats",
+	"/proc/timer_list",
+	"/proc/timer_stats",
+	"/proc/sched_debug",
+	"/proc/scsi",
+	"/sys/firmware",
+	"/sys/devices/virtual/powercap",
+}
+
+// DefaultReadonlyPaths are read only in the container
+var DefaultReadonlyPaths = []string{
+	"/proc/bus",
+	"/proc/fs",
+	"/proc/irq",
+	"/proc/sys",
+	"/proc/sysrq-trigger",
+}
+
+// readOnlyTmpfs are the writable dirs of a container with a read only rootfs
+var readOnlyTmpfs = []Mount{
+	{Source: "tmpfs", Destination: "/tmp", Type: "tmpfs", Flags: syscall.MS_NOSUID | syscall.MS_NODEV, Data: "mode=1777"},
+	{Source: "tmpfs", Destination: "/run", Type: "tmpfs", Flags: syscall.MS_NOSUID | syscall.MS_NODEV, Data: "mode=755"},
+}
+
+// setupSystemPaths makes the paths in the rootfs read only, then masks the masked paths, the paths that don't exist are skipped
+func setupSystemPaths(rootfs string, readonlyPaths, maskedPaths []string) error {
+	for _, path := range readonlyPaths {
+		dest := filepath.Join(rootfs, path)
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			continue
+		}
+		if err := syscall.Mount(dest, dest, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind mount %s error: %v", path, err)
+		}
+		if err := remountReadOnly(dest); err != nil {
+			return fmt.Errorf("remount %s read only error: %v", path, err)
+		}
+	}
+
+	for _, path := range maskedPaths {
+		dest := filepath.Join(rootfs, path)
+		stat, err := os.Stat(dest)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("stat masked path %s error: %v", path, err)
+		}
+		if stat.IsDir() {
+			err = syscall.Mount("tmpfs", dest, "tmpfs", syscall.MS_RDONLY, "")
+		} else {
+			err = syscall.Mount("/dev/null", dest, "", syscall.MS_BIND, "")
+		}
+		// a file of /proc may be gone after it's checked
+		if err != nil && !errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("mask %s error: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// remountReadOnly remounts the mount on path read only. in a user namespace the flags like nosuid of a mount
+// from the host are locked and must be kept by the remount, so they are read from statfs
+func remountReadOnly(path string) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	statFlags := map[int64]uintptr{
+		syscall.ST_NOSUID:     syscall.MS_NOSUID,
+		syscall.ST_NODEV:      syscall.MS_NODEV,
+		syscall.ST_NOEXEC:     syscall.MS_NOEXEC,
+		syscall.ST_NOATIME:    syscall.MS_NOATIME,
+		syscall.ST_NODIRATIME: syscall.MS_NODIRATIME,
+		syscall.ST_RELATIME:   syscall.MS_RELATIME,
+	}
+	for statFlag, mountFlag := range statFlags {
+		if stat.Flags&statFlag != 0 {
+			flags |= mountFlag
+		}
+	}
+	return syscall.Mount("", path, "", flags, "")
+}
@@ -0,0 +1,87 @@
+package container
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// rlimitTypes are the names of the resource limits given by --ulimit, the same as docker
+var rlimitTypes = map[string]int{
+	"as":         syscall.RLIMIT_AS,
+	"core":       syscall.RLIMIT_CORE,
+	"cpu":        syscall.RLIMIT_CPU,
+	"data":       syscall.RLIMIT_DATA,
+	"fsize":      syscall.RLIMIT_FSIZE,
+	"locks":      syscall.RLIMIT_LOCKS,
+	"memlock":    syscall.RLIMIT_MEMLOCK,
+	"msgqueue":   syscall.RLIMIT_MSGQUEUE,
+	"nice":       syscall.RLIMIT_NICE,
+	"nofile":     syscall.RLIMIT_NOFILE,
+	"nproc":      syscall.RLIMIT_NPROC,
+	"rss":        syscall.RLIMIT_RSS,
+	"rtprio":     syscall.RLIMIT_RTPRIO,
+	"rttime":     syscall.RLIMIT_RTTIME,
+	"sigpending": syscall.RLIMIT_SIGPENDING,
+	"stack":      syscall.RLIMIT_STACK,
+}
+
+// ParseUlimits parses the limits like "nofile=1024:2048", "nofile=1024" or "core=unlimited",
+// the hard limit is the same as the soft one if it's not given, a later limit of the same resource replaces the former
+func ParseUlimits(ulimits []string) ([]Rlimit, error) {
+	limits := map[int]Rlimit{}
+	for _, ulimit := range ulimits {
+		name, value, ok := strings.Cut(ulimit, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid ulimit %s, it should be like <type>=<soft>[:<hard>]", ulimit)
+		}
+		resource, ok := rlimitTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid ulimit type %s", name)
+		}
+		softStr, hardStr, hasHard := strings.Cut(value, ":")
+		if !hasHard {
+			hardStr = softStr
+		}
+		soft, err := parseRlimitValue(softStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ulimit %s: %v", ulimit, err)
+		}
+		hard, err := parseRlimitValue(hardStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ulimit %s: %v", ulimit, err)
+		}
+		if soft > hard {
+			return nil, fmt.Errorf("invalid ulimit %s, the soft limit is greater than the hard limit", ulimit)
+		}
+		limits[resource] = Rlimit{Type: resource, Soft: soft, Hard: hard}
+	}
+
+	result := make([]Rlimit, 0, len(limits))
+	for _, limit := range limits {
+		result = append(result, limit)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Type < result[j].Type })
+	return result, nil
+}
+
+// parseRlimitValue parses a limit, "unlimited" and -1 are RLIM_INFINITY
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "unlimited" || value == "-1" {
+		return syscall.RLIM_INFINITY, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// rlimitName returns the name of a resource limit for the errors, like "nofile"
+func rlimitName(resource int) string {
+	for name, r := range rlimitTypes {
+		if r == resource {
+			return name
+		}
+	}
+	return strconv.Itoa(resource)
+}
@@ -0,0 +1,528 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	json "github.com/goccy/go-json"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+const (
+	// SeccompDefault filters the syscalls by the built-in profile
+	SeccompDefault = "default"
+	// SeccompUnconfined doesn't filter the syscalls
+	SeccompUnconfined = "unconfined"
+
+	// SeccompFileName is the profile of the container kept for ganker exec
+	SeccompFileName = "seccomp.json"
+)
+
+// the return values of a seccomp filter, the low 16 bits of SECCOMP_RET_ERRNO and SECCOMP_RET_TRACE carry the data
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetKillThread  = 0x00000000
+	seccompRetTrap        = 0x00030000
+	seccompRetErrno       = 0x00050000
+	seccompRetTrace       = 0x7ff00000
+	seccompRetLog         = 0x7ffc0000
+	seccompRetAllow       = 0x7fff0000
+)
+
+// the offsets in struct seccomp_data { int nr; __u32 arch; __u64 instruction_pointer; __u64 args[6]; }
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+	seccompDataArgs = 16
+)
+
+// SeccompProfile is a seccomp profile in the format of docker, which is also used by the OCI runtime spec
+type SeccompProfile struct {
+	DefaultAction   string           `json:"defaultAction"`
+	DefaultErrnoRet *uint            `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string         `json:"architectures,omitempty"`
+	ArchMap         []SeccompArchMap `json:"archMap,omitempty"`
+	Syscalls        []SeccompSyscall `json:"syscalls"`
+}
+
+// SeccompArchMap lists the sub architectures of an architecture
+type SeccompArchMap struct {
+	Architecture     string   `json:"architecture"`
+	SubArchitectures []string `json:"subArchitectures"`
+}
+
+// SeccompSyscall is the action of the syscalls whose arguments match all Args
+type SeccompSyscall struct {
+	Name     string        `json:"name,omitempty"` // a single syscall, used by the old profiles
+	Names    []string      `json:"names,omitempty"`
+	Action   string        `json:"action"`
+	ErrnoRet *uint         `json:"errnoRet,omitempty"`
+	Args     []SeccompArg  `json:"args,omitempty"`
+	Includes SeccompFilter `json:"includes,omitempty"`
+	Excludes SeccompFilter `json:"excludes,omitempty"`
+}
+
+// SeccompArg compares an argument of the syscall, like "args[Index] Op Value"
+type SeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo,omitempty"` // the expected value of SCMP_CMP_MASKED_EQ, Value is the mask
+	Op       string `json:"op"`
+}
+
+// SeccompFilter applies a rule only to the containers with all capabilities, on one of the architectures
+// and on a kernel at least MinKernel, like "4.8"
+type SeccompFilter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// SecurityOptions are given by --security-opt
+type SecurityOptions struct {
+	Seccomp         string          // default, unconfined or the path of a profile, it is empty if it's not given
+	SeccompProfile  *SeccompProfile // the profile loaded from the path
+	NoNewPrivileges bool            // true unless no-new-privileges=false is given
+}
+
+// ParseSecurityOpts parses the options like "seccomp=unconfined", "seccomp=/path/to/profile.json"
+// or "no-new-privileges=false", no-new-privileges alone is the same as no-new-privileges=true
+func ParseSecurityOpts(opts []string) (*SecurityOptions, error) {
+	security := &SecurityOptions{NoNewPrivileges: true}
+	for _, opt := range opts {
+		if opt == "no-new-privileges" {
+			security.NoNewPrivileges = true
+			continue
+		}
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid security option %s, it should be like <key>=<value>", opt)
+		}
+		switch key {
+		case "seccomp":
+			security.Seccomp = value
+			if value == SeccompDefault || value == SeccompUnconfined {
+				continue
+			}
+			profile, err := loadSeccompProfile(value)
+			if err != nil {
+				return nil, err
+			}
+			security.SeccompProfile = profile
+		case "no-new-privileges":
+			noNewPrivileges, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid security option %s, the value should be true or false", opt)
+			}
+			security.NoNewPrivileges = noNewPrivileges
+		default:
+			return nil, fmt.Errorf("unknown security option %s", key)
+		}
+	}
+	return security, nil
+}
+
+// loadSeccompProfile reads a profile, the rules are checked by compiling them with all capabilities
+func loadSeccompProfile(path string) (*SeccompProfile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read seccomp profile error: %v", err)
+	}
+	profile := &SeccompProfile{}
+	if err := json.Unmarshal(content, profile); err != nil {
+		return nil, fmt.Errorf("parse seccomp profile %s error: %v", path, err)
+	}
+	if _, err := compileSeccomp(profile, allCapabilities()); err != nil {
+		return nil, fmt.Errorf("invalid seccomp profile %s: %v", path, err)
+	}
+	return profile, nil
+}
+
+// resolveSeccomp returns the profile of a container, it is nil if the syscalls are not filtered.
+// a privileged container is unconfined unless a profile is given
+func resolveSeccomp(security *SecurityOptions, privileged bool) (*SeccompProfile, string) {
+	switch {
+	case security.SeccompProfile != nil:
+		return security.SeccompProfile, security.Seccomp
+	case security.Seccomp == SeccompUnconfined, security.Seccomp == "" && privileged:
+		return nil, SeccompUnconfined
+	}
+	return defaultSeccompProfile(), SeccompDefault
+}
+
+// recordSeccompProfile keeps the profile of the container, so that the commands of ganker exec are filtered by it too
+func recordSeccompProfile(containerId string, profile *SeccompProfile) error {
+	content, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(ContainerRootPath+containerId, SeccompFileName), content, 0644); err != nil {
+		return fmt.Errorf("write seccomp profile error: %v", err)
+	}
+	return nil
+}
+
+// getSeccompProfile reads the profile of the container, it is nil if the container is unconfined
+func getSeccompProfile(containerId string) (*SeccompProfile, error) {
+	content, err := os.ReadFile(filepath.Join(ContainerRootPath+containerId, SeccompFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read seccomp profile error: %v", err)
+	}
+	profile := &SeccompProfile{}
+	if err := json.Unmarshal(content, profile); err != nil {
+		return nil, fmt.Errorf("parse seccomp profile error: %v", err)
+	}
+	return profile, nil
+}
+
+// seccompAction converts an action of the profile to the return value of the filter
+func seccompAction(action string, errnoRet *uint) (uint32, error) {
+	ret := uint32(syscall.EPERM)
+	if errnoRet != nil {
+		ret = uint32(*errnoRet)
+	}
+	switch action {
+	case "SCMP_ACT_ALLOW":
+		return seccompRetAllow, nil
+	case "SCMP_ACT_ERRNO":
+		return seccompRetErrno | ret&0xffff, nil
+	case "SCMP_ACT_LOG":
+		return seccompRetLog, nil
+	case "SCMP_ACT_TRACE":
+		return seccompRetTrace | ret&0xffff, nil
+	case "SCMP_ACT_TRAP":
+		return seccompRetTrap, nil
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return seccompRetKillThread, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return seccompRetKillProcess, nil
+	}
+	return 0, fmt.Errorf("unsupported seccomp action %s", action)
+}
+
+// seccompRule is a compiled rule of a syscall
+type seccompRule struct {
+	args   []SeccompArg
+	action uint32
+}
+
+// applies checks the includes and excludes of a rule against the capabilities of the container and the host
+func (s *SeccompSyscall) applies(caps []string) bool {
+	arch := seccompNativeArchName
+	if len(s.Includes.Arches) != 0 && !containsArch(s.Includes.Arches, arch) {
+		return false
+	}
+	for _, c := range s.Includes.Caps {
+		if !contains(caps, c) {
+			return false
+		}
+	}
+	if s.Includes.MinKernel != "" && !kernelAtLeastVersion(s.Includes.MinKernel) {
+		return false
+	}
+	if containsArch(s.Excludes.Arches, arch) {
+		return false
+	}
+	for _, c := range s.Excludes.Caps {
+		if contains(caps, c) {
+			return false
+		}
+	}
+	if s.Excludes.MinKernel != "" && kernelAtLeastVersion(s.Excludes.MinKernel) {
+		return false
+	}
+	return true
+}
+
+// containsArch checks the arch in the arches of a profile, which are like "amd64" or "SCMP_ARCH_X86_64"
+func containsArch(arches []string, arch string) bool {
+	aliases := map[string]string{"amd64": "SCMP_ARCH_X86_64", "arm64": "SCMP_ARCH_AARCH64"}
+	for _, a := range arches {
+		if a == arch || aliases[a] == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// kernelAtLeastVersion checks the kernel against a version like "4.8"
+func kernelAtLeastVersion(version string) bool {
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		return false
+	}
+	return kernelAtLeast(major, minor)
+}
+
+// compileSeccomp compiles the profile into a classic BPF program for the container with the capabilities.
+// the syscalls unknown to the native arch are ignored, and the syscalls of the other archs, like the 32 bit ones,
+// kill the process, as the rules only know the native syscall numbers
+func compileSeccomp(profile *SeccompProfile, caps []string) ([]syscall.SockFilter, error) {
+	if seccompNativeArch == 0 {
+		return nil, fmt.Errorf("seccomp isn't supported on this architecture")
+	}
+	defaultAction, err := seccompAction(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := map[int][]seccompRule{}
+	for i := range profile.Syscalls {
+		s := &profile.Syscalls[i]
+		action, err := seccompAction(s.Action, s.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		for _, arg := range s.Args {
+			if arg.Index > 5 {
+				return nil, fmt.Errorf("invalid index %d of syscall argument", arg.Index)
+			}
+			if _, ok := seccompCompare[arg.Op]; !ok {
+				return nil, fmt.Errorf("unsupported seccomp operator %s", arg.Op)
+			}
+		}
+		if !s.applies(caps) {
+			continue
+		}
+		names := s.Names
+		if s.Name != "" {
+			names = append(names, s.Name)
+		}
+		for _, name := range names {
+			nr, ok := seccompSyscalls[name]
+			if !ok {
+				continue
+			}
+			rules[nr] = append(rules[nr], seccompRule{args: s.Args, action: action})
+		}
+	}
+
+	p := &bpfProgram{}
+	p.stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch)
+	p.jump(syscall.BPF_JEQ|syscall.BPF_K, seccompNativeArch, "native", "")
+	p.stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess)
+	p.label("native")
+	p.stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr)
+	if seccompX32SyscallBit != 0 {
+		p.jump(syscall.BPF_JGE|syscall.BPF_K, seccompX32SyscallBit, "", "dispatch")
+		p.stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess)
+		p.label("dispatch")
+	}
+
+	// every syscall jumps to its rules, which are laid out after the dispatch as they may be too far for a conditional jump
+	var nrs []int
+	for nr := range rules {
+		nrs = append(nrs, nr)
+	}
+	sort.Ints(nrs)
+	for _, nr := range nrs {
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, uint32(nr), "", "next")
+		p.ja(fmt.Sprintf("syscall%d", nr))
+		p.label("next")
+	}
+	p.stmt(syscall.BPF_RET|syscall.BPF_K, defaultAction)
+
+	for _, nr := range nrs {
+		p.label(fmt.Sprintf("syscall%d", nr))
+		for _, rule := range rules[nr] {
+			for _, arg := range rule.args {
+				seccompCompare[arg.Op](p, arg)
+			}
+			p.stmt(syscall.BPF_RET|syscall.BPF_K, rule.action)
+			p.label("fail")
+		}
+		p.stmt(syscall.BPF_RET|syscall.BPF_K, defaultAction)
+	}
+	return p.assemble()
+}
+
+// seccompCompare emits the comparisons of a 64 bit argument, which are done on its high and low 32 bits.
+// a comparison falls through if it matches, otherwise it jumps to the "fail" label, which is the next rule
+var seccompCompare = map[string]func(p *bpfProgram, arg SeccompArg){
+	"SCMP_CMP_EQ": func(p *bpfProgram, arg SeccompArg) {
+		hi, lo := splitArg(arg.Value)
+		p.loadArg(arg.Index, true)
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, hi, "", "fail")
+		p.loadArg(arg.Index, false)
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, lo, "", "fail")
+	},
+	"SCMP_CMP_NE": func(p *bpfProgram, arg SeccompArg) {
+		hi, lo := splitArg(arg.Value)
+		p.loadArg(arg.Index, true)
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, hi, "", "match")
+		p.loadArg(arg.Index, false)
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, lo, "fail", "")
+		p.label("match")
+	},
+	"SCMP_CMP_GT": func(p *bpfProgram, arg SeccompArg) {
+		hi, lo := splitArg(arg.Value)
+		p.loadArg(arg.Index, true)
+		p.jump(syscall.BPF_JGT|syscall.BPF_K, hi, "match", "")
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, hi, "", "fail")
+		p.loadArg(arg.Index, false)
+		p.jump(syscall.BPF_JGT|syscall.BPF_K, lo, "", "fail")
+		p.label("match")
+	},
+	"SCMP_CMP_GE": func(p *bpfProgram, arg SeccompArg) {
+		hi, lo := splitArg(arg.Value)
+		p.loadArg(arg.Index, true)
+		p.jump(syscall.BPF_JGT|syscall.BPF_K, hi, "match", "")
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, hi, "", "fail")
+		p.loadArg(arg.Index, false)
+		p.jump(syscall.BPF_JGE|syscall.BPF_K, lo, "", "fail")
+		p.label("match")
+	},
+	"SCMP_CMP_LT": func(p *bpfProgram, arg SeccompArg) {
+		hi, lo := splitArg(arg.Value)
+		p.loadArg(arg.Index, true)
+		p.jump(syscall.BPF_JGE|syscall.BPF_K, hi, "", "match")
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, hi, "", "fail")
+		p.loadArg(arg.Index, false)
+		p.jump(syscall.BPF_JGE|syscall.BPF_K, lo, "fail", "")
+		p.label("match")
+	},
+	"SCMP_CMP_LE": func(p *bpfProgram, arg SeccompArg) {
+		hi, lo := splitArg(arg.Value)
+		p.loadArg(arg.Index, true)
+		p.jump(syscall.BPF_JGE|syscall.BPF_K, hi, "", "match")
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, hi, "", "fail")
+		p.loadArg(arg.Index, false)
+		p.jump(syscall.BPF_JGT|syscall.BPF_K, lo, "fail", "")
+		p.label("match")
+	},
+	"SCMP_CMP_MASKED_EQ": func(p *bpfProgram, arg SeccompArg) {
+		maskHi, maskLo := splitArg(arg.Value)
+		hi, lo := splitArg(arg.ValueTwo)
+		p.loadArg(arg.Index, true)
+		p.stmt(syscall.BPF_ALU|syscall.BPF_AND|syscall.BPF_K, maskHi)
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, hi, "", "fail")
+		p.loadArg(arg.Index, false)
+		p.stmt(syscall.BPF_ALU|syscall.BPF_AND|syscall.BPF_K, maskLo)
+		p.jump(syscall.BPF_JEQ|syscall.BPF_K, lo, "", "fail")
+	},
+}
+
+func splitArg(value uint64) (uint32, uint32) {
+	return uint32(value >> 32), uint32(value)
+}
+
+// bpfProgram assembles a classic BPF program whose jumps go to labels.
+// a jump goes to the first definition of the label after it, so a label like "fail" can be defined many times
+type bpfProgram struct {
+	insns  []syscall.SockFilter
+	jumps  map[int][2]string // the labels of the true and false branches of the conditional jumps, "" falls through
+	gotos  map[int]string    // the labels of the unconditional jumps
+	labels map[string][]int  // the positions of the labels, in order
+}
+
+func (p *bpfProgram) stmt(code uint16, k uint32) {
+	p.insns = append(p.insns, syscall.SockFilter{Code: code, K: k})
+}
+
+func (p *bpfProgram) jump(code uint16, k uint32, jt, jf string) {
+	if p.jumps == nil {
+		p.jumps = map[int][2]string{}
+	}
+	p.jumps[len(p.insns)] = [2]string{jt, jf}
+	p.insns = append(p.insns, syscall.SockFilter{Code: code | syscall.BPF_JMP, K: k})
+}
+
+func (p *bpfProgram) ja(label string) {
+	if p.gotos == nil {
+		p.gotos = map[int]string{}
+	}
+	p.gotos[len(p.insns)] = label
+	p.insns = append(p.insns, syscall.SockFilter{Code: syscall.BPF_JMP | syscall.BPF_JA})
+}
+
+func (p *bpfProgram) label(name string) {
+	if p.labels == nil {
+		p.labels = map[string][]int{}
+	}
+	p.labels[name] = append(p.labels[name], len(p.insns))
+}
+
+// loadArg loads the high or low 32 bits of a syscall argument, the args are little endian on the supported archs
+func (p *bpfProgram) loadArg(index uint, high bool) {
+	offset := uint32(seccompDataArgs + 8*index)
+	if high {
+		offset += 4
+	}
+	p.stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, offset)
+}
+
+// assemble resolves the labels into the relative offsets of the jumps
+func (p *bpfProgram) assemble() ([]syscall.SockFilter, error) {
+	target := func(from int, label string) (int, error) {
+		for _, pos := range p.labels[label] {
+			if pos > from {
+				return pos - from - 1, nil
+			}
+		}
+		return 0, fmt.Errorf("label %s after %d is not defined", label, from)
+	}
+	for i, labels := range p.jumps {
+		for branch, label := range labels {
+			if label == "" {
+				continue
+			}
+			offset, err := target(i, label)
+			if err != nil {
+				return nil, err
+			}
+			if offset > 255 {
+				return nil, fmt.Errorf("jump to %s is too far", label)
+			}
+			if branch == 0 {
+				p.insns[i].Jt = uint8(offset)
+			} else {
+				p.insns[i].Jf = uint8(offset)
+			}
+		}
+	}
+	for i, label := range p.gotos {
+		offset, err := target(i, label)
+		if err != nil {
+			return nil, err
+		}
+		p.insns[i].K = uint32(offset)
+	}
+	if len(p.insns) > syscall.BPF_MAXINSNS {
+		return nil, fmt.Errorf("the seccomp filter has %d instructions, more than %d", len(p.insns), syscall.BPF_MAXINSNS)
+	}
+	return p.insns, nil
+}
+
+// installSeccomp filters the syscalls of the calling thread and its children. it needs CAP_SYS_ADMIN
+// unless no_new_privs is set, so it's done last only if no_new_privs is set
+func installSeccomp(filter []syscall.SockFilter) error {
+	if len(filter) == 0 {
+		return nil
+	}
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if err := syscall.Prctl(syscall.PR_SET_SECCOMP, syscall.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)), 0, 0); err != nil {
+		return fmt.Errorf("install seccomp filter error: %v", err)
+	}
+	return nil
+}
+
+// encodeSeccomp encodes the filter in hex for the exec process, which installs it as struct sock_filter[]
+func encodeSeccomp(filter []syscall.SockFilter) (string, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.NativeEndian, filter); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
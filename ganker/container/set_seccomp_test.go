@@ -0,0 +1,238 @@
+package container
+
+import (
+	"encoding/binary"
+	"testing"
+
+	syscall "golang.org/x/sys/unix"
+)
+
+// runSeccomp runs the filter on the seccomp_data of a syscall like the kernel does, and returns its return value
+func runSeccomp(t *testing.T, filter []syscall.SockFilter, arch uint32, nr int, args [6]uint64) uint32 {
+	data := make([]byte, seccompDataArgs+8*6)
+	binary.LittleEndian.PutUint32(data[seccompDataNr:], uint32(nr))
+	binary.LittleEndian.PutUint32(data[seccompDataArch:], arch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[seccompDataArgs+8*i:], arg)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		insn := filter[pc]
+		switch insn.Code {
+		case syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS:
+			acc = binary.LittleEndian.Uint32(data[insn.K:])
+		case syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K:
+			acc &= insn.K
+		case syscall.BPF_JMP | syscall.BPF_JA:
+			pc += int(insn.K)
+		case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K,
+			syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K,
+			syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K:
+			var match bool
+			switch insn.Code &^ (syscall.BPF_JMP | syscall.BPF_K) {
+			case syscall.BPF_JEQ:
+				match = acc == insn.K
+			case syscall.BPF_JGT:
+				match = acc > insn.K
+			case syscall.BPF_JGE:
+				match = acc >= insn.K
+			}
+			if match {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		case syscall.BPF_RET | syscall.BPF_K:
+			return insn.K
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", insn.Code, pc)
+		}
+	}
+	t.Fatalf("the filter runs off its end")
+	return 0
+}
+
+func errnoRet(errno uint) *uint {
+	return &errno
+}
+
+func TestSeccompAction(t *testing.T) {
+	tests := []struct {
+		action   string
+		errnoRet *uint
+		want     uint32
+		wantErr  bool
+	}{
+		{action: "SCMP_ACT_ALLOW", want: 0x7fff0000},
+		{action: "SCMP_ACT_ERRNO", want: 0x00050000 | uint32(syscall.EPERM)},
+		{action: "SCMP_ACT_ERRNO", errnoRet: errnoRet(uint(syscall.ENOSYS)), want: 0x00050000 | uint32(syscall.ENOSYS)},
+		{action: "SCMP_ACT_ERRNO", errnoRet: errnoRet(0x10001), want: 0x00050001},
+		{action: "SCMP_ACT_TRACE", errnoRet: errnoRet(5), want: 0x7ff00005},
+		{action: "SCMP_ACT_TRAP", want: 0x00030000},
+		{action: "SCMP_ACT_LOG", want: 0x7ffc0000},
+		{action: "SCMP_ACT_KILL", want: 0x00000000},
+		{action: "SCMP_ACT_KILL_THREAD", want: 0x00000000},
+		{action: "SCMP_ACT_KILL_PROCESS", want: 0x80000000},
+		{action: "SCMP_ACT_NOTIFY", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := seccompAction(tt.action, tt.errnoRet)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("seccompAction(%s) error = %v, want error %v", tt.action, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("seccompAction(%s) = %#x, want %#x", tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestCompileSeccomp(t *testing.T) {
+	if seccompNativeArch == 0 {
+		t.Skip("seccomp isn't supported on this architecture")
+	}
+	const (
+		allow = seccompRetAllow
+		deny  = seccompRetErrno | 1
+	)
+	read, write := seccompSyscalls["read"], seccompSyscalls["write"]
+	// the high and low 32 bits are compared apart, so the values around them are what matter
+	const value = 0x1_0000_0005
+
+	// allowRead only allows read if its arguments match
+	allowRead := func(args ...SeccompArg) *SeccompProfile {
+		return &SeccompProfile{
+			DefaultAction:   "SCMP_ACT_ERRNO",
+			DefaultErrnoRet: errnoRet(1),
+			Syscalls:        []SeccompSyscall{{Names: []string{"read"}, Action: "SCMP_ACT_ALLOW", Args: args}},
+		}
+	}
+	arg := func(op string, index uint, value, valueTwo uint64) SeccompArg {
+		return SeccompArg{Index: index, Value: value, ValueTwo: valueTwo, Op: op}
+	}
+
+	// the rules of a syscall are tried in order, a rule whose arguments don't match falls to the next one
+	rules := &SeccompProfile{
+		DefaultAction: "SCMP_ACT_ALLOW",
+		Syscalls: []SeccompSyscall{
+			{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO", ErrnoRet: errnoRet(2), Args: []SeccompArg{arg("SCMP_CMP_EQ", 0, 1, 0)}},
+			{Name: "read", Action: "SCMP_ACT_KILL_PROCESS", Args: []SeccompArg{arg("SCMP_CMP_EQ", 0, 2, 0)}},
+			{Names: []string{"write", "no_such_syscall"}, Action: "SCMP_ACT_TRACE", ErrnoRet: errnoRet(7)},
+		},
+	}
+	tests := []struct {
+		name    string
+		profile *SeccompProfile
+		arch    uint32
+		nr      int
+		args    [6]uint64
+		want    uint32
+	}{
+		{name: "no args", profile: allowRead(), nr: read, want: allow},
+		{name: "other syscall", profile: allowRead(), nr: write, want: deny},
+		{name: "other arch", profile: allowRead(), arch: seccompNativeArch + 1, nr: read, want: seccompRetKillProcess},
+
+		{name: "eq", profile: allowRead(arg("SCMP_CMP_EQ", 0, value, 0)), nr: read, args: [6]uint64{value}, want: allow},
+		{name: "eq other high", profile: allowRead(arg("SCMP_CMP_EQ", 0, value, 0)), nr: read, args: [6]uint64{0x5}, want: deny},
+		{name: "eq other low", profile: allowRead(arg("SCMP_CMP_EQ", 0, value, 0)), nr: read, args: [6]uint64{0x1_0000_0006}, want: deny},
+		{name: "eq last arg", profile: allowRead(arg("SCMP_CMP_EQ", 5, value, 0)), nr: read, args: [6]uint64{5: value}, want: allow},
+		{name: "eq other arg", profile: allowRead(arg("SCMP_CMP_EQ", 5, value, 0)), nr: read, args: [6]uint64{value}, want: deny},
+
+		{name: "ne equal", profile: allowRead(arg("SCMP_CMP_NE", 0, value, 0)), nr: read, args: [6]uint64{value}, want: deny},
+		{name: "ne other high", profile: allowRead(arg("SCMP_CMP_NE", 0, value, 0)), nr: read, args: [6]uint64{0x5}, want: allow},
+		{name: "ne other low", profile: allowRead(arg("SCMP_CMP_NE", 0, value, 0)), nr: read, args: [6]uint64{0x1_0000_0006}, want: allow},
+
+		{name: "gt above", profile: allowRead(arg("SCMP_CMP_GT", 0, value, 0)), nr: read, args: [6]uint64{value + 1}, want: allow},
+		{name: "gt equal", profile: allowRead(arg("SCMP_CMP_GT", 0, value, 0)), nr: read, args: [6]uint64{value}, want: deny},
+		{name: "gt higher high lower low", profile: allowRead(arg("SCMP_CMP_GT", 0, value, 0)), nr: read, args: [6]uint64{0x2_0000_0000}, want: allow},
+		{name: "gt lower high higher low", profile: allowRead(arg("SCMP_CMP_GT", 0, value, 0)), nr: read, args: [6]uint64{0xffff_ffff}, want: deny},
+
+		{name: "ge equal", profile: allowRead(arg("SCMP_CMP_GE", 0, value, 0)), nr: read, args: [6]uint64{value}, want: allow},
+		{name: "ge below", profile: allowRead(arg("SCMP_CMP_GE", 0, value, 0)), nr: read, args: [6]uint64{value - 1}, want: deny},
+		{name: "ge higher high lower low", profile: allowRead(arg("SCMP_CMP_GE", 0, value, 0)), nr: read, args: [6]uint64{0x2_0000_0000}, want: allow},
+		{name: "ge lower high higher low", profile: allowRead(arg("SCMP_CMP_GE", 0, value, 0)), nr: read, args: [6]uint64{0xffff_ffff}, want: deny},
+
+		{name: "lt below", profile: allowRead(arg("SCMP_CMP_LT", 0, value, 0)), nr: read, args: [6]uint64{value - 1}, want: allow},
+		{name: "lt equal", profile: allowRead(arg("SCMP_CMP_LT", 0, value, 0)), nr: read, args: [6]uint64{value}, want: deny},
+		{name: "lt lower high higher low", profile: allowRead(arg("SCMP_CMP_LT", 0, value, 0)), nr: read, args: [6]uint64{0xffff_ffff}, want: allow},
+		{name: "lt higher high lower low", profile: allowRead(arg("SCMP_CMP_LT", 0, value, 0)), nr: read, args: [6]uint64{0x2_0000_0000}, want: deny},
+
+		{name: "le equal", profile: allowRead(arg("SCMP_CMP_LE", 0, value, 0)), nr: read, args: [6]uint64{value}, want: allow},
+		{name: "le above", profile: allowRead(arg("SCMP_CMP_LE", 0, value, 0)), nr: read, args: [6]uint64{value + 1}, want: deny},
+		{name: "le lower high higher low", profile: allowRead(arg("SCMP_CMP_LE", 0, value, 0)), nr: read, args: [6]uint64{0xffff_ffff}, want: allow},
+
+		{name: "masked eq", profile: allowRead(arg("SCMP_CMP_MASKED_EQ", 0, 0xf0_0000_00f0, 0x10_0000_0010)), nr: read, args: [6]uint64{0x1f_0000_001f}, want: allow},
+		{name: "masked eq other low", profile: allowRead(arg("SCMP_CMP_MASKED_EQ", 0, 0xf0_0000_00f0, 0x10_0000_0010)), nr: read, args: [6]uint64{0x1f_0000_002f}, want: deny},
+		{name: "masked eq other high", profile: allowRead(arg("SCMP_CMP_MASKED_EQ", 0, 0xf0_0000_00f0, 0x10_0000_0010)), nr: read, args: [6]uint64{0x2f_0000_001f}, want: deny},
+
+		{name: "all args match", profile: allowRead(arg("SCMP_CMP_EQ", 0, 1, 0), arg("SCMP_CMP_EQ", 1, 2, 0)), nr: read, args: [6]uint64{1, 2}, want: allow},
+		{name: "one arg differs", profile: allowRead(arg("SCMP_CMP_EQ", 0, 1, 0), arg("SCMP_CMP_EQ", 1, 2, 0)), nr: read, args: [6]uint64{1, 3}, want: deny},
+
+		{name: "first rule", profile: rules, nr: read, args: [6]uint64{1}, want: seccompRetErrno | 2},
+		{name: "second rule", profile: rules, nr: read, args: [6]uint64{2}, want: seccompRetKillProcess},
+		{name: "no rule matches", profile: rules, nr: read, args: [6]uint64{3}, want: seccompRetAllow},
+		{name: "unknown syscall ignored", profile: rules, nr: write, want: seccompRetTrace | 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := compileSeccomp(tt.profile, nil)
+			if err != nil {
+				t.Fatalf("compileSeccomp error = %v", err)
+			}
+			arch := tt.arch
+			if arch == 0 {
+				arch = seccompNativeArch
+			}
+			if got := runSeccomp(t, filter, arch, tt.nr, tt.args); got != tt.want {
+				t.Errorf("filter returns %#x, want %#x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileSeccompInvalid(t *testing.T) {
+	if seccompNativeArch == 0 {
+		t.Skip("seccomp isn't supported on this architecture")
+	}
+	tests := []struct {
+		name    string
+		profile *SeccompProfile
+	}{
+		{name: "default action", profile: &SeccompProfile{DefaultAction: "SCMP_ACT_NOTIFY"}},
+		{name: "rule action", profile: &SeccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls:      []SeccompSyscall{{Names: []string{"read"}, Action: "SCMP_ACT_NOTIFY"}},
+		}},
+		{name: "arg index", profile: &SeccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls: []SeccompSyscall{{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO",
+				Args: []SeccompArg{{Index: 6, Value: 1, Op: "SCMP_CMP_EQ"}}}},
+		}},
+		{name: "operator", profile: &SeccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls: []SeccompSyscall{{Names: []string{"read"}, Action: "SCMP_ACT_ERRNO",
+				Args: []SeccompArg{{Index: 0, Value: 1, Op: "SCMP_CMP_IN"}}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileSeccomp(tt.profile, nil); err == nil {
+				t.Errorf("compileSeccomp succeeds, want an error")
+			}
+		})
+	}
+}
+
+// the default profile must compile for the default capabilities and for all of them
+func TestCompileDefaultSeccomp(t *testing.T) {
+	if seccompNativeArch == 0 {
+		t.Skip("seccomp isn't supported on this architecture")
+	}
+	for _, caps := range [][]string{DefaultCapabilities, allCapabilities()} {
+		if _, err := compileSeccomp(defaultSeccompProfile(), caps); err != nil {
+			t.Errorf("compileSeccomp of the default profile error = %v", err)
+		}
+	}
+}
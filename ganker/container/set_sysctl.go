@@ -0,0 +1,66 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ipcSysctls are the sysctls of the ipc namespace, and the ones under fs.mqueue.
+// the other namespaced sysctls are under net., which belong to the network namespace
+var ipcSysctls = []string{
+	"kernel.msgmax", "kernel.msgmnb", "kernel.msgmni", "kernel.sem",
+	"kernel.shmall", "kernel.shmmax", "kernel.shmmni", "kernel.shm_rmid_forced",
+}
+
+// ParseSysctls parses the sysctls like "net.core.somaxconn=1024", the keys may also be separated by "/"
+func ParseSysctls(sysctls []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, sysctl := range sysctls {
+		key, value, ok := strings.Cut(sysctl, "=")
+		key = strings.ReplaceAll(strings.TrimSpace(key), "/", ".")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid sysctl %s, it should be like <key>=<value>", sysctl)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// checkSysctls only allows the sysctls of the namespaces owned by the container,
+// the others would change the host or the container sharing the namespace
+func checkSysctls(sysctls map[string]string, namespaces map[string]string) error {
+	for key := range sysctls {
+		namespace := sysctlNamespace(key)
+		if namespace == "" {
+			return fmt.Errorf("sysctl %s is not namespaced, it can't be set in a container", key)
+		}
+		if mode := namespaces[namespace]; mode != "" {
+			return fmt.Errorf("sysctl %s can't be set in the %s namespace shared with %s", key, namespace, mode)
+		}
+	}
+	return nil
+}
+
+// sysctlNamespace returns the namespace of a sysctl, it is empty if the sysctl is not namespaced
+func sysctlNamespace(key string) string {
+	switch {
+	case contains(ipcSysctls, key), strings.HasPrefix(key, "fs.mqueue."):
+		return "ipc"
+	case strings.HasPrefix(key, "net."):
+		return "net"
+	}
+	return ""
+}
+
+// setupSysctls writes the sysctls into proc/sys of the rootfs, which must be done before /proc/sys is made read only
+func setupSysctls(rootfs string, sysctls map[string]string) error {
+	for key, value := range sysctls {
+		path := filepath.Join(rootfs, "proc/sys", strings.ReplaceAll(key, ".", "/"))
+		if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+			return fmt.Errorf("set sysctl %s=%s error: %v", key, value, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// ExecUser is the user a command of the container runs as
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int  // supplementary groups
+	Home   string // "/" if the user is not in the passwd file
+}
+
+// passwdEntry is a line of /etc/passwd, like "root:x:0:0:root:/root:/bin/sh"
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+	home string
+}
+
+// groupEntry is a line of /etc/group, like "wheel:x:10:root,admin"
+type groupEntry struct {
+	name    string
+	gid     int
+	members []string
+}
+
+// lookupUser resolves "user[:group]" against the passwd and group files of the container, the user and group
+// may be names or ids. a uid not in the passwd file is allowed, its gid is the same as the uid unless it's given.
+// the supplementary groups are the groups listing the user as a member, they are not set if the group is given
+func lookupUser(user, passwdFile, groupFile string) (*ExecUser, error) {
+	userStr, groupStr, hasGroup := strings.Cut(user, ":")
+	if userStr == "" || (hasGroup && groupStr == "") {
+		return nil, fmt.Errorf("invalid user %s, it should be like user[:group]", user)
+	}
+	passwd, err := readPasswd(passwdFile)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(groupFile)
+	if err != nil {
+		return nil, err
+	}
+
+	execUser := &ExecUser{Home: "/"}
+	var entry *passwdEntry
+	uid, err := strconv.Atoi(userStr)
+	for i := range passwd {
+		if (err == nil && passwd[i].uid == uid) || (err != nil && passwd[i].name == userStr) {
+			entry = &passwd[i]
+			break
+		}
+	}
+	switch {
+	case entry != nil:
+		execUser.Uid, execUser.Gid, execUser.Home = entry.uid, entry.gid, entry.home
+	case err == nil && uid >= 0:
+		execUser.Uid, execUser.Gid = uid, uid
+	default:
+		return nil, fmt.Errorf("unable to find user %s in %s", userStr, passwdFile)
+	}
+
+	if hasGroup {
+		gid, err := strconv.Atoi(groupStr)
+		if err != nil {
+			gid = -1
+			for _, group := range groups {
+				if group.name == groupStr {
+					gid = group.gid
+					break
+				}
+			}
+		}
+		if gid < 0 {
+			return nil, fmt.Errorf("unable to find group %s in %s", groupStr, groupFile)
+		}
+		execUser.Gid = gid
+		return execUser, nil
+	}
+
+	if entry != nil {
+		for _, group := range groups {
+			if group.gid != execUser.Gid && contains(group.members, entry.name) {
+				execUser.Groups = append(execUser.Groups, group.gid)
+			}
+		}
+	}
+	return execUser, nil
+}
+
+// readPasswd reads the entries of a passwd file, a missing file has no entries
+func readPasswd(file string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(file, func(fields []string) {
+		if len(fields) < 6 {
+			return
+		}
+		uid, err1 := strconv.Atoi(fields[2])
+		gid, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			return
+		}
+		home := fields[5]
+		if home == "" {
+			home = "/"
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid, home: home})
+	})
+	return entries, err
+}
+
+// readGroup reads the entries of a group file, a missing file has no entries
+func readGroup(file string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(file, func(fields []string) {
+		if len(fields) < 4 {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		entry := groupEntry{name: fields[0], gid: gid}
+		if fields[3] != "" {
+			entry.members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, entry)
+	})
+	return entries, err
+}
+
+// readColonFile calls parse with the fields of each line of a file like /etc/passwd, comments and blank lines are skipped
+func readColonFile(file string, parse func(fields []string)) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open %s error: %v", file, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parse(strings.Split(line, ":"))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read %s error: %v", file, err)
+	}
+	return nil
+}
+
+// encodeGroups joins the gids with commas, like "10,20"
+func encodeGroups(groups []int) string {
+	strs := make([]string, len(groups))
+	for i, gid := range groups {
+		strs[i] = strconv.Itoa(gid)
+	}
+	return strings.Join(strs, ",")
+}
+
+// hasEnv reports whether the environment sets the variable
+func hasEnv(env []string, key string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	subUidPath = "/etc/subuid"
+	subGidPath = "/etc/subgid"
+)
+
+// IDMapping maps Size ids starting from ContainerID in the container to the ids starting from HostID on the host
+type IDMapping struct {
+	ContainerID int `json:"container id"`
+	HostID      int `json:"host id"`
+	Size        int `json:"size"`
+}
+
+// UserNamespace is the id mappings of a container running in its own user namespace
+type UserNamespace struct {
+	UidMappings []IDMapping `json:"uid mappings"`
+	GidMappings []IDMapping `json:"gid mappings"`
+}
+
+// NewUserNamespace builds the id mappings from --userns-remap or --uidmap/--gidmap,
+// it returns nil if none of them is given, the container then shares the user namespace of the host.
+// remap is a user on the host whose ranges in /etc/subuid and /etc/subgid are used
+func NewUserNamespace(remap string, uidMaps, gidMaps []string) (*UserNamespace, error) {
+	if remap == "" && len(uidMaps) == 0 && len(gidMaps) == 0 {
+		return nil, nil
+	}
+	if remap != "" && (len(uidMaps) != 0 || len(gidMaps) != 0) {
+		return nil, fmt.Errorf("--userns-remap can't be used with --uidmap or --gidmap")
+	}
+
+	userNs := &UserNamespace{}
+	var err error
+	if remap != "" {
+		if userNs.UidMappings, err = readSubIDs(subUidPath, remap); err != nil {
+			return nil, err
+		}
+		if userNs.GidMappings, err = readSubIDs(subGidPath, remap); err != nil {
+			return nil, err
+		}
+		return userNs, nil
+	}
+
+	if userNs.UidMappings, err = parseIDMappings(uidMaps); err != nil {
+		return nil, err
+	}
+	if userNs.GidMappings, err = parseIDMappings(gidMaps); err != nil {
+		return nil, err
+	}
+	// the same as podman, the gid mappings follow the uid mappings if they're not given
+	if len(userNs.GidMappings) == 0 {
+		userNs.GidMappings = userNs.UidMappings
+	}
+	if len(userNs.UidMappings) == 0 {
+		userNs.UidMappings = userNs.GidMappings
+	}
+	return userNs, nil
+}
+
+// parseIDMappings parses mappings like "0:100000:65536" (containerID:hostID:size)
+func parseIDMappings(mappings []string) ([]IDMapping, error) {
+	var result []IDMapping
+	for _, mapping := range mappings {
+		fields := strings.Split(mapping, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid id mapping %s, it should be like containerID:hostID:size", mapping)
+		}
+		var ids [3]int
+		for i, field := range fields {
+			id, err := strconv.Atoi(field)
+			if err != nil || id < 0 {
+				return nil, fmt.Errorf("invalid id mapping %s", mapping)
+			}
+			ids[i] = id
+		}
+		if ids[2] == 0 {
+			return nil, fmt.Errorf("invalid id mapping %s, size can't be 0", mapping)
+		}
+		result = append(result, IDMapping{ContainerID: ids[0], HostID: ids[1], Size: ids[2]})
+	}
+	return result, nil
+}
+
+// readSubIDs reads the ranges of a user in /etc/subuid or /etc/subgid, the lines are like "ganker:100000:65536".
+// the ranges are mapped one after another from id 0 in the container
+func readSubIDs(file, userName string) ([]IDMapping, error) {
+	// the file may refer the user by name or by uid
+	names := []string{userName}
+	if u, err := user.Lookup(userName); err == nil {
+		names = append(names, u.Uid)
+	} else if u, err := user.LookupId(userName); err == nil {
+		names = append(names, u.Username)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open %s error: %v", file, err)
+	}
+	defer f.Close()
+
+	var mappings []IDMapping
+	containerID := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(fields) != 3 || !contains(names, fields[0]) {
+			continue
+		}
+		hostID, err1 := strconv.Atoi(fields[1])
+		size, err2 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid line %s in %s", scanner.Text(), file)
+		}
+		mappings = append(mappings, IDMapping{ContainerID: containerID, HostID: hostID, Size: size})
+		containerID += size
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s error: %v", file, err)
+	}
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("no subordinate ids of user %s in %s", userName, file)
+	}
+	return mappings, nil
+}
+
+// setSysProcAttr makes the process cloned in a new user namespace with the mappings, and run as root in it
+func (u *UserNamespace) setSysProcAttr(attr *syscall.SysProcAttr) {
+	attr.Cloneflags |= syscall.CLONE_NEWUSER
+	attr.UidMappings = sysProcIDMaps(u.UidMappings)
+	attr.GidMappings = sysProcIDMaps(u.GidMappings)
+	// the process keeps the host ids after clone, which are not mapped in the new namespace
+	attr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	// the kernel only allows an unprivileged user to write gid_map after setgroups is denied
+	attr.GidMappingsEnableSetgroups = !Rootless
+	attr.Credential.NoSetGroups = Rootless
+}
+
+// sysProcIDMaps converts the mappings into the ones exec.Cmd writes into /proc/<pid>/{uid,gid}_map
+func sysProcIDMaps(mappings []IDMapping) []syscall.SysProcIDMap {
+	var result []syscall.SysProcIDMap
+	for _, m := range mappings {
+		result = append(result, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return result
+}
+
+// toHost returns the host id of an id in the container, ok is false if the id isn't mapped
+func toHost(mappings []IDMapping, id int) (int, bool) {
+	for _, m := range mappings {
+		if id >= m.ContainerID && id < m.ContainerID+m.Size {
+			return id - m.ContainerID + m.HostID, true
+		}
+	}
+	return -1, false
+}
+
+// toContainer returns the container id of an id on the host, ok is false if the id isn't mapped
+func toContainer(mappings []IDMapping, id int) (int, bool) {
+	for _, m := range mappings {
+		if id >= m.HostID && id < m.HostID+m.Size {
+			return id - m.HostID + m.ContainerID, true
+		}
+	}
+	return -1, false
+}
+
+// RootPair returns the host uid and gid of root in the container
+func (u *UserNamespace) RootPair() (int, int, error) {
+	uid, ok := toHost(u.UidMappings, 0)
+	if !ok {
+		return 0, 0, fmt.Errorf("uid 0 isn't mapped in the user namespace")
+	}
+	gid, ok := toHost(u.GidMappings, 0)
+	if !ok {
+		return 0, 0, fmt.Errorf("gid 0 isn't mapped in the user namespace")
+	}
+	return uid, gid, nil
+}
+
+// chownRoot makes root of the container the owner of path, it is called on the dirs ganker creates for the container
+func (u *UserNamespace) chownRoot(path string) error {
+	uid, gid, err := u.RootPair()
+	if err != nil {
+		return err
+	}
+	if err := os.Lchown(path, uid, gid); err != nil {
+		return fmt.Errorf("chown %s error: %v", path, err)
+	}
+	return nil
+}
+
+// shiftOwnership changes the owner of every file under root from the ids in the image to their mapped host ids,
+// so the files are owned by the same users inside the container as they are in the image
+func (u *UserNamespace) shiftOwnership(root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, uidOk := toHost(u.UidMappings, int(stat.Uid))
+		gid, gidOk := toHost(u.GidMappings, int(stat.Gid))
+		if !uidOk || !gidOk {
+			return nil
+		}
+		// chown clears the setuid and setgid bits, they are set again after it
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return fmt.Errorf("chown %s error: %v", path, err)
+		}
+		if info.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 && info.Mode()&os.ModeSymlink == 0 {
+			if err := syscall.Chmod(path, stat.Mode&07777); err != nil {
+				return fmt.Errorf("chmod %s error: %v", path, err)
+			}
+		}
+		return nil
+	})
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
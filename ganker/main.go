@@ -14,12 +14,23 @@ func main() {
 }
 
 func init() {
+	// the init process runs in the rootfs of the container, the root dirs are relative to the working dir
+	// when ganker is run by root, so they would be created in the container
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		return
+	}
+
 	// image root dir
 	// check if the root dir of image exist
 	if err := os.MkdirAll(container.ImageRootPath, 0777); err != nil {
 		log.Panic("Fail to create root dir of image: " + err.Error())
 	}
 
+	// layer root dir, the layers of the images are unpacked here once and shared by the containers
+	if err := os.MkdirAll(container.LayerRootPath, 0777); err != nil {
+		log.Panic("Fail to create root dir of layer: " + err.Error())
+	}
+
 	// storage root dir, which is used to store the container's data
 	// check if the root dir of container exist
 
@@ -76,7 +76,8 @@ func (d *BridgeNetDriver) Connect(net *Net, endpoint *NetPoint) error {
 	// create veth pair , one end of veth pair is mounted to the bridge in network(as Peer)
 	endpoint.Device = netlink.Veth{
 		LinkAttrs: la,
-		PeerName:  endpoint.ID[:5],
+		// the peer is moved into the container, its name must differ from the end on the bridge
+		PeerName: "cif-" + endpoint.ID[:5],
 	}
 
 	// create veth pair interface
@@ -143,6 +143,8 @@ func enterContainerNetns(link *netlink.Link, pid string) func() {
 	}
 }
 
+// ConfigurePortMapping forwards the host ports to the container, a mapping is like 8080:80, or 5353:53/udp
+// for a port of another protocol than tcp
 func ConfigurePortMapping(endpoint *NetPoint) error {
 
 	for _, pm := range endpoint.PortMapping {
@@ -150,6 +152,16 @@ func ConfigurePortMapping(endpoint *NetPoint) error {
 		if len(mapArray) != 2 {
 			return fmt.Errorf("invalid port mapping: %s", pm)
 		}
+		containerPort, protocol, _ := strings.Cut(mapArray[1], "/")
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return fmt.Errorf("invalid port mapping: %s, only tcp and udp ports can be mapped", pm)
+		}
+		if err := checkPortFree(protocol, mapArray[0]); err != nil {
+			return err
+		}
 
 		iptable, err := iptables.New()
 		if err != nil {
@@ -157,7 +169,7 @@ func ConfigurePortMapping(endpoint *NetPoint) error {
 		}
 
 		// add DNAT rule to nat table
-		command := []string{"-p", "tcp", "-m", "tcp", "--dport", mapArray[0], "-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%s", endpoint.IP.String(), mapArray[1])}
+		command := []string{"-p", protocol, "-m", protocol, "--dport", mapArray[0], "-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%s", endpoint.IP.String(), containerPort)}
 		if err := iptable.Append("nat", "POSTROUTING", command...); err != nil {
 			fmt.Printf("iptables append %v:%v port mapping error: %v", mapArray[0], mapArray[1], err)
 			continue
@@ -166,6 +178,22 @@ func ConfigurePortMapping(endpoint *NetPoint) error {
 	return nil
 }
 
+// checkPortFree fails if a process of the host listens on the port, the DNAT rule would take its packets
+func checkPortFree(protocol, port string) error {
+	if protocol == "udp" {
+		conn, err := net.ListenPacket("udp", ":"+port)
+		if err != nil {
+			return fmt.Errorf("host port %s/udp is in use: %v", port, err)
+		}
+		return conn.Close()
+	}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("host port %s/tcp is in use: %v", port, err)
+	}
+	return listener.Close()
+}
+
 func (n *Net) Dump(configPath string) error {
 
 	if err := os.MkdirAll(configPath, 0644); err != nil {
@@ -11,6 +11,174 @@ package nsenter
 #include <stdio.h>
 #include <errno.h>
 #include <string.h>
+#include <grp.h>
+#include <sys/stat.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
+#include <linux/capability.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
+
+// same_namespace returns 1 if the caller is in the namespace of the container, -1 if it can't be checked
+static int same_namespace(char *container_pid, char *namespace) {
+	char nspath[1024], selfpath[1024];
+	struct stat self, target;
+	sprintf(nspath, "/proc/%s/ns/%s", container_pid, namespace);
+	sprintf(selfpath, "/proc/self/ns/%s", namespace);
+	if (stat(nspath, &target) == -1 || stat(selfpath, &self) == -1) {
+		return -1;
+	}
+	return self.st_ino == target.st_ino && self.st_dev == target.st_dev;
+}
+
+// join_user_namespace joins the user namespace of the container if it isn't the one of the caller,
+// and switches to root of the container, which is needed to join the other namespaces owned by it
+static int join_user_namespace(char *container_pid) {
+	char nspath[1024];
+	int same = same_namespace(container_pid, "user");
+	if (same != 0) {
+		return same == 1 ? 0 : -1;
+	}
+	sprintf(nspath, "/proc/%s/ns/user", container_pid);
+	int fd = open(nspath, O_RDONLY);
+	if (fd == -1) {
+		return -1;
+	}
+	if (setns(fd, CLONE_NEWUSER) == -1) {
+		close(fd);
+		return -1;
+	}
+	close(fd);
+	// setgroups is denied in the user namespace of a rootless container
+	if (setgroups(0, NULL) == -1 && errno != EPERM) {
+		return -1;
+	}
+	if (setresgid(0, 0, 0) == -1 || setresuid(0, 0, 0) == -1) {
+		return -1;
+	}
+	return 0;
+}
+
+// drop_bounding_set drops the capabilities not in the hex mask container_caps from the bounding set,
+// it needs CAP_SETPCAP, so it's done before the user is switched
+static int drop_bounding_set(char *container_caps) {
+	unsigned long long mask = strtoull(container_caps, NULL, 16);
+	int cap;
+	for (cap = 0; cap < 64; cap++) {
+		if (mask & (1ULL << cap)) {
+			continue;
+		}
+		if (prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) == -1) {
+			// the capabilities after the last one of the kernel are invalid
+			if (errno == EINVAL) {
+				break;
+			}
+			return -1;
+		}
+	}
+	return 0;
+}
+
+// set_capabilities keeps the capabilities in the hex mask container_caps and drops the others
+// from the effective, permitted and inheritable sets, the kept ones are raised in the ambient set
+static int set_capabilities(char *container_caps) {
+	unsigned long long mask = strtoull(container_caps, NULL, 16);
+	int cap;
+	struct __user_cap_header_struct header = { _LINUX_CAPABILITY_VERSION_3, 0 };
+	struct __user_cap_data_struct data[2];
+	if (syscall(SYS_capget, &header, data) == -1) {
+		return -1;
+	}
+	int i;
+	for (i = 0; i < 2; i++) {
+		data[i].permitted &= (__u32)(mask >> (32 * i));
+		data[i].effective = data[i].permitted;
+		data[i].inheritable = data[i].permitted;
+	}
+	if (syscall(SYS_capset, &header, data) == -1) {
+		return -1;
+	}
+	for (cap = 0; cap < 64; cap++) {
+		if (data[cap / 32].permitted & (1U << (cap % 32))) {
+			// the ambient set needs linux 4.3 or later
+			prctl(PR_CAP_AMBIENT, PR_CAP_AMBIENT_RAISE, cap, 0, 0);
+		}
+	}
+	return 0;
+}
+
+// switch_user sets the supplementary groups in container_groups, like "10,20", and the uid and gid in container_user,
+// like "1000:1000". only root keeps the permitted capabilities if keep_caps is set, to be limited by set_capabilities
+static int switch_user(char *container_user, char *container_groups, int keep_caps) {
+	unsigned int uid, gid;
+	if (sscanf(container_user, "%u:%u", &uid, &gid) != 2) {
+		errno = EINVAL;
+		return -1;
+	}
+	keep_caps = keep_caps && uid == 0;
+	gid_t groups[64];
+	int ngroups = 0;
+	char *group = container_groups;
+	while (group && *group && ngroups < 64) {
+		groups[ngroups++] = strtoul(group, &group, 10);
+		if (*group == ',') {
+			group++;
+		}
+	}
+	if (keep_caps && prctl(PR_SET_KEEPCAPS, 1, 0, 0, 0) == -1) {
+		return -1;
+	}
+	// setgroups is denied in the user namespace of a rootless container
+	if (setgroups(ngroups, groups) == -1 && errno != EPERM) {
+		return -1;
+	}
+	if (setresgid(gid, gid, gid) == -1 || setresuid(uid, uid, uid) == -1) {
+		return -1;
+	}
+	if (keep_caps && prctl(PR_SET_KEEPCAPS, 0, 0, 0, 0) == -1) {
+		return -1;
+	}
+	return 0;
+}
+
+// install_seccomp installs the seccomp filter encoded in hex as struct sock_filter[] in container_seccomp,
+// it needs CAP_SYS_ADMIN unless no_new_privs is set
+static int install_seccomp(char *container_seccomp) {
+	size_t len = strlen(container_seccomp) / 2;
+	if (len == 0 || len % sizeof(struct sock_filter) != 0) {
+		errno = EINVAL;
+		return -1;
+	}
+	unsigned char *filter = malloc(len);
+	if (filter == NULL) {
+		return -1;
+	}
+	size_t i;
+	for (i = 0; i < len; i++) {
+		unsigned int byte;
+		if (sscanf(container_seccomp + 2 * i, "%2x", &byte) != 1) {
+			free(filter);
+			errno = EINVAL;
+			return -1;
+		}
+		filter[i] = byte;
+	}
+	struct sock_fprog prog = { len / sizeof(struct sock_filter), (struct sock_filter *)filter };
+	int res = prctl(PR_SET_SECCOMP, SECCOMP_MODE_FILTER, &prog, 0, 0);
+	free(filter);
+	return res;
+}
+
+// clear_control_env removes the control vars of ganker exec, so the command doesn't inherit them.
+// unsetenv only takes them out of environ, the strings read by getenv before are still valid
+static void clear_control_env(void) {
+	char *names[] = { "container_pid", "container_cmd", "container_caps", "container_seccomp",
+		"container_user", "container_groups", "container_home", "container_no_new_privs" };
+	int i;
+	for (i = 0; i < sizeof(names) / sizeof(names[0]); i++) {
+		unsetenv(names[i]);
+	}
+}
 
 // __attribute__((constructor)) will make this function run before main() if this package is imported
 __attribute__((constructor)) void enter_namespace(void) {
@@ -30,19 +198,71 @@ __attribute__((constructor)) void enter_namespace(void) {
 	}else {
 		return;
 	}
+	// the go runtime can't start in the namespaces joined partly, so the errors exit
+	if (join_user_namespace(container_pid) == -1) {
+		fprintf(stderr, "C :join user namespace error: %s\n", strerror(errno));
+		exit(1);
+	}
 	int i;
 	char nspath[1024];
-	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt"};
-	for (i=0; i<5; i++) {
+	char *namespaces[] = { "ipc", "uts", "net", "pid", "cgroup", "time", "mnt"};
+	for (i=0; i<7; i++) {
+		// the namespaces shared with the host, like the cgroup namespace of a rootless container, are not joined,
+		// as joining a namespace owned by the host needs root on the host
+		if (same_namespace(container_pid, namespaces[i]) == 1) {
+			continue;
+		}
 		// join the path of namespace
 		sprintf(nspath, "/proc/%s/ns/%s", container_pid, namespaces[i]);
 		int fd = open(nspath, O_RDONLY);
+		// the kernel may not support the cgroup or time namespace
+		if (fd == -1 && errno == ENOENT) {
+			continue;
+		}
 		// invoke setns to join the namespace , if success, return 0
 		if (setns(fd, 0) == -1) {
-			return;
+			fprintf(stderr, "C :join %s namespace error: %s\n", namespaces[i], strerror(errno));
+			exit(1);
 		}
 		close(fd);
 	}
+	// without no_new_privs the filter needs CAP_SYS_ADMIN, which may be gone after the user is switched,
+	// so it's installed before, and must allow the syscalls left, like setresuid and capset
+	char *container_seccomp = getenv("container_seccomp");
+	int no_new_privs = getenv("container_no_new_privs") != NULL;
+	if (!no_new_privs && container_seccomp && install_seccomp(container_seccomp) == -1) {
+		fprintf(stderr, "C :install seccomp filter error: %s\n", strerror(errno));
+		exit(1);
+	}
+	char *container_caps = getenv("container_caps");
+	if (container_caps && drop_bounding_set(container_caps) == -1) {
+		fprintf(stderr, "C :drop bounding set error: %s\n", strerror(errno));
+		exit(1);
+	}
+	char *container_user = getenv("container_user");
+	if (container_user && switch_user(container_user, getenv("container_groups"), container_caps != NULL) == -1) {
+		fprintf(stderr, "C :switch user %s error: %s\n", container_user, strerror(errno));
+		exit(1);
+	}
+	if (container_caps && set_capabilities(container_caps) == -1) {
+		fprintf(stderr, "C :set capabilities error: %s\n", strerror(errno));
+		exit(1);
+	}
+	char *container_home = getenv("container_home");
+	if (container_home) {
+		setenv("HOME", container_home, 1);
+	}
+	// the setuid binaries and file capabilities can't grant more privileges to the command after it's set
+	if (no_new_privs && prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) == -1) {
+		fprintf(stderr, "C :set no_new_privs error: %s\n", strerror(errno));
+		exit(1);
+	}
+	// the filter is installed last, so only the command goes through it
+	if (no_new_privs && container_seccomp && install_seccomp(container_seccomp) == -1) {
+		fprintf(stderr, "C :install seccomp filter error: %s\n", strerror(errno));
+		exit(1);
+	}
+	clear_control_env();
 	// 进入所有Namespace后执行指定的命令
 	int res = system(container_cmd);
 	exit(0);
@@ -51,5 +271,32 @@ __attribute__((constructor)) void enter_namespace(void) {
 */
 import "C"
 
+import (
+	"fmt"
+	"os"
+
+	syscall "golang.org/x/sys/unix"
+)
+
 func EnterNamespace() {
 }
+
+// NamespacePath returns the file of a namespace of the process, like /proc/<pid>/ns/net,
+// it is the same file enter_namespace opens to join the namespace
+func NamespacePath(pid, namespace string) string {
+	return fmt.Sprintf("/proc/%s/ns/%s", pid, namespace)
+}
+
+// Setns makes the calling thread join a namespace of the process, the caller must lock the goroutine to the thread.
+// the calling thread itself doesn't change its pid namespace, the processes it creates are in the new one
+func Setns(pid, namespace string) error {
+	file, err := os.Open(NamespacePath(pid, namespace))
+	if err != nil {
+		return fmt.Errorf("open %s namespace of process %s error: %v", namespace, pid, err)
+	}
+	defer file.Close()
+	if err := syscall.Setns(int(file.Fd()), 0); err != nil {
+		return fmt.Errorf("join %s namespace of process %s error: %v", namespace, pid, err)
+	}
+	return nil
+}